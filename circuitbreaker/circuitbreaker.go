@@ -18,8 +18,12 @@
 package circuitbreaker
 
 import (
+	"context"
+	"errors"
+	"github.com/chanjarster/gears/event"
 	gtime "github.com/chanjarster/gears/util/time"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -39,83 +43,385 @@ type Interface interface {
 	Do(task func() error, onError func(error), onOpen func())
 }
 
-//---------------------------
-// 同步的CircuitBreaker
-//---------------------------
+// ContextInterface circuit breaker that passes a context to the task
+type ContextInterface interface {
+	Interface
+
+	// DoCtx same as Do, task should return when ctx is done.
+	// If the circuit breaker has a timeout, ctx passed to task is done after the timeout,
+	// and onError is called with context.DeadlineExceeded without waiting for task returning.
+	DoCtx(ctx context.Context, task func(ctx context.Context) error, onError func(error), onOpen func())
+}
+
+// State of circuit breaker
+type State int
+
+const (
+	StateClosed   State = iota // 闭合
+	StateOpen                  // 断开
+	StateHalfOpen              // 半开
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
 
-type state int
+// Outcome how a call is counted by circuit breaker
+type Outcome int
 
 const (
-	open     state = iota // 断开
-	closed   state = iota // 闭合
-	halfOpen state = iota // 半开
+	OutcomeSuccess Outcome = iota // 成功
+	OutcomeFailure                // 失败
+	OutcomeIgnored                // 既不算成功也不算失败
 )
 
+// Ignored wrap err returned by task, so that the call is counted as neither success nor failure,
+// e.g. the caller gives up, but the task can't tell the circuit breaker through ctx.
+// onError is called with err itself.
+func Ignored(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ignoredError{err: err}
+}
+
+type ignoredError struct {
+	err error
+}
+
+func (e *ignoredError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ignoredError) Unwrap() error {
+	return e.err
+}
+
+//---------------------------
+// 同步的CircuitBreaker
+//---------------------------
+
 // failureThreshold: once the failures reach this threshold, the circuit breaker will be opened
 //
 // resetTimeout: if circuit breaker is in state of open,
 // after this timeout, the circuit breaker will be in half-open state
 func NewSyncCircuitBreaker(failureThreshold int, resetTimeout time.Duration) *SyncCircuitBreaker {
 	return &SyncCircuitBreaker{
-		failureThreshold: failureThreshold,
-		resetTimeout:     int64(resetTimeout),
-		nowFn:            gtime.SysNow,
+		failureThreshold:     failureThreshold,
+		resetTimeout:         int64(resetTimeout),
+		halfOpenMaxCalls:     1,
+		halfOpenSuccessCalls: 1,
+		nowFn:                gtime.SysNow,
+	}
+}
+
+// NewCountBasedCircuitBreaker New a SyncCircuitBreaker opens when failure rate of the last windowSize calls
+// reaches failureRateThreshold.
+//
+//	windowSize: number of last calls to calculate failure rate
+//	minimumCalls: failure rate is not calculated until there are this many calls in the window
+//	failureRateThreshold: in (0, 1], once the failure rate reaches it, the circuit breaker will be opened
+//	resetTimeout: if circuit breaker is in state of open, after this timeout, the circuit breaker will be in half-open state
+func NewCountBasedCircuitBreaker(windowSize, minimumCalls int, failureRateThreshold float64, resetTimeout time.Duration) *SyncCircuitBreaker {
+	return newRateCircuitBreaker(newCountWindow(windowSize), minimumCalls, failureRateThreshold, resetTimeout)
+}
+
+// NewTimeBasedCircuitBreaker New a SyncCircuitBreaker opens when failure rate of calls in the last windowSize
+// reaches failureRateThreshold.
+//
+//	windowSize: time range to calculate failure rate, with precision of windowSize / 10
+//	minimumCalls: failure rate is not calculated until there are this many calls in the window
+//	failureRateThreshold: in (0, 1], once the failure rate reaches it, the circuit breaker will be opened
+//	resetTimeout: if circuit breaker is in state of open, after this timeout, the circuit breaker will be in half-open state
+func NewTimeBasedCircuitBreaker(windowSize time.Duration, minimumCalls int, failureRateThreshold float64, resetTimeout time.Duration) *SyncCircuitBreaker {
+	return newRateCircuitBreaker(newTimeWindow(int64(windowSize)), minimumCalls, failureRateThreshold, resetTimeout)
+}
+
+func newRateCircuitBreaker(window failureWindow, minimumCalls int, failureRateThreshold float64, resetTimeout time.Duration) *SyncCircuitBreaker {
+	s := NewSyncCircuitBreaker(0, resetTimeout)
+	s.failureRate = &failureRate{
+		window:       window,
+		minimumCalls: minimumCalls,
+		threshold:    failureRateThreshold,
 	}
+	return s
 }
 
+// SyncCircuitBreaker a state machine:
+//
+//	closed --(failures reach threshold)--> open --(after resetTimeout)--> half-open
+//	half-open --(halfOpenSuccessCalls successes)--> closed
+//	half-open --(any failure)--> open
+//
+// In half-open state, at most halfOpenMaxCalls trial calls are executing at the same time, others are rejected as open.
+// If no trial call returns for resetTimeout, they are given up and new trial calls are permitted.
 type SyncCircuitBreaker struct {
-	lock             sync.RWMutex
-	failureThreshold int           // 多少次失败之后就断开
-	lastFailureTs    int64         // 上次失败的时间戳
-	failures         int           // 失败次数
-	resetTimeout     int64         // 当断开之后多久，把断路器重置到half-open状态
-	nowFn            gtime.NowFunc // 获得当前时间的函数
+	name                 string
+	lock                 sync.Mutex
+	state                State
+	generation           uint64             // 每次状态转换都加1，用来丢弃状态转换之前开始的调用的结果
+	openedAt             int64              // 断开的时间戳
+	failureThreshold     int                // 多少次连续失败之后就断开
+	failures             int                // closed状态下的连续失败次数
+	failureRate          *failureRate       // 不为nil时，按照失败率断开，而不是连续失败次数
+	resetTimeout         int64              // 当断开之后多久，把断路器重置到half-open状态
+	halfOpenMaxCalls     int                // half-open状态下最多同时进行的试探调用数
+	halfOpenSuccessCalls int                // half-open状态下成功多少次之后闭合
+	halfOpenCalls        int                // half-open状态下正在进行的试探调用数
+	halfOpenSuccesses    int                // half-open状态下的成功次数
+	halfOpenActiveAt     int64              // half-open状态下最近一次开始试探或者试探调用返回的时间戳
+	slowCallDuration     int64              // 调用耗时超过它就算失败，<= 0 表示不检测慢调用
+	timeout              int64              // 调用超时时间，<= 0 表示不超时
+	classifier           Classifier         // 错误分类，nil表示所有错误都是失败
+	nowFn                gtime.NowFunc      // 获得当前时间的函数
+	pendingChanges       []StateChangeEvent // 持有锁期间发生的状态转换，释放锁之后通知
+	listenerLock         sync.RWMutex
+	listeners            []StateChangeListener
+	bus                  *event.FanOutBus
+	metrics              Metrics
+}
+
+// SetSlowCallDuration calls take longer than d are counted as failures even if they succeed,
+// onError is not called for them. d <= 0 disables slow call detection.
+func (s *SyncCircuitBreaker) SetSlowCallDuration(d time.Duration) {
+	atomic.StoreInt64(&s.slowCallDuration, int64(d))
+}
+
+// SetTimeout calls take longer than d are failed with context.DeadlineExceeded, d <= 0 disables timeout.
+func (s *SyncCircuitBreaker) SetTimeout(d time.Duration) {
+	atomic.StoreInt64(&s.timeout, int64(d))
+}
+
+// SetHalfOpen configure half-open state, values < 1 are treated as 1.
+//
+//	maxCalls: at most maxCalls trial calls are executing at the same time
+//	successCalls: after successCalls trial calls succeed, the circuit breaker will be closed
+func (s *SyncCircuitBreaker) SetHalfOpen(maxCalls, successCalls int) {
+	if maxCalls < 1 {
+		maxCalls = 1
+	}
+	if successCalls < 1 {
+		successCalls = 1
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.halfOpenMaxCalls = maxCalls
+	s.halfOpenSuccessCalls = successCalls
+}
+
+// State current state, open turns into half-open if resetTimeout elapsed
+func (s *SyncCircuitBreaker) State() State {
+	now := s.nowFn().UnixNano()
+	s.lock.Lock()
+	defer s.unlock()
+
+	s.tryHalfOpen(now)
+	return s.state
 }
 
 func (s *SyncCircuitBreaker) Do(task func() error, onError func(error), onOpen func()) {
-	switch s.state() {
-	case open:
+	s.DoCtx(context.Background(), func(ctx context.Context) error {
+		return task()
+	}, onError, onOpen)
+}
+
+// DoCtx if ctx is canceled or its deadline is exceeded, the call is counted as neither success nor failure,
+// because it's the caller who gives up.
+// onError is called for all errors, no matter how they are classified.
+func (s *SyncCircuitBreaker) DoCtx(ctx context.Context, task func(ctx context.Context) error, onError func(error), onOpen func()) {
+	generation, permitted := s.acquire()
+	if !permitted {
+		atomic.AddInt64(&s.metrics.Rejections, 1)
 		onOpen()
-	case closed, halfOpen:
-		if err := task(); err != nil {
-			s.recordFailure()
-			onError(err)
-		} else {
-			s.reset()
+		return
+	}
+
+	o, err := s.call(ctx, task)
+	s.complete(generation, o)
+	if err != nil {
+		onError(err)
+	}
+}
+
+// call task and count it in metrics, return its outcome and error
+func (s *SyncCircuitBreaker) call(ctx context.Context, task func(ctx context.Context) error) (Outcome, error) {
+	start := s.nowFn().UnixNano()
+	err := s.run(ctx, task)
+	elapsed := s.nowFn().UnixNano() - start
+
+	o := s.classify(ctx, err)
+	if ie, ok := err.(*ignoredError); ok {
+		err = ie.err
+	}
+	if slow := atomic.LoadInt64(&s.slowCallDuration); slow > 0 && elapsed > slow {
+		atomic.AddInt64(&s.metrics.SlowCalls, 1)
+		if o == OutcomeSuccess {
+			o = OutcomeFailure
 		}
+	}
+	atomic.AddInt64(&s.metrics.Calls, 1)
+	if o == OutcomeFailure {
+		atomic.AddInt64(&s.metrics.Failures, 1)
+	}
+	return o, err
+}
+
+// classify result of task, error caused by ctx of the caller being done is ignored
+func (s *SyncCircuitBreaker) classify(ctx context.Context, err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case isIgnored(err):
+		return OutcomeIgnored
+	case ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)):
+		return OutcomeIgnored
+	case s.classifier != nil:
+		return s.classifier(err)
 	default:
-		panic("Unreachable code")
+		return OutcomeFailure
 	}
 }
 
-func (s *SyncCircuitBreaker) recordFailure() {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+func isIgnored(err error) bool {
+	var ie *ignoredError
+	return errors.As(err, &ie)
+}
+
+// run task with timeout, return context.DeadlineExceeded once timeout even if task doesn't return
+func (s *SyncCircuitBreaker) run(ctx context.Context, task func(ctx context.Context) error) error {
+	timeout := atomic.LoadInt64(&s.timeout)
+	if timeout <= 0 {
+		return task(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout))
+	defer cancel()
 
-	s.failures++
-	s.lastFailureTs = s.nowFn().UnixNano()
+	done := make(chan error, 1)
+	go func() {
+		done <- task(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (s *SyncCircuitBreaker) state() state {
-	now := s.nowFn()
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+// acquire permission to execute a call, return generation of the state to complete the call
+func (s *SyncCircuitBreaker) acquire() (generation uint64, permitted bool) {
+	now := s.nowFn().UnixNano()
+	s.lock.Lock()
+	defer s.unlock()
 
-	if s.failures >= s.failureThreshold && (now.UnixNano()-s.lastFailureTs) > s.resetTimeout {
-		return halfOpen
-	} else if s.failures >= s.failureThreshold {
-		return open
-	} else {
-		return closed
+	s.tryHalfOpen(now)
+	switch s.state {
+	case StateClosed:
+		return s.generation, true
+	case StateHalfOpen:
+		if s.halfOpenCalls >= s.halfOpenMaxCalls {
+			if now-s.halfOpenActiveAt <= s.resetTimeout {
+				return 0, false
+			}
+			// 试探调用一直没有返回，放弃它们，重新开始试探
+			s.generation++
+			s.halfOpenCalls = 0
+			s.halfOpenSuccesses = 0
+			s.halfOpenActiveAt = now
+		}
+		s.halfOpenCalls++
+		return s.generation, true
+	default:
+		return 0, false
 	}
 }
 
-func (s *SyncCircuitBreaker) reset() {
+// complete a call, its outcome is discarded if state has been changed since it was acquired
+func (s *SyncCircuitBreaker) complete(generation uint64, o Outcome) {
+	now := s.nowFn().UnixNano()
 	s.lock.Lock()
-	defer s.lock.Unlock()
+	defer s.unlock()
+
+	if generation != s.generation {
+		return
+	}
 
+	switch s.state {
+	case StateClosed:
+		switch o {
+		case OutcomeSuccess:
+			if s.failureRate != nil {
+				s.failureRate.window.record(now, false)
+			} else {
+				s.failures = 0
+			}
+		case OutcomeFailure:
+			if s.failureRate != nil {
+				s.failureRate.window.record(now, true)
+				if s.failureRate.exceeded(now) {
+					s.transition(StateOpen, now)
+				}
+			} else {
+				s.failures++
+				if s.failures >= s.failureThreshold {
+					s.transition(StateOpen, now)
+				}
+			}
+		}
+	case StateHalfOpen:
+		s.halfOpenCalls--
+		s.halfOpenActiveAt = now
+		switch o {
+		case OutcomeSuccess:
+			s.halfOpenSuccesses++
+			if s.halfOpenSuccesses >= s.halfOpenSuccessCalls {
+				s.transition(StateClosed, now)
+			}
+		case OutcomeFailure:
+			s.transition(StateOpen, now)
+		}
+	}
+}
+
+// open turns into half-open if resetTimeout elapsed, must hold s.lock
+func (s *SyncCircuitBreaker) tryHalfOpen(now int64) {
+	if s.state == StateOpen && now-s.openedAt > s.resetTimeout {
+		s.transition(StateHalfOpen, now)
+	}
+}
+
+// must hold s.lock
+func (s *SyncCircuitBreaker) transition(to State, now int64) {
+	s.pendingChanges = append(s.pendingChanges, StateChangeEvent{
+		Name: s.name,
+		From: s.state,
+		To:   to,
+		Time: time.Unix(0, now),
+	})
+	s.state = to
+	s.generation++
 	s.failures = 0
-	s.lastFailureTs = 0
+	s.halfOpenCalls = 0
+	s.halfOpenSuccesses = 0
+	if s.failureRate != nil {
+		s.failureRate.window.reset()
+	}
+	switch to {
+	case StateOpen:
+		s.openedAt = now
+	case StateHalfOpen:
+		s.halfOpenActiveAt = now
+	}
 }
 
 //---------------------------
@@ -132,3 +438,9 @@ func (n neverOpen) Do(task func() error, onError func(error), onOpen func()) {
 		onError(err)
 	}
 }
+
+func (n neverOpen) DoCtx(ctx context.Context, task func(ctx context.Context) error, onError func(error), onOpen func()) {
+	if err := task(ctx); err != nil {
+		onError(err)
+	}
+}
@@ -0,0 +1,453 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"context"
+	"github.com/chanjarster/gears/simplelog"
+	gtime "github.com/chanjarster/gears/util/time"
+	"github.com/go-redis/redis/v7"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	redisPrefix = "_cb:"
+
+	// state is stored in a hash:
+	//
+	//	st: state, 0 closed, 1 open, 2 half-open
+	//	gen: generation, increased on every transition
+	//	fails: consecutive failures in closed state
+	//	since: timestamp(milliseconds) of last transition
+	//	hc: executing trial calls in half-open state
+	//	hs: succeeded trial calls in half-open state
+	//	ha: timestamp(milliseconds) when trial calls started or a trial call returned in half-open state
+	//
+	// trial calls that never return (e.g. the replica crashed) are given up after resetTimeout,
+	// same as SyncCircuitBreaker.
+	//
+	// the hash expires after ttl(milliseconds) since last call of the scripts.
+	//
+	// return permitted, st, gen, fails, since
+	acquireScript = `local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local reset = tonumber(ARGV[2])
+local maxc = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local v = redis.call('HMGET', key, 'st', 'gen', 'fails', 'since', 'hc', 'ha')
+local st = tonumber(v[1]) or 0
+local gen = tonumber(v[2]) or 0
+local fails = tonumber(v[3]) or 0
+local since = tonumber(v[4]) or 0
+local hc = tonumber(v[5]) or 0
+local ha = tonumber(v[6]) or since
+
+if st == 1 and now - since > reset
+then
+  st = 2
+  gen = gen + 1
+  fails = 0
+  since = now
+  hc = 0
+  redis.call('HMSET', key, 'st', st, 'gen', gen, 'fails', 0, 'since', since, 'hc', 0, 'hs', 0, 'ha', now)
+elseif st == 2 and hc >= maxc and now - ha > reset
+then
+  gen = gen + 1
+  hc = 0
+  redis.call('HMSET', key, 'gen', gen, 'hc', 0, 'hs', 0, 'ha', now)
+end
+redis.call('PEXPIRE', key, ttl)
+
+if st == 0
+then
+  return {1, st, gen, fails, since}
+end
+if st == 2 and hc < maxc
+then
+  redis.call('HINCRBY', key, 'hc', 1)
+  return {1, st, gen, fails, since}
+end
+return {0, st, gen, fails, since}
+`
+
+	// outcome: 0 success, 1 failure, 2 ignored, discarded if gen doesn't match
+	//
+	// return st, gen, fails, since
+	completeScript = `local key = KEYS[1]
+local cgen = tonumber(ARGV[1])
+local outcome = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local threshold = tonumber(ARGV[4])
+local succ = tonumber(ARGV[5])
+local ttl = tonumber(ARGV[6])
+
+local v = redis.call('HMGET', key, 'st', 'gen', 'fails', 'since', 'hc')
+local st = tonumber(v[1]) or 0
+local gen = tonumber(v[2]) or 0
+local fails = tonumber(v[3]) or 0
+local since = tonumber(v[4]) or 0
+local hc = tonumber(v[5]) or 0
+
+if cgen ~= gen
+then
+  redis.call('PEXPIRE', key, ttl)
+  return {st, gen, fails, since}
+end
+
+local to = -1
+if st == 0
+then
+  if outcome == 0 and fails > 0
+  then
+    fails = 0
+    redis.call('HSET', key, 'fails', 0)
+  elseif outcome == 1
+  then
+    fails = redis.call('HINCRBY', key, 'fails', 1)
+    if fails >= threshold
+    then
+      to = 1
+    end
+  end
+elseif st == 2
+then
+  if hc > 0
+  then
+    redis.call('HINCRBY', key, 'hc', -1)
+  end
+  redis.call('HSET', key, 'ha', now)
+  if outcome == 0
+  then
+    if redis.call('HINCRBY', key, 'hs', 1) >= succ
+    then
+      to = 0
+    end
+  elseif outcome == 1
+  then
+    to = 1
+  end
+end
+
+if to >= 0
+then
+  st = to
+  gen = gen + 1
+  fails = 0
+  since = now
+  redis.call('HMSET', key, 'st', st, 'gen', gen, 'fails', 0, 'since', since, 'hc', 0, 'hs', 0)
+end
+redis.call('PEXPIRE', key, ttl)
+return {st, gen, fails, since}
+`
+)
+
+var (
+	acquireScriptSha  = ""
+	completeScriptSha = ""
+)
+
+// LoadScript load lua scripts of RedisCircuitBreaker, it panics if failed
+func LoadScript(redisClient *redis.Client) {
+	acquireScriptSha = loadScript(redisClient, acquireScript)
+	completeScriptSha = loadScript(redisClient, completeScript)
+}
+
+func loadScript(redisClient *redis.Client, script string) string {
+	sha, err := redisClient.ScriptLoad(script).Result()
+	if err != nil {
+		panic(err)
+	}
+	return sha
+}
+
+//---------------------------
+// 状态保存在Redis的CircuitBreaker
+//---------------------------
+
+// NewRedisCircuitBreaker New a circuit breaker whose state is stored in redis, all instances of same name
+// share the same state, so all replicas of a service open and probe together.
+//
+// Call LoadScript before using it.
+//
+//	name: circuit breaker's name, see WithName
+//	opts: same as NewCircuitBreaker, except failures are always counted as consecutive failures,
+//	  WithCountWindow and WithTimeWindow only apply when redis is unavailable
+func NewRedisCircuitBreaker(redisClient *redis.Client, name string, opts ...Option) *RedisCircuitBreaker {
+	return newRedisCircuitBreaker(redisClient, name, "", opts)
+}
+
+// NewRedisCircuitBreakerCluster New a RedisCircuitBreaker for Redis Cluster environment.
+//
+//	hashTag: redis hash tag value, helps to ensure all keys be in the same slot.
+//
+// see: https://redis.io/topics/cluster-tutorial#redis-cluster-data-sharding
+func NewRedisCircuitBreakerCluster(redisClient *redis.Client, name string, hashTag string, opts ...Option) *RedisCircuitBreaker {
+	return newRedisCircuitBreaker(redisClient, name, formatHashTag(hashTag), opts)
+}
+
+func newRedisCircuitBreaker(redisClient *redis.Client, name string, hashTag string, opts []Option) *RedisCircuitBreaker {
+	o := newOptions(opts)
+	o.name = name
+	local := o.newSyncCircuitBreaker()
+	return &RedisCircuitBreaker{
+		redisClient:          redisClient,
+		key:                  redisPrefix + name + hashTag,
+		local:                local,
+		failureThreshold:     o.failureThreshold,
+		resetTimeout:         int64(o.resetTimeout),
+		halfOpenMaxCalls:     local.halfOpenMaxCalls,
+		halfOpenSuccessCalls: local.halfOpenSuccessCalls,
+		syncInterval:         int64(o.syncInterval),
+		keyTtl:               redisKeyTtl(o.resetTimeout, o.syncInterval),
+		redisTimeout:         o.redisTimeout,
+		nowFn:                gtime.SysNow,
+	}
+}
+
+// the hash of state expires if no replica calls redis for twice of resetTimeout and syncInterval,
+// then it's closed with no failures
+func redisKeyTtl(resetTimeout, syncInterval time.Duration) int64 {
+	ttl := 2 * resetTimeout
+	if syncInterval > resetTimeout {
+		ttl = 2 * syncInterval
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return int64(ttl / time.Millisecond)
+}
+
+// wrap hashTag with "{}"
+func formatHashTag(hashTag string) string {
+	return "{" + strings.Trim(hashTag, "{}") + "}"
+}
+
+// RedisCircuitBreaker same state machine as SyncCircuitBreaker, but state is stored in redis.
+//
+// To avoid calling redis for every call, state is cached locally:
+// in closed state, calls are permitted without calling redis, and state is synced at most once every syncInterval;
+// in open state, calls are rejected without calling redis until resetTimeout elapsed;
+// successes in closed state are not sent to redis if there were no failures when synced less than syncInterval ago.
+// So other replicas may notice a transition up to syncInterval later. Failures are always sent to redis.
+//
+// State in redis expires if no replica calls redis for twice of max(resetTimeout, syncInterval).
+//
+// If redis is unavailable, a local SyncCircuitBreaker created with the same options decides instead.
+//
+// StateChangeListener is called on every replica when it notices the transition.
+type RedisCircuitBreaker struct {
+	redisClient          *redis.Client
+	key                  string
+	local                *SyncCircuitBreaker // 执行调用、统计、通知，Redis不可用时由它决定
+	failureThreshold     int
+	resetTimeout         int64
+	halfOpenMaxCalls     int
+	halfOpenSuccessCalls int
+	syncInterval         int64 // closed状态下多久从Redis同步一次状态
+	keyTtl               int64 // Redis中状态的过期时间，毫秒
+	redisTimeout         time.Duration
+	nowFn                gtime.NowFunc
+	lock                 sync.Mutex
+	cache                redisState // 最近一次从Redis得到的状态
+	syncedAt             int64      // 最近一次从Redis得到状态的时间戳
+}
+
+// state stored in redis
+type redisState struct {
+	state      State
+	generation uint64
+	failures   int
+	since      int64 // 最近一次状态转换的时间戳
+}
+
+// Name of the circuit breaker
+func (r *RedisCircuitBreaker) Name() string {
+	return r.local.Name()
+}
+
+// OnStateChange see SyncCircuitBreaker.OnStateChange
+func (r *RedisCircuitBreaker) OnStateChange(listener StateChangeListener) {
+	r.local.OnStateChange(listener)
+}
+
+// Metrics snapshot of counters of this replica
+func (r *RedisCircuitBreaker) Metrics() Metrics {
+	return r.local.Metrics()
+}
+
+// State last known state, open turns into half-open if resetTimeout elapsed
+func (r *RedisCircuitBreaker) State() State {
+	now := r.nowFn().UnixNano()
+	r.lock.Lock()
+	cache := r.cache
+	r.lock.Unlock()
+
+	if cache.state == StateOpen && now-cache.since > r.resetTimeout {
+		return StateHalfOpen
+	}
+	return cache.state
+}
+
+func (r *RedisCircuitBreaker) Do(task func() error, onError func(error), onOpen func()) {
+	r.DoCtx(context.Background(), func(ctx context.Context) error {
+		return task()
+	}, onError, onOpen)
+}
+
+// DoCtx see SyncCircuitBreaker.DoCtx
+func (r *RedisCircuitBreaker) DoCtx(ctx context.Context, task func(ctx context.Context) error, onError func(error), onOpen func()) {
+	generation, permitted, err := r.acquire(ctx)
+	if err != nil {
+		simplelog.ErrLogger.Println("circuitbreaker[", r.Name(), "]: eval acquire script", acquireScriptSha, "error", err)
+		r.local.DoCtx(ctx, task, onError, onOpen)
+		return
+	}
+	if !permitted {
+		atomic.AddInt64(&r.local.metrics.Rejections, 1)
+		onOpen()
+		return
+	}
+
+	o, err := r.local.call(ctx, task)
+	r.complete(generation, o)
+	if err != nil {
+		onError(err)
+	}
+}
+
+// acquire permission to execute a call, call redis only if the cached state is not enough to decide
+func (r *RedisCircuitBreaker) acquire(ctx context.Context) (generation uint64, permitted bool, err error) {
+	now := r.nowFn().UnixNano()
+	r.lock.Lock()
+	cache, syncedAt := r.cache, r.syncedAt
+	r.lock.Unlock()
+
+	switch {
+	case cache.state == StateClosed && now-syncedAt < r.syncInterval:
+		return cache.generation, true, nil
+	case cache.state == StateOpen && now-cache.since <= r.resetTimeout:
+		return 0, false, nil
+	}
+
+	ctx, cancel := r.redisContext(ctx)
+	defer cancel()
+
+	//local now = tonumber(ARGV[1])
+	//local reset = tonumber(ARGV[2])
+	//local maxc = tonumber(ARGV[3])
+	//local ttl = tonumber(ARGV[4])
+	raw, err := r.redisClient.WithContext(ctx).EvalSha(
+		acquireScriptSha,
+		[]string{r.key},
+		now/int64(time.Millisecond),
+		r.resetTimeout/int64(time.Millisecond),
+		r.halfOpenMaxCalls,
+		r.keyTtl,
+	).Result()
+	if err != nil {
+		return 0, false, err
+	}
+
+	arr := raw.([]interface{})
+	state := parseRedisState(arr[1:])
+	r.update(state, now)
+	return state.generation, arr[0].(int64) == 1, nil
+}
+
+// complete a call, call redis only if the outcome may change the state, failures are always sent to redis
+func (r *RedisCircuitBreaker) complete(generation uint64, o Outcome) {
+	now := r.nowFn().UnixNano()
+	r.lock.Lock()
+	cache, syncedAt := r.cache, r.syncedAt
+	r.lock.Unlock()
+
+	if cache.state == StateClosed && generation == cache.generation {
+		switch {
+		case o == OutcomeIgnored:
+			return
+		case o == OutcomeSuccess && cache.failures == 0 && now-syncedAt < r.syncInterval:
+			// no failures to reset as of recent sync
+			return
+		}
+	}
+
+	// ctx of the call may be done, but its outcome should still be recorded
+	ctx, cancel := r.redisContext(context.Background())
+	defer cancel()
+
+	//local cgen = tonumber(ARGV[1])
+	//local outcome = tonumber(ARGV[2])
+	//local now = tonumber(ARGV[3])
+	//local threshold = tonumber(ARGV[4])
+	//local succ = tonumber(ARGV[5])
+	//local ttl = tonumber(ARGV[6])
+	raw, err := r.redisClient.WithContext(ctx).EvalSha(
+		completeScriptSha,
+		[]string{r.key},
+		generation,
+		int(o),
+		now/int64(time.Millisecond),
+		r.failureThreshold,
+		r.halfOpenSuccessCalls,
+		r.keyTtl,
+	).Result()
+	if err != nil {
+		simplelog.ErrLogger.Println("circuitbreaker[", r.Name(), "]: eval complete script", completeScriptSha, "error", err)
+		return
+	}
+	r.update(parseRedisState(raw.([]interface{})), now)
+}
+
+// update cached state, notify if state changes
+func (r *RedisCircuitBreaker) update(state redisState, now int64) {
+	r.lock.Lock()
+	from := r.cache.state
+	r.cache = state
+	r.syncedAt = now
+	r.lock.Unlock()
+
+	if from != state.state {
+		r.local.notify(StateChangeEvent{
+			Name: r.Name(),
+			From: from,
+			To:   state.state,
+			Time: time.Unix(0, now),
+		})
+	}
+}
+
+func (r *RedisCircuitBreaker) redisContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.redisTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.redisTimeout)
+}
+
+// parse {st, gen, fails, since} returned by scripts
+func parseRedisState(arr []interface{}) redisState {
+	return redisState{
+		state:      State(arr[0].(int64)),
+		generation: uint64(arr[1].(int64)),
+		failures:   int(arr[2].(int64)),
+		since:      arr[3].(int64) * int64(time.Millisecond),
+	}
+}
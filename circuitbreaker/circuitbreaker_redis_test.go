@@ -0,0 +1,267 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+	"testing"
+	"time"
+)
+
+// run scripts on an in-process miniredis, no live redis is needed
+func newMiniRedisClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		redisClient.Close()
+	})
+	LoadScript(redisClient)
+	return mr, redisClient
+}
+
+func TestRedisCircuitBreaker(t *testing.T) {
+
+	mr, redisClient := newMiniRedisClient(t)
+
+	errFoo := errors.New("foo")
+	fail := func() error { return errFoo }
+	succeed := func() error { return nil }
+	noop := func(error) {}
+
+	t.Run("open together", func(t *testing.T) {
+		a := NewRedisCircuitBreaker(redisClient, "open", WithFailureThreshold(2), WithSyncInterval(0))
+		var changes []State
+		b := NewRedisCircuitBreaker(redisClient, "open", WithFailureThreshold(2), WithSyncInterval(0),
+			WithStateChangeListener(func(name string, from, to State) {
+				changes = append(changes, to)
+			}))
+
+		b.Do(succeed, noop, func() {})
+		a.Do(fail, noop, func() {})
+		a.Do(fail, noop, func() {})
+		if got := a.State(); got != StateOpen {
+			t.Errorf("a.State() = %v, want %v", got, StateOpen)
+		}
+
+		opened := false
+		b.Do(succeed, noop, func() { opened = true })
+		if !opened {
+			t.Errorf("b.Do() not rejected after a opened")
+		}
+		if len(changes) != 1 || changes[0] != StateOpen {
+			t.Errorf("changes = %v, want [open]", changes)
+		}
+		if got := b.Metrics().Rejections; got != 1 {
+			t.Errorf("b.Metrics().Rejections = %v, want 1", got)
+		}
+	})
+
+	t.Run("local cache", func(t *testing.T) {
+		a := NewRedisCircuitBreaker(redisClient, "cache", WithFailureThreshold(1), WithSyncInterval(0))
+		b := NewRedisCircuitBreaker(redisClient, "cache", WithFailureThreshold(1), WithSyncInterval(time.Hour))
+
+		b.Do(succeed, noop, func() {})
+		a.Do(fail, noop, func() {})
+
+		// b is not synced yet
+		if got := b.State(); got != StateClosed {
+			t.Errorf("b.State() = %v, want %v", got, StateClosed)
+		}
+		opened := false
+		b.Do(fail, noop, func() { opened = true })
+		if opened {
+			t.Errorf("b.Do() rejected before synced")
+		}
+		// failure of b is sent to redis, and it gets the latest state
+		if got := b.State(); got != StateOpen {
+			t.Errorf("b.State() = %v, want %v", got, StateOpen)
+		}
+	})
+
+	t.Run("probe together", func(t *testing.T) {
+		opts := []Option{WithFailureThreshold(1), WithResetTimeout(50 * time.Millisecond), WithHalfOpen(1, 1)}
+		a := NewRedisCircuitBreaker(redisClient, "probe", opts...)
+		b := NewRedisCircuitBreaker(redisClient, "probe", opts...)
+
+		a.Do(fail, noop, func() {})
+		time.Sleep(60 * time.Millisecond)
+
+		generation, permitted, err := a.acquire(context.Background())
+		if !permitted || err != nil {
+			t.Fatalf("a.acquire() = %v, %v, want true, nil", permitted, err)
+		}
+		if _, permitted, _ := b.acquire(context.Background()); permitted {
+			t.Errorf("b.acquire() = %v, want false", permitted)
+		}
+		if got := b.State(); got != StateHalfOpen {
+			t.Errorf("b.State() = %v, want %v", got, StateHalfOpen)
+		}
+
+		a.complete(generation, OutcomeSuccess)
+		if got := a.State(); got != StateClosed {
+			t.Errorf("a.State() = %v, want %v", got, StateClosed)
+		}
+		if _, permitted, _ := b.acquire(context.Background()); !permitted {
+			t.Errorf("b.acquire() = %v, want true", permitted)
+		}
+		if got := b.State(); got != StateClosed {
+			t.Errorf("b.State() = %v, want %v", got, StateClosed)
+		}
+	})
+
+	t.Run("abandoned probe", func(t *testing.T) {
+		opts := []Option{WithFailureThreshold(1), WithResetTimeout(50 * time.Millisecond)}
+		a := NewRedisCircuitBreaker(redisClient, "abandoned", opts...)
+		b := NewRedisCircuitBreaker(redisClient, "abandoned", opts...)
+
+		a.Do(fail, noop, func() {})
+		time.Sleep(60 * time.Millisecond)
+		// a never completes its trial call
+		a.acquire(context.Background())
+		if _, permitted, _ := b.acquire(context.Background()); permitted {
+			t.Errorf("b.acquire() = %v, want false", permitted)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if _, permitted, _ := b.acquire(context.Background()); !permitted {
+			t.Errorf("b.acquire() = %v, want true", permitted)
+		}
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		a := NewRedisCircuitBreaker(redisClient, "ignored", WithFailureThreshold(2), WithIgnoredErrors(ErrorIs(errFoo)))
+		a.Do(fail, noop, func() {})
+		a.Do(fail, noop, func() {})
+		if got := redisClient.HGet(redisPrefix+"ignored", "fails").Val(); got != "" {
+			t.Errorf("fails = %q, want %q", got, "")
+		}
+		if got := a.State(); got != StateClosed {
+			t.Errorf("a.State() = %v, want %v", got, StateClosed)
+		}
+	})
+
+	t.Run("give up trial calls since last one returned", func(t *testing.T) {
+		clock := nowTs
+		nowFn := func() time.Time {
+			return clock
+		}
+		opts := []Option{WithFailureThreshold(1), WithResetTimeout(time.Second), WithHalfOpen(2, 2)}
+		a := NewRedisCircuitBreaker(redisClient, "give-up", opts...)
+		b := NewRedisCircuitBreaker(redisClient, "give-up", opts...)
+		a.nowFn, b.nowFn = nowFn, nowFn
+
+		a.Do(fail, noop, func() {})
+		clock = clock.Add(time.Second + time.Millisecond)
+
+		// a never completes its trial calls, b completes one
+		a.acquire(context.Background())
+		generation, _, _ := b.acquire(context.Background())
+		clock = clock.Add(500 * time.Millisecond)
+		b.complete(generation, OutcomeSuccess)
+		a.acquire(context.Background())
+
+		// resetTimeout since trial calls started, but not since the last one returned
+		clock = clock.Add(700 * time.Millisecond)
+		if _, permitted, _ := b.acquire(context.Background()); permitted {
+			t.Errorf("b.acquire() = %v, want false", permitted)
+		}
+		clock = clock.Add(400 * time.Millisecond)
+		if _, permitted, _ := b.acquire(context.Background()); !permitted {
+			t.Errorf("b.acquire() = %v, want true", permitted)
+		}
+		if got := b.State(); got != StateHalfOpen {
+			t.Errorf("b.State() = %v, want %v", got, StateHalfOpen)
+		}
+	})
+
+	t.Run("failures always sent", func(t *testing.T) {
+		a := NewRedisCircuitBreaker(redisClient, "failures", WithFailureThreshold(2), WithSyncInterval(0))
+		b := NewRedisCircuitBreaker(redisClient, "failures", WithFailureThreshold(2), WithSyncInterval(time.Hour))
+
+		// b caches closed with no failures
+		b.Do(succeed, noop, func() {})
+		a.Do(fail, noop, func() {})
+		b.Do(fail, noop, func() {})
+		if got := a.State(); got != StateClosed {
+			t.Errorf("a.State() = %v, want %v", got, StateClosed)
+		}
+		if got := b.State(); got != StateOpen {
+			t.Errorf("b.State() = %v, want %v", got, StateOpen)
+		}
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		a := NewRedisCircuitBreaker(redisClient, "expire", WithFailureThreshold(1),
+			WithResetTimeout(time.Minute), WithSyncInterval(time.Second))
+		a.Do(fail, noop, func() {})
+		if got, want := mr.TTL(redisPrefix+"expire"), 2*time.Minute; got != want {
+			t.Errorf("TTL() = %v, want %v", got, want)
+		}
+
+		// no replica calls redis, it's closed again
+		mr.FastForward(2 * time.Minute)
+		b := NewRedisCircuitBreaker(redisClient, "expire", WithFailureThreshold(1))
+		permitted := true
+		b.Do(succeed, noop, func() { permitted = false })
+		if !permitted {
+			t.Errorf("b.Do() rejected after state expired")
+		}
+	})
+
+	t.Run("cluster", func(t *testing.T) {
+		a := NewRedisCircuitBreakerCluster(redisClient, "cluster", "foo", WithFailureThreshold(1))
+		a.Do(fail, noop, func() {})
+		if got := redisClient.HGet(redisPrefix+"cluster{foo}", "st").Val(); got != "1" {
+			t.Errorf("st = %q, want %q", got, "1")
+		}
+	})
+
+}
+
+func TestRedisCircuitBreaker_redisUnavailable(t *testing.T) {
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:1", MaxRetries: -1})
+	defer redisClient.Close()
+
+	a := NewRedisCircuitBreaker(redisClient, "unavailable", WithFailureThreshold(1))
+
+	var gotErr error
+	a.Do(func() error {
+		return errors.New("foo")
+	}, func(err error) {
+		gotErr = err
+	}, func() {})
+	if gotErr == nil || gotErr.Error() != "foo" {
+		t.Errorf("onError() got %v, want foo", gotErr)
+	}
+
+	// degrade to local state
+	opened := false
+	a.Do(func() error {
+		return nil
+	}, func(err error) {}, func() {
+		opened = true
+	})
+	if !opened {
+		t.Errorf("Do() not rejected when local state is open")
+	}
+	if got := a.local.State(); got != StateOpen {
+		t.Errorf("local.State() = %v, want %v", got, StateOpen)
+	}
+}
@@ -18,8 +18,11 @@
 package circuitbreaker
 
 import (
+	"context"
 	"errors"
 	gtime "github.com/chanjarster/gears/util/time"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -40,11 +43,17 @@ func TestNewSyncCircuitBreaker(t *testing.T) {
 	if got, want := breaker.resetTimeout, int64(10); got != want {
 		t.Errorf("resetTimeout = %v, want %v", got, want)
 	}
-	if got, want := breaker.lastFailureTs, int64(0); got != want {
-		t.Errorf("lastFailureTs = %v, want %v", got, want)
+	if got, want := breaker.state, StateClosed; got != want {
+		t.Errorf("state = %v, want %v", got, want)
 	}
 	if got, want := breaker.failures, 0; got != want {
-		t.Errorf("lastFailureTs = %v, want %v", got, want)
+		t.Errorf("failures = %v, want %v", got, want)
+	}
+	if got, want := breaker.halfOpenMaxCalls, 1; got != want {
+		t.Errorf("halfOpenMaxCalls = %v, want %v", got, want)
+	}
+	if got, want := breaker.halfOpenSuccessCalls, 1; got != want {
+		t.Errorf("halfOpenSuccessCalls = %v, want %v", got, want)
 	}
 	if got := breaker.nowFn; got == nil {
 		t.Errorf("nowFn is nil, want not nil")
@@ -54,13 +63,8 @@ func TestNewSyncCircuitBreaker(t *testing.T) {
 func TestSyncCircuitBreaker_Do(t *testing.T) {
 
 	t.Run("always success", func(t *testing.T) {
-		cb := &SyncCircuitBreaker{
-			lastFailureTs:    0,
-			failures:         0,
-			failureThreshold: 1,
-			resetTimeout:     1000,
-			nowFn:            gtime.FixedNow(nowTs),
-		}
+		cb := NewSyncCircuitBreaker(1, 1000)
+		cb.nowFn = gtime.FixedNow(nowTs)
 		successTimes := 0
 		onErrorTimes := 0
 		onOpenTimes := 0
@@ -89,13 +93,8 @@ func TestSyncCircuitBreaker_Do(t *testing.T) {
 	})
 
 	t.Run("success(close), failure(open)", func(t *testing.T) {
-		cb := &SyncCircuitBreaker{
-			lastFailureTs:    0,
-			failures:         0,
-			failureThreshold: 1,
-			resetTimeout:     1000,
-			nowFn:            gtime.FixedNow(nowTs),
-		}
+		cb := NewSyncCircuitBreaker(1, 1000)
+		cb.nowFn = gtime.FixedNow(nowTs)
 		successTimes := 0
 
 		onErrorTimes := 0
@@ -129,13 +128,8 @@ func TestSyncCircuitBreaker_Do(t *testing.T) {
 	})
 
 	t.Run("failure(open), success(open)", func(t *testing.T) {
-		cb := &SyncCircuitBreaker{
-			lastFailureTs:    0,
-			failures:         0,
-			failureThreshold: 1,
-			resetTimeout:     1000,
-			nowFn:            gtime.FixedNow(nowTs),
-		}
+		cb := NewSyncCircuitBreaker(1, 1000)
+		cb.nowFn = gtime.FixedNow(nowTs)
 		successTimes := 0
 
 		onErrorTimes := 0
@@ -169,13 +163,8 @@ func TestSyncCircuitBreaker_Do(t *testing.T) {
 	})
 
 	t.Run("failure(open), reset timeout, success(close)", func(t *testing.T) {
-		cb := &SyncCircuitBreaker{
-			lastFailureTs:    0,
-			failures:         0,
-			failureThreshold: 1,
-			resetTimeout:     10,
-			nowFn:            gtime.FixedNow(nowTs),
-		}
+		cb := NewSyncCircuitBreaker(1, 10)
+		cb.nowFn = gtime.FixedNow(nowTs)
 
 		successTimes := 0
 		onErrorTimes := 0
@@ -211,157 +200,446 @@ func TestSyncCircuitBreaker_Do(t *testing.T) {
 
 }
 
-func TestSyncCircuitBreaker_recordFailure(t *testing.T) {
+func TestNewCountBasedCircuitBreaker(t *testing.T) {
+	cb := NewCountBasedCircuitBreaker(10, 4, 0.5, time.Second)
+	if got, want := cb.failureThreshold, 0; got != want {
+		t.Errorf("failureThreshold = %v, want %v", got, want)
+	}
+	if got, want := cb.resetTimeout, int64(time.Second); got != want {
+		t.Errorf("resetTimeout = %v, want %v", got, want)
+	}
+	if got, want := cb.failureRate.minimumCalls, 4; got != want {
+		t.Errorf("minimumCalls = %v, want %v", got, want)
+	}
+	if got, want := cb.failureRate.threshold, 0.5; got != want {
+		t.Errorf("threshold = %v, want %v", got, want)
+	}
+	if got, want := len(cb.failureRate.window.(*countWindow).outcomes), 10; got != want {
+		t.Errorf("window size = %v, want %v", got, want)
+	}
+	if got := cb.nowFn; got == nil {
+		t.Errorf("nowFn is nil, want not nil")
+	}
+}
+
+func TestSyncCircuitBreaker_Do_failureRate(t *testing.T) {
+	failure := errors.New("on purpose")
+	doTimes := func(cb *SyncCircuitBreaker, times int, err error) (executed, opened int) {
+		for i := 0; i < times; i++ {
+			cb.Do(func() error {
+				executed++
+				return err
+			}, func(error) {}, func() {
+				opened++
+			})
+		}
+		return executed, opened
+	}
+
+	t.Run("success doesn't reset failures", func(t *testing.T) {
+		cb := NewCountBasedCircuitBreaker(10, 5, 0.5, time.Second)
+		cb.nowFn = gtime.FixedNow(nowTs)
 
-	t.Run("", func(t *testing.T) {
-		cb := &SyncCircuitBreaker{
-			lastFailureTs: 10,
-			failures:      5,
-			nowFn:         gtime.FixedNow(nowTs),
+		doTimes(cb, 4, failure)
+		doTimes(cb, 1, nil)
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
 		}
-		cb.recordFailure()
-		if cb.failures != 6 {
-			t.Errorf("failures = %v, want %v", cb.failures, 6)
+		doTimes(cb, 1, failure)
+		if got, want := cb.State(), StateOpen; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+		if executed, opened := doTimes(cb, 1, nil); executed != 0 || opened != 1 {
+			t.Errorf("executed = %v, opened = %v, want 0, 1", executed, opened)
+		}
+	})
+
+	t.Run("below minimumCalls", func(t *testing.T) {
+		cb := NewCountBasedCircuitBreaker(10, 5, 0.5, time.Second)
+		cb.nowFn = gtime.FixedNow(nowTs)
+
+		doTimes(cb, 4, failure)
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
 		}
-		if cb.lastFailureTs == 10 {
-			t.Errorf("lastFailureTs = %v, want neq %v", cb.lastFailureTs, 10)
+	})
+
+	t.Run("trickle of failures", func(t *testing.T) {
+		cb := NewTimeBasedCircuitBreaker(time.Minute, 5, 0.5, time.Second)
+		clock := nowTs
+		for i := 0; i < 100; i++ {
+			cb.nowFn = gtime.FixedNow(clock)
+			doTimes(cb, 1, failure)
+			doTimes(cb, 9, nil)
+			clock = clock.Add(10 * time.Minute)
+		}
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
 		}
 	})
 
+	t.Run("half-open", func(t *testing.T) {
+		cb := NewTimeBasedCircuitBreaker(time.Minute, 2, 0.5, time.Second)
+		cb.nowFn = gtime.FixedNow(nowTs)
+		doTimes(cb, 2, failure)
+		if got, want := cb.State(), StateOpen; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+
+		// failed in half-open, back to open
+		cb.nowFn = gtime.FixedNow(nowTs.Add(2 * time.Second))
+		if got, want := cb.State(), StateHalfOpen; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+		doTimes(cb, 1, failure)
+		if got, want := cb.State(), StateOpen; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+
+		// succeeded in half-open, closed with a clean window
+		cb.nowFn = gtime.FixedNow(nowTs.Add(4 * time.Second))
+		doTimes(cb, 1, nil)
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+		doTimes(cb, 1, failure)
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+	})
 }
 
-func TestSyncCircuitBreaker_reset(t *testing.T) {
-	t.Run("", func(t *testing.T) {
-		cb := &SyncCircuitBreaker{
-			lastFailureTs: 10,
-			failures:      5,
+func TestSyncCircuitBreaker_DoCtx(t *testing.T) {
+	noop := func(error) {}
+
+	t.Run("slow call", func(t *testing.T) {
+		cb := NewSyncCircuitBreaker(2, time.Second)
+		cb.SetSlowCallDuration(100 * time.Millisecond)
+		clock := nowTs
+		cb.nowFn = func() time.Time {
+			return clock
 		}
-		cb.reset()
-		if cb.failures != 0 {
-			t.Errorf("failures = %v, want %v", cb.failures, 0)
+		onErrorTimes := 0
+		slowTask := func(ctx context.Context) error {
+			clock = clock.Add(101 * time.Millisecond)
+			return nil
 		}
-		if cb.lastFailureTs != 0 {
-			t.Errorf("lastFailureTs = %v, want %v", cb.lastFailureTs, 0)
+
+		cb.DoCtx(context.Background(), slowTask, func(error) {
+			onErrorTimes++
+		}, func() {})
+		if got, want := cb.failures, 1; got != want {
+			t.Errorf("failures = %v, want %v", got, want)
+		}
+		if got, want := onErrorTimes, 0; got != want {
+			t.Errorf("onErrorTimes = %v, want %v", got, want)
+		}
+		cb.DoCtx(context.Background(), slowTask, noop, func() {})
+		if got, want := cb.State(), StateOpen; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+
+		// disabled
+		cb.SetSlowCallDuration(0)
+		cb = NewSyncCircuitBreaker(2, time.Second)
+		cb.DoCtx(context.Background(), slowTask, noop, func() {})
+		if got, want := cb.failures, 0; got != want {
+			t.Errorf("failures = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cb := NewSyncCircuitBreaker(1, time.Second)
+		cb.SetTimeout(10 * time.Millisecond)
+
+		release := make(chan struct{})
+		defer close(release)
+		var gotErr error
+		start := time.Now()
+		cb.DoCtx(context.Background(), func(ctx context.Context) error {
+			// ignores ctx
+			<-release
+			return nil
+		}, func(err error) {
+			gotErr = err
+		}, func() {})
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("DoCtx() took %v, want about %v", elapsed, 10*time.Millisecond)
+		}
+		if gotErr != context.DeadlineExceeded {
+			t.Errorf("onError(%v), want %v", gotErr, context.DeadlineExceeded)
+		}
+		if got, want := cb.State(), StateOpen; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("timeout passed to task", func(t *testing.T) {
+		cb := NewSyncCircuitBreaker(1, time.Second)
+		cb.SetTimeout(time.Hour)
+		cb.DoCtx(context.Background(), func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("ctx has no deadline")
+			}
+			return nil
+		}, noop, func() {})
+	})
+
+	t.Run("canceled by caller", func(t *testing.T) {
+		cb := NewSyncCircuitBreaker(1, time.Second)
+		ctx, cancel := context.WithCancel(context.Background())
+		var gotErr error
+		cb.DoCtx(ctx, func(ctx context.Context) error {
+			cancel()
+			return ctx.Err()
+		}, func(err error) {
+			gotErr = err
+		}, func() {})
+		if gotErr != context.Canceled {
+			t.Errorf("onError(%v), want %v", gotErr, context.Canceled)
+		}
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("deadline of caller exceeded", func(t *testing.T) {
+		cb := NewSyncCircuitBreaker(1, time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		cb.DoCtx(ctx, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, noop, func() {})
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("NeverOpen", func(t *testing.T) {
+		var gotErr error
+		failure := errors.New("on purpose")
+		NeverOpen.DoCtx(context.Background(), func(ctx context.Context) error {
+			return failure
+		}, func(err error) {
+			gotErr = err
+		}, func() {})
+		if gotErr != failure {
+			t.Errorf("onError(%v), want %v", gotErr, failure)
 		}
 	})
 }
 
-func TestSyncCircuitBreaker_state(t *testing.T) {
+func TestIgnored(t *testing.T) {
+	clock := nowTs
+	cb := NewSyncCircuitBreaker(1, time.Second)
+	cb.nowFn = func() time.Time {
+		return clock
+	}
+	failure := errors.New("on purpose")
+	fail := func() error {
+		return failure
+	}
+	cb.Do(fail, func(error) {}, func() {})
+	clock = clock.Add(time.Second + time.Nanosecond)
+
+	var gotErr error
+	cb.Do(func() error {
+		return Ignored(failure)
+	}, func(err error) {
+		gotErr = err
+	}, func() {})
+	if gotErr != failure {
+		t.Errorf("onError(%v), want %v", gotErr, failure)
+	}
+	if got, want := cb.State(), StateHalfOpen; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
+	}
 
-	type fields struct {
-		failureThreshold int
-		lastFailureTs    int64
-		failures         int
-		resetTimeout     int64
-		nowFn            gtime.NowFunc
+	cb.Do(fail, func(error) {}, func() {})
+	if got, want := cb.State(), StateOpen; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
 	}
 
+	if err := Ignored(nil); err != nil {
+		t.Errorf("Ignored(nil) = %v, want nil", err)
+	}
+}
+
+func TestState_String(t *testing.T) {
 	tests := []struct {
-		name   string
-		fields fields
-		want   state
+		state State
+		want  string
 	}{
-		{
-			name: "failureThreshold:0, failures:0, not reach resetTimeout",
-			fields: fields{
-				failureThreshold: 0,
-				lastFailureTs:    nowTs.UnixNano() - 1,
-				failures:         0,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: open,
-		},
-		{
-			name: "failureThreshold:0, failures:0, reach resetTimeout",
-			fields: fields{
-				failureThreshold: 0,
-				lastFailureTs:    nowTs.UnixNano() - 11,
-				failures:         0,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: halfOpen,
-		},
-		{
-			name: "failureThreshold:1, failures:1, not reach resetTimeout",
-			fields: fields{
-				failureThreshold: 1,
-				lastFailureTs:    nowTs.UnixNano() - 1,
-				failures:         1,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: open,
-		},
-		{
-			name: "failureThreshold:1, failures:1, reach resetTimeout",
-			fields: fields{
-				failureThreshold: 1,
-				lastFailureTs:    nowTs.UnixNano() - 11,
-				failures:         1,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: halfOpen,
-		},
-		{
-			name: "failureThreshold:1, failures:2, not reach resetTimeout",
-			fields: fields{
-				failureThreshold: 1,
-				lastFailureTs:    nowTs.UnixNano() - 1,
-				failures:         2,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: open,
-		},
-		{
-			name: "failureThreshold:1, failures:2, reach resetTimeout",
-			fields: fields{
-				failureThreshold: 1,
-				lastFailureTs:    nowTs.UnixNano() - 11,
-				failures:         2,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: halfOpen,
-		},
-		{
-			name: "failureThreshold:1, failures:0",
-			fields: fields{
-				failureThreshold: 1,
-				lastFailureTs:    0,
-				failures:         0,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: closed,
-		},
-		{
-			name: "failureThreshold:2, failures:1",
-			fields: fields{
-				failureThreshold: 2,
-				lastFailureTs:    nowTs.UnixNano(),
-				failures:         1,
-				resetTimeout:     10,
-				nowFn:            gtime.FixedNow(nowTs),
-			},
-			want: closed,
-		},
+		{StateClosed, "closed"},
+		{StateOpen, "open"},
+		{StateHalfOpen, "half-open"},
+		{State(-1), "unknown"},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := &SyncCircuitBreaker{
-				failureThreshold: tt.fields.failureThreshold,
-				lastFailureTs:    tt.fields.lastFailureTs,
-				failures:         tt.fields.failures,
-				resetTimeout:     tt.fields.resetTimeout,
-				nowFn:            tt.fields.nowFn,
-			}
-			if got := s.state(); got != tt.want {
-				t.Errorf("state() = %v, want %v", got, tt.want)
-			}
-		})
+		if got := tt.state.String(); got != tt.want {
+			t.Errorf("String() = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestSyncCircuitBreaker_stateMachine(t *testing.T) {
+	clock := nowTs
+	cb := NewSyncCircuitBreaker(2, time.Second)
+	cb.SetHalfOpen(2, 3)
+	cb.nowFn = func() time.Time {
+		return clock
+	}
+	assertState := func(want State) {
+		t.Helper()
+		if got := cb.State(); got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+	}
+	mustAcquire := func() uint64 {
+		t.Helper()
+		generation, permitted := cb.acquire()
+		if !permitted {
+			t.Fatalf("acquire() permitted = false, want true")
+		}
+		return generation
+	}
+
+	// closed -> open
+	cb.complete(mustAcquire(), OutcomeFailure)
+	cb.complete(mustAcquire(), OutcomeSuccess)
+	cb.complete(mustAcquire(), OutcomeFailure)
+	assertState(StateClosed)
+	stale := mustAcquire()
+	cb.complete(mustAcquire(), OutcomeFailure)
+	assertState(StateOpen)
+	if _, permitted := cb.acquire(); permitted {
+		t.Errorf("acquire() permitted = true in open state, want false")
+	}
+
+	// open -> half-open
+	clock = clock.Add(time.Second)
+	assertState(StateOpen)
+	clock = clock.Add(time.Nanosecond)
+	assertState(StateHalfOpen)
+
+	// result of call acquired in closed state is discarded
+	cb.complete(stale, OutcomeFailure)
+	assertState(StateHalfOpen)
+
+	// at most 2 trial calls
+	g1, g2 := mustAcquire(), mustAcquire()
+	if _, permitted := cb.acquire(); permitted {
+		t.Errorf("acquire() permitted = true when trial calls are full, want false")
+	}
+	cb.complete(g1, OutcomeSuccess)
+	cb.complete(g2, OutcomeIgnored)
+	g1, g2 = mustAcquire(), mustAcquire()
+	cb.complete(g1, OutcomeSuccess)
+	assertState(StateHalfOpen)
+
+	// half-open -> open
+	cb.complete(g2, OutcomeFailure)
+	assertState(StateOpen)
+
+	// half-open -> closed after 3 successes
+	clock = clock.Add(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		assertState(StateHalfOpen)
+		cb.complete(mustAcquire(), OutcomeSuccess)
+	}
+	assertState(StateClosed)
+	if got, want := cb.failures, 0; got != want {
+		t.Errorf("failures = %v, want %v", got, want)
+	}
+}
+
+func TestSyncCircuitBreaker_SetHalfOpen(t *testing.T) {
+	cb := NewSyncCircuitBreaker(1, time.Second)
+	cb.SetHalfOpen(0, -1)
+	if cb.halfOpenMaxCalls != 1 || cb.halfOpenSuccessCalls != 1 {
+		t.Errorf("halfOpenMaxCalls = %v, halfOpenSuccessCalls = %v, want 1, 1", cb.halfOpenMaxCalls, cb.halfOpenSuccessCalls)
+	}
+}
+
+func TestSyncCircuitBreaker_Do_halfOpenStampede(t *testing.T) {
+	// trial calls are given up after reset timeout, it should be long enough
+	cb := NewSyncCircuitBreaker(1, 100*time.Millisecond)
+	cb.SetHalfOpen(3, 1)
+	cb.Do(func() error {
+		return errors.New("on purpose")
+	}, func(error) {}, func() {})
+	time.Sleep(110 * time.Millisecond)
+
+	var (
+		executed int32
+		opened   int32
+		wg       sync.WaitGroup
+	)
+	release := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cb.Do(func() error {
+				atomic.AddInt32(&executed, 1)
+				<-release
+				return nil
+			}, func(error) {}, func() {
+				atomic.AddInt32(&opened, 1)
+			})
+		}()
+	}
+	for atomic.LoadInt32(&executed)+atomic.LoadInt32(&opened) < 20 && atomic.LoadInt32(&opened) < 17 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got, want := atomic.LoadInt32(&executed), int32(3); got != want {
+		t.Errorf("executed = %v, want %v", got, want)
+	}
+	if got, want := cb.State(), StateClosed; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncCircuitBreaker_halfOpenHangingTrial(t *testing.T) {
+	clock := nowTs
+	cb := NewSyncCircuitBreaker(1, time.Second)
+	cb.nowFn = func() time.Time {
+		return clock
+	}
+	cb.Do(func() error {
+		return errors.New("on purpose")
+	}, func(error) {}, func() {})
+	clock = clock.Add(time.Second + time.Nanosecond)
+
+	// trial call never returns
+	hanging, permitted := cb.acquire()
+	if !permitted {
+		t.Fatalf("acquire() permitted = false, want true")
+	}
+	clock = clock.Add(time.Second)
+	if _, permitted := cb.acquire(); permitted {
+		t.Errorf("acquire() permitted = true before reset timeout, want false")
+	}
+
+	clock = clock.Add(time.Nanosecond)
+	generation, permitted := cb.acquire()
+	if !permitted {
+		t.Fatalf("acquire() permitted = false after reset timeout, want true")
+	}
+	if generation == hanging {
+		t.Errorf("acquire() generation = %v, want a new one", generation)
+	}
+	// result of the hanging trial call is discarded
+	cb.complete(hanging, OutcomeFailure)
+	if got, want := cb.State(), StateHalfOpen; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
+	}
+	cb.complete(generation, OutcomeSuccess)
+	if got, want := cb.State(), StateClosed; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
 	}
 }
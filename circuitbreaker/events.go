@@ -0,0 +1,91 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StateChangeListener called after state of circuit breaker named name changes,
+// it's called in the goroutine making the change, so it should be fast.
+type StateChangeListener func(name string, from, to State)
+
+// StateChangeEvent published to event bus after state changes
+type StateChangeEvent struct {
+	Name string
+	From State
+	To   State
+	Time time.Time
+}
+
+// Metrics counters of a circuit breaker since created
+type Metrics struct {
+	Calls      int64 // calls executed
+	Failures   int64 // calls counted as failure, including slow calls succeeded
+	SlowCalls  int64 // calls took longer than slow call duration
+	Rejections int64 // calls rejected because the circuit breaker is open or trial calls are full in half-open state
+}
+
+// Name of the circuit breaker, see WithName
+func (s *SyncCircuitBreaker) Name() string {
+	return s.name
+}
+
+// OnStateChange add a listener to be called after state changes
+func (s *SyncCircuitBreaker) OnStateChange(listener StateChangeListener) {
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// Metrics snapshot of counters
+func (s *SyncCircuitBreaker) Metrics() Metrics {
+	return Metrics{
+		Calls:      atomic.LoadInt64(&s.metrics.Calls),
+		Failures:   atomic.LoadInt64(&s.metrics.Failures),
+		SlowCalls:  atomic.LoadInt64(&s.metrics.SlowCalls),
+		Rejections: atomic.LoadInt64(&s.metrics.Rejections),
+	}
+}
+
+// unlock s.lock, then notify state changes happened while holding it
+func (s *SyncCircuitBreaker) unlock() {
+	changes := s.pendingChanges
+	s.pendingChanges = nil
+	s.lock.Unlock()
+
+	for _, change := range changes {
+		s.notify(change)
+	}
+}
+
+func (s *SyncCircuitBreaker) notify(change StateChangeEvent) {
+	s.listenerLock.RLock()
+	listeners := s.listeners
+	s.listenerLock.RUnlock()
+
+	for _, listener := range listeners {
+		listener(change.Name, change.From, change.To)
+	}
+
+	if s.bus != nil {
+		// dropped if the bus is full or closed
+		s.bus.TryPublish(change)
+	}
+}
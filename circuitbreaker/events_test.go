@@ -0,0 +1,154 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"errors"
+	"github.com/chanjarster/gears/event"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type recordedChange struct {
+	name     string
+	from, to State
+}
+
+func TestSyncCircuitBreaker_OnStateChange(t *testing.T) {
+	clock := nowTs
+	var changes []recordedChange
+	listener := func(name string, from, to State) {
+		changes = append(changes, recordedChange{name, from, to})
+	}
+	cb := NewCircuitBreaker(
+		WithName("foo"),
+		WithFailureThreshold(1),
+		WithResetTimeout(time.Second),
+		WithStateChangeListener(listener),
+	)
+	cb.nowFn = func() time.Time {
+		return clock
+	}
+	var lateChanges []recordedChange
+	cb.OnStateChange(func(name string, from, to State) {
+		// state can be read in listener
+		cb.State()
+		lateChanges = append(lateChanges, recordedChange{name, from, to})
+	})
+
+	failure := errors.New("on purpose")
+	cb.Do(func() error { return failure }, func(error) {}, func() {})
+	clock = clock.Add(2 * time.Second)
+	cb.Do(func() error { return nil }, func(error) {}, func() {})
+
+	want := []recordedChange{
+		{"foo", StateClosed, StateOpen},
+		{"foo", StateOpen, StateHalfOpen},
+		{"foo", StateHalfOpen, StateClosed},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+	if !reflect.DeepEqual(lateChanges, want) {
+		t.Errorf("changes of listener added later = %v, want %v", lateChanges, want)
+	}
+	if got, want := cb.Name(), "foo"; got != want {
+		t.Errorf("Name() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncCircuitBreaker_eventBus(t *testing.T) {
+	bus := event.NewFanOutBus(1)
+	recv := bus.NewRecv("test", 10)
+	bus.GoDispatch()
+	defer bus.Close()
+
+	cb := NewCircuitBreaker(WithName("foo"), WithFailureThreshold(1), WithEventBus(bus))
+	cb.nowFn = func() time.Time {
+		return nowTs
+	}
+
+	cb.Do(func() error { return errors.New("on purpose") }, func(error) {}, func() {})
+	got := <-recv.C
+	want := StateChangeEvent{Name: "foo", From: StateClosed, To: StateOpen, Time: time.Unix(0, nowTs.UnixNano())}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("event = %v, want %v", got, want)
+	}
+}
+
+func TestSyncCircuitBreaker_eventBusFull(t *testing.T) {
+	// not dispatching, so the bus is full after 1 event
+	bus := event.NewFanOutBus(1)
+	bus.C <- "occupied"
+
+	cb := NewCircuitBreaker(WithFailureThreshold(1), WithEventBus(bus))
+	done := make(chan struct{})
+	go func() {
+		cb.Do(func() error { return errors.New("on purpose") }, func(error) {}, func() {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Do() blocked by full event bus")
+	}
+}
+
+func TestSyncCircuitBreaker_eventBusClosed(t *testing.T) {
+	bus := event.NewFanOutBus(1)
+	bus.Close()
+
+	cb := NewCircuitBreaker(WithFailureThreshold(1), WithEventBus(bus))
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("Do() panicked by closed event bus: %v", r)
+		}
+	}()
+	cb.Do(func() error { return errors.New("on purpose") }, func(error) {}, func() {})
+	if got, want := cb.State(), StateOpen; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncCircuitBreaker_Metrics(t *testing.T) {
+	clock := nowTs
+	cb := NewCircuitBreaker(WithFailureThreshold(3), WithSlowCallDuration(time.Second))
+	cb.nowFn = func() time.Time {
+		return clock
+	}
+	noop := func(error) {}
+
+	cb.Do(func() error { return nil }, noop, func() {})
+	cb.Do(func() error {
+		clock = clock.Add(2 * time.Second)
+		return nil
+	}, noop, func() {})
+	cb.Do(func() error {
+		clock = clock.Add(2 * time.Second)
+		return errors.New("on purpose")
+	}, noop, func() {})
+	cb.Do(func() error { return errors.New("on purpose") }, noop, func() {})
+	cb.Do(func() error { return nil }, noop, func() {})
+	cb.Do(func() error { return nil }, noop, func() {})
+
+	want := Metrics{Calls: 4, Failures: 3, SlowCalls: 2, Rejections: 2}
+	if got := cb.Metrics(); got != want {
+		t.Errorf("Metrics() = %+v, want %+v", got, want)
+	}
+}
@@ -18,7 +18,11 @@
 package circuitbreaker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/chanjarster/gears/event"
+	"github.com/go-redis/redis/v7"
 	"net/http"
 	"os"
 	"time"
@@ -53,3 +57,179 @@ func Example_circuitBreaker() {
 	getGoogle(client, circuitBreaker)
 
 }
+
+func Example_failureRate() {
+	// opens when at least half of the last 100 calls failed, failure rate is calculated after 20 calls
+	circuitBreaker := NewCountBasedCircuitBreaker(100, 20, 0.5, 10*time.Second)
+	// opens when at least half of the calls in the last minute failed
+	circuitBreaker = NewTimeBasedCircuitBreaker(time.Minute, 20, 0.5, 10*time.Second)
+
+	circuitBreaker.Do(
+		func() error {
+			return nil
+		},
+		func(err error) {
+			fmt.Println("Error:", err)
+		},
+		func() {
+			fmt.Println("Circuit breaker is opened")
+		},
+	)
+}
+
+func Example_doCtx() {
+	circuitBreaker := NewSyncCircuitBreaker(5, 10*time.Second)
+	// calls take longer than 1s are failures, calls take longer than 3s are canceled
+	circuitBreaker.SetSlowCallDuration(time.Second)
+	circuitBreaker.SetTimeout(3 * time.Second)
+
+	circuitBreaker.DoCtx(
+		context.Background(),
+		func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, "GET", "https://google.com", nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		},
+		func(err error) {
+			fmt.Println("google is not available. Error:", err)
+		},
+		func() {
+			fmt.Println("google is not available. Circuit breaker is opened")
+		},
+	)
+}
+
+func Example_classifyErrors() {
+	errNotFound := errors.New("not found")
+	errInvalidArgument := errors.New("invalid argument")
+	circuitBreaker := NewCircuitBreaker(
+		WithCountWindow(100, 20, 0.5),
+		WithResetTimeout(10*time.Second),
+		// not found is a normal response of a healthy downstream
+		WithSuccessErrors(ErrorIs(errNotFound)),
+		// caller's fault, tells nothing about the downstream
+		WithIgnoredErrors(ErrorIs(errInvalidArgument)),
+	)
+
+	circuitBreaker.Do(
+		func() error {
+			return errNotFound
+		},
+		func(err error) {
+			fmt.Println("Error:", err)
+		},
+		func() {
+			fmt.Println("Circuit breaker is opened")
+		},
+	)
+	// Output: Error: not found
+}
+
+func Example_stateChange() {
+	bus := event.NewFanOutBus(1024)
+	bus.GoDispatch()
+	recv := bus.NewRecv("alert", 1024)
+	go func() {
+		for e := range recv.C {
+			change := e.(StateChangeEvent)
+			fmt.Println("alert:", change.Name, "is", change.To)
+		}
+	}()
+
+	circuitBreaker := NewCircuitBreaker(
+		WithName("google"),
+		WithStateChangeListener(func(name string, from, to State) {
+			fmt.Println(name, "changed from", from, "to", to)
+		}),
+		WithEventBus(bus),
+	)
+
+	// for dashboards
+	metrics := circuitBreaker.Metrics()
+	fmt.Println("calls:", metrics.Calls, "failures:", metrics.Failures,
+		"slow calls:", metrics.SlowCalls, "rejections:", metrics.Rejections)
+}
+
+func Example_registry() {
+	// all downstreams open when half of the last 100 calls failed
+	registry := NewRegistry(WithCountWindow(100, 20, 0.5), WithResetTimeout(10*time.Second))
+	// payment service recovers slowly
+	registry.Configure("payment", WithResetTimeout(time.Minute), WithHalfOpen(3, 10))
+
+	registry.Get("payment").Do(
+		func() error {
+			return nil
+		},
+		func(err error) {
+			fmt.Println("payment is not available. Error:", err)
+		},
+		func() {
+			fmt.Println("payment is not available. Circuit breaker is opened")
+		},
+	)
+
+	for _, status := range registry.List() {
+		fmt.Println(status.Name, status.State, status.Metrics.Calls)
+	}
+	// Output: payment closed 1
+}
+
+func Example_execute() {
+	circuitBreaker := NewCircuitBreaker(WithTimeout(3 * time.Second))
+
+	status, err := Execute(circuitBreaker, context.Background(),
+		func(ctx context.Context) (string, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://google.com", nil)
+			if err != nil {
+				return "", err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			return resp.Status, nil
+		},
+		func(ctx context.Context, err error) (string, error) {
+			if errors.Is(err, ErrOpenState) {
+				return "google is not available", nil
+			}
+			return "", err
+		},
+	)
+	fmt.Println(status, err)
+}
+
+func Example_redisCircuitBreaker() {
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	LoadScript(redisClient)
+
+	// all replicas open after 5 consecutive failures in total, and only 1 of them probes in half-open state
+	circuitBreaker := NewRedisCircuitBreaker(redisClient, "google",
+		WithFailureThreshold(5),
+		WithResetTimeout(10*time.Second),
+		WithSyncInterval(time.Second),
+		WithRedisTimeout(100*time.Millisecond),
+	)
+
+	status, err := Execute[string](circuitBreaker, context.Background(),
+		func(ctx context.Context) (string, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://google.com", nil)
+			if err != nil {
+				return "", err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			return resp.Status, nil
+		}, nil)
+	fmt.Println(status, err)
+}
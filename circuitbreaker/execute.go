@@ -0,0 +1,65 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrOpenState returned by Execute when the circuit breaker is open
+var ErrOpenState = errors.New("circuitbreaker: circuit breaker is open")
+
+// Execute task with the circuit breaker and return its result.
+//
+//	cb: circuit breaker
+//	task: task to be done, should return when ctx is done
+//	fallback: optional, be called with ErrOpenState if state == open, or with the error returned from task.
+//	  If fallback is nil, zero value and the error are returned.
+func Execute[T any](cb ContextInterface, ctx context.Context,
+	task func(ctx context.Context) (T, error),
+	fallback func(ctx context.Context, err error) (T, error)) (T, error) {
+
+	// task may still be running after timeout, so result is passed through a channel instead of a captured variable
+	results := make(chan T, 1)
+	var err error
+	cb.DoCtx(ctx,
+		func(ctx context.Context) error {
+			v, err := task(ctx)
+			if err == nil {
+				results <- v
+			}
+			return err
+		},
+		func(e error) {
+			err = e
+		},
+		func() {
+			err = ErrOpenState
+		},
+	)
+
+	if err == nil {
+		return <-results, nil
+	}
+	if fallback != nil {
+		return fallback(ctx, err)
+	}
+	var zero T
+	return zero, err
+}
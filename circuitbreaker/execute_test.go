@@ -0,0 +1,110 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExecute(t *testing.T) {
+	errFoo := errors.New("foo")
+	fallback := func(ctx context.Context, err error) (string, error) {
+		return "fallback: " + err.Error(), nil
+	}
+
+	t.Run("success", func(t *testing.T) {
+		cb := NewCircuitBreaker()
+		got, err := Execute(cb, context.Background(), func(ctx context.Context) (string, error) {
+			return "ok", nil
+		}, fallback)
+		if got != "ok" || err != nil {
+			t.Errorf("Execute() = %q, %v, want %q, nil", got, err, "ok")
+		}
+	})
+
+	t.Run("error without fallback", func(t *testing.T) {
+		cb := NewCircuitBreaker()
+		got, err := Execute(cb, context.Background(), func(ctx context.Context) (int, error) {
+			return 1, errFoo
+		}, nil)
+		if got != 0 || err != errFoo {
+			t.Errorf("Execute() = %v, %v, want 0, %v", got, err, errFoo)
+		}
+	})
+
+	t.Run("error with fallback", func(t *testing.T) {
+		cb := NewCircuitBreaker()
+		got, err := Execute(cb, context.Background(), func(ctx context.Context) (string, error) {
+			return "", errFoo
+		}, fallback)
+		if got != "fallback: foo" || err != nil {
+			t.Errorf("Execute() = %q, %v, want %q, nil", got, err, "fallback: foo")
+		}
+	})
+
+	t.Run("open", func(t *testing.T) {
+		cb := NewCircuitBreaker(WithFailureThreshold(1))
+		Execute(cb, context.Background(), func(ctx context.Context) (string, error) {
+			return "", errFoo
+		}, nil)
+
+		called := false
+		got, err := Execute(cb, context.Background(), func(ctx context.Context) (string, error) {
+			called = true
+			return "ok", nil
+		}, nil)
+		if called {
+			t.Errorf("Execute() called task when state == open")
+		}
+		if got != "" || !errors.Is(err, ErrOpenState) {
+			t.Errorf("Execute() = %q, %v, want \"\", %v", got, err, ErrOpenState)
+		}
+
+		got, err = Execute(cb, context.Background(), func(ctx context.Context) (string, error) {
+			return "ok", nil
+		}, fallback)
+		if want := "fallback: " + ErrOpenState.Error(); got != want || err != nil {
+			t.Errorf("Execute() = %q, %v, want %q, nil", got, err, want)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		cb := NewCircuitBreaker(WithTimeout(10 * time.Millisecond))
+		got, err := Execute(cb, context.Background(), func(ctx context.Context) (string, error) {
+			time.Sleep(50 * time.Millisecond)
+			return "late", nil
+		}, nil)
+		if got != "" || err != context.DeadlineExceeded {
+			t.Errorf("Execute() = %q, %v, want \"\", %v", got, err, context.DeadlineExceeded)
+		}
+		// wait for the task returning, race detector should not complain
+		time.Sleep(60 * time.Millisecond)
+	})
+
+	t.Run("never open", func(t *testing.T) {
+		got, err := Execute(NeverOpen, context.Background(), func(ctx context.Context) (int, error) {
+			return 42, nil
+		}, nil)
+		if got != 42 || err != nil {
+			t.Errorf("Execute() = %v, %v, want 42, nil", got, err)
+		}
+	})
+}
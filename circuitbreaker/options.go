@@ -0,0 +1,270 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"errors"
+	"github.com/chanjarster/gears/event"
+	gtime "github.com/chanjarster/gears/util/time"
+	"reflect"
+	"time"
+)
+
+// Classifier classify a non-nil error returned by task
+type Classifier func(err error) Outcome
+
+// ErrorMatcher tells whether err matches
+type ErrorMatcher func(err error) bool
+
+// ErrorIs match err if errors.Is(err, target) for any of targets
+func ErrorIs(targets ...error) ErrorMatcher {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ErrorAs match err if errors.As(err, &target) for any type of prototypes, e.g.
+//
+//	ErrorAs(&ValidationError{}, (*NotFoundError)(nil))
+func ErrorAs(prototypes ...error) ErrorMatcher {
+	types := make([]reflect.Type, 0, len(prototypes))
+	for _, prototype := range prototypes {
+		if prototype != nil {
+			types = append(types, reflect.TypeOf(prototype))
+		}
+	}
+	return func(err error) bool {
+		for _, typ := range types {
+			if errors.As(err, reflect.New(typ).Interface()) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type options struct {
+	name                 string
+	failureThreshold     int
+	newFailureRate       func() *failureRate // 每个断路器都要有自己的窗口
+	resetTimeout         time.Duration
+	halfOpenMaxCalls     int
+	halfOpenSuccessCalls int
+	slowCallDuration     time.Duration
+	timeout              time.Duration
+	classifier           Classifier
+	successMatchers      []ErrorMatcher
+	ignoredMatchers      []ErrorMatcher
+	listeners            []StateChangeListener
+	bus                  *event.FanOutBus
+	syncInterval         time.Duration
+	redisTimeout         time.Duration
+}
+
+// Option of NewCircuitBreaker
+type Option func(opts *options)
+
+// WithName name of the circuit breaker, passed to StateChangeListener and StateChangeEvent
+func WithName(name string) Option {
+	return func(opts *options) {
+		opts.name = name
+	}
+}
+
+// WithStateChangeListener see SyncCircuitBreaker.OnStateChange
+func WithStateChangeListener(listeners ...StateChangeListener) Option {
+	return func(opts *options) {
+		opts.listeners = append(opts.listeners, listeners...)
+	}
+}
+
+// WithEventBus publish StateChangeEvent to bus, events are dropped if bus.C is full or bus is closed
+func WithEventBus(bus *event.FanOutBus) Option {
+	return func(opts *options) {
+		opts.bus = bus
+	}
+}
+
+// WithFailureThreshold open after n consecutive failures, default 5
+func WithFailureThreshold(n int) Option {
+	return func(opts *options) {
+		opts.failureThreshold = n
+		opts.newFailureRate = nil
+	}
+}
+
+// WithCountWindow open when failure rate of the last windowSize calls reaches failureRateThreshold,
+// see NewCountBasedCircuitBreaker
+func WithCountWindow(windowSize, minimumCalls int, failureRateThreshold float64) Option {
+	return func(opts *options) {
+		opts.newFailureRate = func() *failureRate {
+			return &failureRate{
+				window:       newCountWindow(windowSize),
+				minimumCalls: minimumCalls,
+				threshold:    failureRateThreshold,
+			}
+		}
+	}
+}
+
+// WithTimeWindow open when failure rate of calls in the last windowSize reaches failureRateThreshold,
+// see NewTimeBasedCircuitBreaker
+func WithTimeWindow(windowSize time.Duration, minimumCalls int, failureRateThreshold float64) Option {
+	return func(opts *options) {
+		opts.newFailureRate = func() *failureRate {
+			return &failureRate{
+				window:       newTimeWindow(int64(windowSize)),
+				minimumCalls: minimumCalls,
+				threshold:    failureRateThreshold,
+			}
+		}
+	}
+}
+
+// WithResetTimeout stay open for resetTimeout before half-open, default 60s
+func WithResetTimeout(resetTimeout time.Duration) Option {
+	return func(opts *options) {
+		opts.resetTimeout = resetTimeout
+	}
+}
+
+// WithHalfOpen see SyncCircuitBreaker.SetHalfOpen, default 1, 1
+func WithHalfOpen(maxCalls, successCalls int) Option {
+	return func(opts *options) {
+		opts.halfOpenMaxCalls = maxCalls
+		opts.halfOpenSuccessCalls = successCalls
+	}
+}
+
+// WithSlowCallDuration see SyncCircuitBreaker.SetSlowCallDuration
+func WithSlowCallDuration(d time.Duration) Option {
+	return func(opts *options) {
+		opts.slowCallDuration = d
+	}
+}
+
+// WithTimeout see SyncCircuitBreaker.SetTimeout
+func WithTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.timeout = d
+	}
+}
+
+// WithClassifier classify errors by classifier, WithSuccessErrors and WithIgnoredErrors are not used if it's set.
+func WithClassifier(classifier Classifier) Option {
+	return func(opts *options) {
+		opts.classifier = classifier
+	}
+}
+
+// WithSuccessErrors errors matched are counted as success, e.g. WithSuccessErrors(ErrorIs(sql.ErrNoRows))
+func WithSuccessErrors(matchers ...ErrorMatcher) Option {
+	return func(opts *options) {
+		opts.successMatchers = append(opts.successMatchers, matchers...)
+	}
+}
+
+// WithIgnoredErrors errors matched are counted as neither success nor failure,
+// e.g. WithIgnoredErrors(ErrorAs(&ValidationError{}))
+func WithIgnoredErrors(matchers ...ErrorMatcher) Option {
+	return func(opts *options) {
+		opts.ignoredMatchers = append(opts.ignoredMatchers, matchers...)
+	}
+}
+
+// WithSyncInterval RedisCircuitBreaker in closed state reads state from redis at most once every d, default 1s
+func WithSyncInterval(d time.Duration) Option {
+	return func(opts *options) {
+		opts.syncInterval = d
+	}
+}
+
+// WithRedisTimeout timeout of each redis call of RedisCircuitBreaker, default is 0, means no timeout other than the redis client's.
+func WithRedisTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.redisTimeout = d
+	}
+}
+
+// classifier by matchers, errors not matched are failures
+func (o *options) matcherClassifier() Classifier {
+	if len(o.successMatchers) == 0 && len(o.ignoredMatchers) == 0 {
+		return nil
+	}
+	successMatchers, ignoredMatchers := o.successMatchers, o.ignoredMatchers
+	return func(err error) Outcome {
+		for _, match := range successMatchers {
+			if match(err) {
+				return OutcomeSuccess
+			}
+		}
+		for _, match := range ignoredMatchers {
+			if match(err) {
+				return OutcomeIgnored
+			}
+		}
+		return OutcomeFailure
+	}
+}
+
+// NewCircuitBreaker New a SyncCircuitBreaker with options, by default it opens after 5 consecutive failures,
+// half-opens after 60s, closes after 1 trial call succeeds, and all errors are failures.
+func NewCircuitBreaker(opts ...Option) *SyncCircuitBreaker {
+	return newOptions(opts).newSyncCircuitBreaker()
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		failureThreshold:     5,
+		resetTimeout:         60 * time.Second,
+		halfOpenMaxCalls:     1,
+		halfOpenSuccessCalls: 1,
+		syncInterval:         time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) newSyncCircuitBreaker() *SyncCircuitBreaker {
+	s := &SyncCircuitBreaker{
+		name:             o.name,
+		failureThreshold: o.failureThreshold,
+		resetTimeout:     int64(o.resetTimeout),
+		slowCallDuration: int64(o.slowCallDuration),
+		timeout:          int64(o.timeout),
+		classifier:       o.classifier,
+		nowFn:            gtime.SysNow,
+		listeners:        o.listeners,
+		bus:              o.bus,
+	}
+	if o.newFailureRate != nil {
+		s.failureRate = o.newFailureRate()
+	}
+	if s.classifier == nil {
+		s.classifier = o.matcherClassifier()
+	}
+	s.SetHalfOpen(o.halfOpenMaxCalls, o.halfOpenSuccessCalls)
+	return s
+}
@@ -0,0 +1,188 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testValidationError struct {
+	field string
+}
+
+func (t testValidationError) Error() string {
+	return "invalid " + t.field
+}
+
+type testNotFoundError struct{}
+
+func (t *testNotFoundError) Error() string {
+	return "not found"
+}
+
+func TestErrorIs(t *testing.T) {
+	errFoo, errBar := errors.New("foo"), errors.New("bar")
+	match := ErrorIs(errFoo, errBar)
+
+	if !match(fmt.Errorf("wrapped: %w", errBar)) {
+		t.Errorf("ErrorIs() not match wrapped error")
+	}
+	if match(errors.New("foo")) {
+		t.Errorf("ErrorIs() match another error")
+	}
+}
+
+func TestErrorAs(t *testing.T) {
+	match := ErrorAs(testValidationError{}, (*testNotFoundError)(nil), nil)
+
+	if !match(fmt.Errorf("wrapped: %w", testValidationError{"name"})) {
+		t.Errorf("ErrorAs() not match wrapped value error")
+	}
+	if !match(&testNotFoundError{}) {
+		t.Errorf("ErrorAs() not match pointer error")
+	}
+	if match(errors.New("foo")) {
+		t.Errorf("ErrorAs() match another error")
+	}
+}
+
+func TestNewCircuitBreaker(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		cb := NewCircuitBreaker()
+		if got, want := cb.failureThreshold, 5; got != want {
+			t.Errorf("failureThreshold = %v, want %v", got, want)
+		}
+		if got, want := cb.resetTimeout, int64(60*time.Second); got != want {
+			t.Errorf("resetTimeout = %v, want %v", got, want)
+		}
+		if cb.halfOpenMaxCalls != 1 || cb.halfOpenSuccessCalls != 1 {
+			t.Errorf("halfOpenMaxCalls = %v, halfOpenSuccessCalls = %v, want 1, 1", cb.halfOpenMaxCalls, cb.halfOpenSuccessCalls)
+		}
+		if cb.failureRate != nil || cb.classifier != nil || cb.slowCallDuration != 0 || cb.timeout != 0 {
+			t.Errorf("failureRate, classifier, slowCallDuration, timeout should be zero")
+		}
+		if cb.nowFn == nil {
+			t.Errorf("nowFn is nil, want not nil")
+		}
+	})
+
+	t.Run("options", func(t *testing.T) {
+		cb := NewCircuitBreaker(
+			WithFailureThreshold(3),
+			WithResetTimeout(time.Second),
+			WithHalfOpen(2, 3),
+			WithSlowCallDuration(time.Millisecond),
+			WithTimeout(time.Minute),
+		)
+		if got, want := cb.failureThreshold, 3; got != want {
+			t.Errorf("failureThreshold = %v, want %v", got, want)
+		}
+		if got, want := cb.resetTimeout, int64(time.Second); got != want {
+			t.Errorf("resetTimeout = %v, want %v", got, want)
+		}
+		if cb.halfOpenMaxCalls != 2 || cb.halfOpenSuccessCalls != 3 {
+			t.Errorf("halfOpenMaxCalls = %v, halfOpenSuccessCalls = %v, want 2, 3", cb.halfOpenMaxCalls, cb.halfOpenSuccessCalls)
+		}
+		if got, want := cb.slowCallDuration, int64(time.Millisecond); got != want {
+			t.Errorf("slowCallDuration = %v, want %v", got, want)
+		}
+		if got, want := cb.timeout, int64(time.Minute); got != want {
+			t.Errorf("timeout = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		if _, ok := NewCircuitBreaker(WithCountWindow(10, 5, 0.5)).failureRate.window.(*countWindow); !ok {
+			t.Errorf("WithCountWindow() window is not countWindow")
+		}
+		if _, ok := NewCircuitBreaker(WithTimeWindow(time.Minute, 5, 0.5)).failureRate.window.(*timeWindow); !ok {
+			t.Errorf("WithTimeWindow() window is not timeWindow")
+		}
+		if got := NewCircuitBreaker(WithTimeWindow(time.Minute, 5, 0.5), WithFailureThreshold(1)).failureRate; got != nil {
+			t.Errorf("WithFailureThreshold() failureRate = %v, want nil", got)
+		}
+	})
+}
+
+func TestSyncCircuitBreaker_DoCtx_classify(t *testing.T) {
+	errIgnored := errors.New("ignored")
+	errSuccess := errors.New("success")
+	errFailure := errors.New("failure")
+
+	do := func(cb *SyncCircuitBreaker, err error) (gotErr error) {
+		cb.DoCtx(context.Background(), func(ctx context.Context) error {
+			return err
+		}, func(err error) {
+			gotErr = err
+		}, func() {})
+		return gotErr
+	}
+
+	t.Run("matchers", func(t *testing.T) {
+		cb := NewCircuitBreaker(
+			WithFailureThreshold(1),
+			WithIgnoredErrors(ErrorIs(errIgnored), ErrorAs(testValidationError{})),
+			WithSuccessErrors(ErrorAs(&testNotFoundError{})),
+		)
+		for _, err := range []error{errIgnored, testValidationError{"name"}} {
+			if got := do(cb, err); got != err {
+				t.Errorf("onError(%v), want %v", got, err)
+			}
+			if got, want := cb.State(), StateClosed; got != want {
+				t.Errorf("State() = %v, want %v", got, want)
+			}
+		}
+
+		cb.failures = 0
+		cb.failureThreshold = 2
+		do(cb, errFailure)
+		do(cb, &testNotFoundError{})
+		do(cb, errFailure)
+		// success resets failures
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+		do(cb, errFailure)
+		if got, want := cb.State(), StateOpen; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("classifier", func(t *testing.T) {
+		cb := NewCircuitBreaker(
+			WithFailureThreshold(1),
+			WithClassifier(func(err error) Outcome {
+				if err == errSuccess {
+					return OutcomeSuccess
+				}
+				return OutcomeIgnored
+			}),
+			// not used
+			WithSuccessErrors(ErrorIs(errFailure)),
+		)
+		do(cb, errFailure)
+		do(cb, errSuccess)
+		if got, want := cb.State(), StateClosed; got != want {
+			t.Errorf("State() = %v, want %v", got, want)
+		}
+	})
+}
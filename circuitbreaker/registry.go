@@ -0,0 +1,115 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry creates and retrieves circuit breakers by name, e.g. one per downstream host or per route.
+//
+//	registry := NewRegistry(WithCountWindow(100, 20, 0.5), WithResetTimeout(10*time.Second))
+//	registry.Configure("payment", WithResetTimeout(time.Minute))
+//	registry.Get("payment").Do(task, onError, onOpen)
+type Registry struct {
+	lock      sync.RWMutex
+	defaults  []Option
+	overrides map[string][]Option
+	breakers  map[string]*SyncCircuitBreaker
+}
+
+// Status of a circuit breaker in Registry
+type Status struct {
+	Name    string
+	State   State
+	Metrics Metrics
+}
+
+// NewRegistry New a Registry
+//
+//	defaults: options applied to all circuit breakers
+func NewRegistry(defaults ...Option) *Registry {
+	return &Registry{
+		defaults:  defaults,
+		overrides: make(map[string][]Option),
+		breakers:  make(map[string]*SyncCircuitBreaker),
+	}
+}
+
+// Configure options of circuit breaker named name, applied after the defaults.
+// It only affects circuit breaker created after it, call it before Get.
+func (r *Registry) Configure(name string, opts ...Option) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.overrides[name] = append(r.overrides[name], opts...)
+}
+
+// Get circuit breaker named name, create it if not exists
+func (r *Registry) Get(name string) *SyncCircuitBreaker {
+	r.lock.RLock()
+	breaker := r.breakers[name]
+	r.lock.RUnlock()
+	if breaker != nil {
+		return breaker
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if breaker = r.breakers[name]; breaker != nil {
+		return breaker
+	}
+	opts := make([]Option, 0, len(r.defaults)+len(r.overrides[name])+1)
+	opts = append(opts, r.defaults...)
+	opts = append(opts, r.overrides[name]...)
+	opts = append(opts, WithName(name))
+	breaker = NewCircuitBreaker(opts...)
+	r.breakers[name] = breaker
+	return breaker
+}
+
+// Remove circuit breaker named name, next Get creates a new one. Its overrides are kept.
+func (r *Registry) Remove(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.breakers, name)
+}
+
+// List status of all circuit breakers, sorted by name
+func (r *Registry) List() []Status {
+	r.lock.RLock()
+	breakers := make([]*SyncCircuitBreaker, 0, len(r.breakers))
+	for _, breaker := range r.breakers {
+		breakers = append(breakers, breaker)
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(breakers, func(i, j int) bool {
+		return breakers[i].name < breakers[j].name
+	})
+	result := make([]Status, 0, len(breakers))
+	for _, breaker := range breakers {
+		result = append(result, Status{
+			Name:    breaker.name,
+			State:   breaker.State(),
+			Metrics: breaker.Metrics(),
+		})
+	}
+	return result
+}
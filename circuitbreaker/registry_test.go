@@ -0,0 +1,95 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Get(t *testing.T) {
+	registry := NewRegistry(WithCountWindow(10, 2, 0.5), WithResetTimeout(time.Second))
+	registry.Configure("bar", WithResetTimeout(time.Minute))
+	registry.Configure("bar", WithHalfOpen(2, 2))
+
+	foo := registry.Get("foo")
+	if got := registry.Get("foo"); got != foo {
+		t.Errorf("Get() returns a different circuit breaker of same name")
+	}
+	if got, want := foo.Name(), "foo"; got != want {
+		t.Errorf("Name() = %v, want %v", got, want)
+	}
+	if got, want := foo.resetTimeout, int64(time.Second); got != want {
+		t.Errorf("foo resetTimeout = %v, want %v", got, want)
+	}
+
+	bar := registry.Get("bar")
+	if got, want := bar.resetTimeout, int64(time.Minute); got != want {
+		t.Errorf("bar resetTimeout = %v, want %v", got, want)
+	}
+	if bar.halfOpenMaxCalls != 2 || bar.halfOpenSuccessCalls != 2 {
+		t.Errorf("bar halfOpenMaxCalls = %v, halfOpenSuccessCalls = %v, want 2, 2", bar.halfOpenMaxCalls, bar.halfOpenSuccessCalls)
+	}
+	// each circuit breaker has its own window
+	if foo.failureRate.window == bar.failureRate.window {
+		t.Errorf("foo and bar share the same window")
+	}
+
+	registry.Remove("foo")
+	if got := registry.Get("foo"); got == foo {
+		t.Errorf("Get() after Remove() returns the removed circuit breaker")
+	}
+}
+
+func TestRegistry_Get_concurrent(t *testing.T) {
+	registry := NewRegistry()
+	var wg sync.WaitGroup
+	breakers := make([]*SyncCircuitBreaker, 10)
+	for i := range breakers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			breakers[i] = registry.Get("foo")
+		}(i)
+	}
+	wg.Wait()
+	for _, b := range breakers {
+		if b != breakers[0] {
+			t.Fatalf("Get() created more than one circuit breaker of same name")
+		}
+	}
+}
+
+func TestRegistry_List(t *testing.T) {
+	registry := NewRegistry(WithFailureThreshold(1))
+	registry.Get("foo")
+	registry.Get("bar").Do(func() error {
+		return errors.New("on purpose")
+	}, func(error) {}, func() {})
+
+	want := []Status{
+		{Name: "bar", State: StateOpen, Metrics: Metrics{Calls: 1, Failures: 1}},
+		{Name: "foo", State: StateClosed},
+	}
+	if got := registry.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+// 滑动窗口，统计窗口内的调用次数和失败次数
+type failureWindow interface {
+	// record a call
+	record(now int64, failed bool)
+	// calls and failures in the window
+	stats(now int64) (calls, failures int)
+	// clear all records
+	reset()
+}
+
+// 基于次数的滑动窗口，统计最近 size 次调用
+type countWindow struct {
+	outcomes []bool // 环形缓冲区，true表示失败
+	next     int    // 下一个写入位置
+	calls    int    // 已记录的调用次数，不超过 len(outcomes)
+	failures int
+}
+
+func newCountWindow(size int) *countWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &countWindow{outcomes: make([]bool, size)}
+}
+
+func (c *countWindow) record(now int64, failed bool) {
+	if c.calls == len(c.outcomes) {
+		// 覆盖最老的记录
+		if c.outcomes[c.next] {
+			c.failures--
+		}
+	} else {
+		c.calls++
+	}
+	c.outcomes[c.next] = failed
+	if failed {
+		c.failures++
+	}
+	c.next = (c.next + 1) % len(c.outcomes)
+}
+
+func (c *countWindow) stats(now int64) (calls, failures int) {
+	return c.calls, c.failures
+}
+
+func (c *countWindow) reset() {
+	for i := range c.outcomes {
+		c.outcomes[i] = false
+	}
+	c.next, c.calls, c.failures = 0, 0, 0
+}
+
+// how many buckets a timeWindow is split into
+const timeWindowBuckets = 10
+
+// 基于时间的滑动窗口，统计最近 windowSize 时间内的调用，窗口被分成 timeWindowBuckets 个桶，
+// 过期的桶整个丢弃，所以精度是 windowSize / timeWindowBuckets
+type timeWindow struct {
+	buckets    []timeBucket
+	bucketSize int64
+}
+
+type timeBucket struct {
+	start    int64 // 桶的开始时间
+	calls    int
+	failures int
+}
+
+func newTimeWindow(windowSize int64) *timeWindow {
+	bucketSize := windowSize / timeWindowBuckets
+	if bucketSize < 1 {
+		bucketSize = 1
+	}
+	return &timeWindow{
+		buckets:    make([]timeBucket, timeWindowBuckets),
+		bucketSize: bucketSize,
+	}
+}
+
+func (t *timeWindow) record(now int64, failed bool) {
+	start := now - now%t.bucketSize
+	b := &t.buckets[(start/t.bucketSize)%timeWindowBuckets]
+	if b.start != start {
+		*b = timeBucket{start: start}
+	}
+	b.calls++
+	if failed {
+		b.failures++
+	}
+}
+
+func (t *timeWindow) stats(now int64) (calls, failures int) {
+	// 当前桶和之前的 timeWindowBuckets - 1 个桶
+	oldest := now - now%t.bucketSize - (timeWindowBuckets-1)*t.bucketSize
+	for _, b := range t.buckets {
+		if b.start >= oldest && b.start <= now {
+			calls += b.calls
+			failures += b.failures
+		}
+	}
+	return calls, failures
+}
+
+func (t *timeWindow) reset() {
+	for i := range t.buckets {
+		t.buckets[i] = timeBucket{}
+	}
+}
+
+// 失败率阈值
+type failureRate struct {
+	window       failureWindow
+	minimumCalls int     // 窗口内至少有这么多次调用才计算失败率
+	threshold    float64 // 失败率达到这个值就断开
+}
+
+// whether failure rate in the window reaches threshold
+func (f *failureRate) exceeded(now int64) bool {
+	calls, failures := f.window.stats(now)
+	if calls == 0 || calls < f.minimumCalls {
+		return false
+	}
+	return float64(failures)/float64(calls) >= f.threshold
+}
@@ -0,0 +1,103 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_countWindow(t *testing.T) {
+	w := newCountWindow(3)
+	assertStats := func(wantCalls, wantFailures int) {
+		t.Helper()
+		if calls, failures := w.stats(0); calls != wantCalls || failures != wantFailures {
+			t.Errorf("stats() = %v, %v, want %v, %v", calls, failures, wantCalls, wantFailures)
+		}
+	}
+
+	assertStats(0, 0)
+	w.record(0, true)
+	w.record(0, false)
+	assertStats(2, 1)
+	w.record(0, true)
+	assertStats(3, 2)
+	// the oldest failure is evicted
+	w.record(0, false)
+	assertStats(3, 1)
+	w.record(0, false)
+	assertStats(3, 1)
+	w.record(0, false)
+	assertStats(3, 0)
+
+	w.record(0, true)
+	w.reset()
+	assertStats(0, 0)
+}
+
+func Test_timeWindow(t *testing.T) {
+	w := newTimeWindow(int64(10 * time.Second))
+	now := time.Unix(1600002000, 0).UnixNano()
+	second := int64(time.Second)
+	assertStats := func(now int64, wantCalls, wantFailures int) {
+		t.Helper()
+		if calls, failures := w.stats(now); calls != wantCalls || failures != wantFailures {
+			t.Errorf("stats() = %v, %v, want %v, %v", calls, failures, wantCalls, wantFailures)
+		}
+	}
+
+	w.record(now, true)
+	w.record(now+second/2, false)
+	w.record(now+5*second, true)
+	assertStats(now+5*second, 3, 2)
+	assertStats(now+9*second, 3, 2)
+	// the first bucket expired
+	assertStats(now+10*second, 1, 1)
+	assertStats(now+15*second, 0, 0)
+
+	// bucket is reused after a round
+	w.record(now+20*second, false)
+	assertStats(now+20*second, 1, 0)
+
+	w.reset()
+	assertStats(now+20*second, 0, 0)
+}
+
+func Test_failureRate_exceeded(t *testing.T) {
+	f := &failureRate{
+		window:       newCountWindow(10),
+		minimumCalls: 4,
+		threshold:    0.5,
+	}
+	f.window.record(0, true)
+	f.window.record(0, true)
+	f.window.record(0, true)
+	if f.exceeded(0) {
+		t.Errorf("exceeded() = true before minimumCalls, want false")
+	}
+	f.window.record(0, false)
+	if !f.exceeded(0) {
+		t.Errorf("exceeded() = false, want true")
+	}
+	f.window.record(0, false)
+	f.window.record(0, false)
+	f.window.record(0, false)
+	if f.exceeded(0) {
+		t.Errorf("exceeded() = true, want false")
+	}
+}
@@ -64,6 +64,22 @@ type Interface interface {
 	ResetKey(key string)
 }
 
+// be called after config key's value changed, value is converted by ValueProcessor
+type Listener func(key string, value interface{})
+
+// optional interface of Interface, implemented by NewStore, notify changes of config key-values
+type Observable interface {
+	// add a listener of key, it is called after key's value changed by
+	// Update, UpdateNoPersist, BatchUpdate, ResetKey or loaded from Persister,
+	// because Persister.Load sets values by UpdateNoPersist.
+	//
+	// Values in Persister are loaded only when values are read, depends on LoadPolicy.
+	//
+	// Listeners are called one at a time, a change older than the one already notified is not notified,
+	// so don't change the store in them.
+	AddListener(key string, listener Listener)
+}
+
 // used to validate string value and convert string value to specific type
 type ValueProcessor interface {
 	Validate(value string) (ok bool, err string)
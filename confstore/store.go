@@ -17,8 +17,15 @@ type defaultImpl struct {
 	kvDefaultStr map[string]string
 	kv           map[string]interface{}
 	kvStr        map[string]string
+	kvVersion    uint64 // increased on every change of kv, protected by kvLock
 	persister    Persister
 	loadPolicy   LoadPolicy
+
+	listenerLock *sync.RWMutex
+	listeners    map[string][]Listener
+
+	notifyLock *sync.Mutex
+	notified   map[string]uint64 // kvVersion of the last change notified of each key
 }
 
 func NewStore(persister Persister, policy LoadPolicy) Interface {
@@ -32,6 +39,10 @@ func NewStore(persister Persister, policy LoadPolicy) Interface {
 		kvStr:           make(map[string]string),
 		persister:       persister,
 		loadPolicy:      policy,
+		listenerLock:    &sync.RWMutex{},
+		listeners:       make(map[string][]Listener),
+		notifyLock:      &sync.Mutex{},
+		notified:        make(map[string]uint64),
 	}
 }
 
@@ -87,6 +98,10 @@ func (m *defaultImpl) DeregisterKey(key string) {
 	delete(m.kvDefault, key)
 	delete(m.kvDefaultStr, key)
 
+	m.listenerLock.Lock()
+	delete(m.listeners, key)
+	m.listenerLock.Unlock()
+
 	err := m.persister.Delete(key)
 	if err != nil {
 		simplelog.ErrLogger.Println("perister delete key error:", err)
@@ -160,10 +175,21 @@ func (m *defaultImpl) UpdateNoPersist(key string, value string) *KVError {
 		}
 	}
 
+	v := p.Convert(value)
 	m.kvLock.Lock()
-	m.kv[key] = p.Convert(value)
+	oldValue, hit := m.kvStr[key]
+	if !hit {
+		oldValue = m.kvDefaultStr[key]
+	}
+	m.kv[key] = v
 	m.kvStr[key] = value
+	m.kvVersion++
+	version := m.kvVersion
 	m.kvLock.Unlock()
+
+	if oldValue != value {
+		m.notify(key, v, version)
+	}
 	return nil
 }
 
@@ -254,12 +280,53 @@ func (m *defaultImpl) GetValueString(key string) (v string, hit bool) {
 
 func (m *defaultImpl) ResetKey(key string) {
 	m.kvLock.Lock()
-	defer m.kvLock.Unlock()
-
+	oldValue, changed := m.kvStr[key]
+	changed = changed && oldValue != m.kvDefaultStr[key]
+	v := m.kvDefault[key]
 	delete(m.kv, key)
 	delete(m.kvStr, key)
+	m.kvVersion++
+	version := m.kvVersion
+	m.kvLock.Unlock()
+
+	if changed {
+		m.notify(key, v, version)
+	}
 	err := m.persister.Delete(key)
 	if err != nil {
 		simplelog.ErrLogger.Println("perister delete key error:", err)
 	}
 }
+
+func (m *defaultImpl) AddListener(key string, listener Listener) {
+	if key == "" || listener == nil {
+		return
+	}
+
+	m.listenerLock.Lock()
+	defer m.listenerLock.Unlock()
+
+	m.listeners[key] = append(m.listeners[key], listener)
+}
+
+// call listeners of key, must not be called with any lock held.
+//
+// Concurrent changes of the same key may call notify in any order, so notifications are serialized,
+// and a change older than the one already notified is dropped, listeners always end up with the latest value.
+func (m *defaultImpl) notify(key string, value interface{}, version uint64) {
+	m.notifyLock.Lock()
+	defer m.notifyLock.Unlock()
+
+	if version <= m.notified[key] {
+		return
+	}
+	m.notified[key] = version
+
+	m.listenerLock.RLock()
+	listeners := m.listeners[key]
+	m.listenerLock.RUnlock()
+
+	for _, listener := range listeners {
+		listener(key, value)
+	}
+}
@@ -3,6 +3,8 @@ package confstore
 import (
 	"github.com/chanjarster/gears/testutil"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -499,3 +501,111 @@ func Test_memStore_ResetKey(t *testing.T) {
 
 }
 
+func Test_memStore_AddListener(t *testing.T) {
+
+	m := NewStore(NoopPersister, NoopLoadPolicy)
+
+	m.RegisterKey("foo", "1", Int)
+	m.RegisterKey("bar", "true", Bool)
+
+	var got []interface{}
+	m.(Observable).AddListener("foo", func(key string, value interface{}) {
+		got = append(got, value)
+	})
+
+	m.Update("foo", "2")
+	m.Update("foo", "2")   // not changed
+	m.Update("foo", "abc") // invalid
+	m.UpdateNoPersist("bar", "false")
+	m.BatchUpdate([]*KVStr{{Key: "foo", Value: "3"}, {Key: "bar", Value: "true"}})
+	m.ResetKey("foo")
+	m.ResetKey("foo") // not changed
+
+	want := []interface{}{2, 3, 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got = %v, want %v", got, want)
+	}
+
+	m.DeregisterKey("foo")
+	m.RegisterKey("foo", "1", Int)
+	m.Update("foo", "2")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got = %v after deregister, want %v", got, want)
+	}
+
+}
+
+func Test_memStore_AddListener_concurrent(t *testing.T) {
+
+	m := NewStore(NoopPersister, NoopLoadPolicy)
+	m.RegisterKey("foo", "0", Int)
+
+	var lock sync.Mutex
+	var got interface{}
+	m.(Observable).AddListener("foo", func(key string, value interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		got = value
+	})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.UpdateNoPersist("foo", strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+
+	// listener ends up with the latest value
+	if want, _ := m.GetValue("foo"); got != want {
+		t.Errorf("listener got = %v, want %v", got, want)
+	}
+
+	// older change is dropped
+	var got2 []interface{}
+	m.(Observable).AddListener("bar", func(key string, value interface{}) {
+		got2 = append(got2, value)
+	})
+	m.(*defaultImpl).notify("bar", 2, 1002)
+	m.(*defaultImpl).notify("bar", 1, 1001)
+	if want := []interface{}{2}; !reflect.DeepEqual(got2, want) {
+		t.Errorf("listener got = %v, want %v", got2, want)
+	}
+
+}
+
+type loadingPersister struct {
+	mockPersister
+	kvs []*KVStr
+}
+
+func (l *loadingPersister) Load(s Interface) error {
+	for _, kv := range l.kvs {
+		s.UpdateNoPersist(kv.Key, kv.Value)
+	}
+	return nil
+}
+
+func Test_memStore_AddListener_load(t *testing.T) {
+
+	p := &loadingPersister{kvs: []*KVStr{{Key: "foo", Value: "2"}}}
+	m := NewStore(p, SimpleLoadPolicy)
+	m.RegisterKey("foo", "1", Int)
+
+	var got []interface{}
+	m.(Observable).AddListener("foo", func(key string, value interface{}) {
+		got = append(got, value)
+	})
+
+	// values are loaded when read
+	m.GetValue("foo")
+	m.GetValue("foo")
+
+	want := []interface{}{2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listener got = %v, want %v", got, want)
+	}
+
+}
@@ -29,6 +29,8 @@ type FanOutBus struct {
 	ch          chan interface{}   // internal channel(bidirectional), C is backed by this
 	recvMapLock sync.RWMutex
 	recvMap     map[string]*Receiver
+	closeLock   sync.RWMutex
+	closed      bool
 }
 
 // NewFanOutBus make a new FanOutBus
@@ -82,9 +84,30 @@ func (b *FanOutBus) GoDispatch() {
 	go b.doDispatch()
 }
 
+// TryPublish send event to FanOutBus.C without blocking, return false if FanOutBus.C is full or closed.
+// Unlike sending to FanOutBus.C directly, it never panics after Close.
+func (b *FanOutBus) TryPublish(event interface{}) bool {
+	b.closeLock.RLock()
+	defer b.closeLock.RUnlock()
+
+	if b.closed {
+		return false
+	}
+	select {
+	case b.ch <- event:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close FanOutBus.C, close all Receiver.C, deregister Receivers
 func (b *FanOutBus) Close() {
+	b.closeLock.Lock()
+	b.closed = true
 	close(b.ch)
+	b.closeLock.Unlock()
+
 	b.recvMapLock.Lock()
 	for name, recv := range b.recvMap {
 		close(recv.ch)
@@ -86,6 +86,28 @@ func TestFanOutBus_Close(t *testing.T) {
 	})
 }
 
+func TestFanOutBus_TryPublish(t *testing.T) {
+	bus := NewFanOutBus(1)
+
+	if got, want := bus.TryPublish("foo"), true; got != want {
+		t.Errorf("TryPublish() = %v, want %v", got, want)
+	}
+	// full
+	if got, want := bus.TryPublish("bar"), false; got != want {
+		t.Errorf("TryPublish() = %v, want %v", got, want)
+	}
+	if got, want := <-bus.ch, interface{}("foo"); got != want {
+		t.Errorf("<-bus.ch = %v, want %v", got, want)
+	}
+
+	bus.Close()
+	assert.NotPanics(t, func() {
+		if got, want := bus.TryPublish("foo"), false; got != want {
+			t.Errorf("TryPublish() = %v, want %v", got, want)
+		}
+	})
+}
+
 func TestFanOutBus_NewRecv(t *testing.T) {
 	bus := NewFanOutBus(1)
 	foo := bus.NewRecv("foo", 1)
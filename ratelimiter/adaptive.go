@@ -0,0 +1,258 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	gtime "github.com/chanjarster/gears/util/time"
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimiter limits concurrent(in-flight) requests, the limit is adjusted by
+// observed latency and failures of requests, so it's no need to tune a static capacity.
+type AdaptiveLimiter interface {
+	// SetCapacity set max limit
+	Configurable
+
+	// Acquire a permission, return false if in-flight requests reach the limit.
+	// If ok, caller MUST call release when request is done, success tells whether the request is succeeded.
+	Acquire() (release func(success bool), ok bool)
+
+	// Capacity max limit
+	Capacity() int
+
+	// Limit current limit
+	Limit() int
+
+	// InFlight current in-flight requests
+	InFlight() int
+
+	// SetLimitRange pin the limit in [minLimit, maxLimit]
+	SetLimitRange(minLimit, maxLimit int)
+}
+
+// LimitAlgorithm calculates new limit when a request is done.
+//
+// It's called by AdaptiveLimiter in serial, so implementations can hold states without locking.
+type LimitAlgorithm interface {
+	//	limit: current limit
+	//	rtt: latency of the request
+	//	inFlight: in-flight requests when the request began
+	//	success: whether the request is succeeded
+	Update(limit float64, rtt time.Duration, inFlight int, success bool) (newLimit float64)
+}
+
+// NewAimd Additive Increase Multiplicative Decrease algorithm:
+// limit += 1 if request is succeeded and the limit is utilized,
+// limit *= backoffRatio if request is failed or rtt > timeout.
+//
+//	backoffRatio: in (0, 1), e.g. 0.9
+//	timeout: requests slower than timeout are treated as failures, <= 0 means no timeout
+func NewAimd(backoffRatio float64, timeout time.Duration) LimitAlgorithm {
+	return &aimd{
+		backoffRatio: backoffRatio,
+		timeout:      timeout,
+	}
+}
+
+type aimd struct {
+	backoffRatio float64
+	timeout      time.Duration
+}
+
+func (a *aimd) Update(limit float64, rtt time.Duration, inFlight int, success bool) float64 {
+	if !success || (a.timeout > 0 && rtt > a.timeout) {
+		return limit * a.backoffRatio
+	}
+	// 只有在limit被充分利用的时候才增加，否则limit会无限增长
+	if float64(inFlight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// NewGradient Gradient algorithm, similar to TCP Vegas, it compares short-term rtt(latest request)
+// with long-term rtt(exponential moving average), if short-term rtt increases, which means requests are queueing,
+// decrease the limit proportionally:
+//
+//	gradient = max(0.5, min(1, tolerance * longRtt / shortRtt))
+//	newLimit = limit * gradient + sqrt(limit)
+//	limit = limit * (1 - smoothing) + newLimit * smoothing
+//
+// Failed requests are treated as extremely slow.
+//
+//	tolerance: how much latency increasing is tolerated, >= 1, e.g. 1.5 means 50% increasing
+//	smoothing: in (0, 1], e.g. 0.2
+//	window: how many samples long-term rtt is averaged over, e.g. 600
+func NewGradient(tolerance, smoothing float64, window int) LimitAlgorithm {
+	return &gradient{
+		tolerance: tolerance,
+		smoothing: smoothing,
+		window:    window,
+	}
+}
+
+type gradient struct {
+	tolerance float64
+	smoothing float64
+	window    int
+	samples   int
+	longRtt   float64 // exponential moving average of rtt
+}
+
+func (g *gradient) Update(limit float64, rtt time.Duration, inFlight int, success bool) float64 {
+	if !success {
+		return limit * 0.5
+	}
+
+	shortRtt := float64(rtt)
+	if shortRtt <= 0 {
+		shortRtt = 1
+	}
+	if g.samples < g.window {
+		// 预热阶段使用算术平均
+		g.samples++
+		g.longRtt += (shortRtt - g.longRtt) / float64(g.samples)
+	} else {
+		g.longRtt += (shortRtt - g.longRtt) * 2 / float64(g.window+1)
+	}
+
+	// 没有充分利用limit的时候，不增加limit
+	if float64(inFlight)*2 < limit {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.longRtt/shortRtt))
+	newLimit := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + newLimit*g.smoothing
+}
+
+// NewSyncAdaptiveLimiter New a SyncAdaptiveLimiter
+//
+//	initLimit: initial limit
+//	minLimit: limit never be less than it, should be >= 1, otherwise all requests may be rejected forever
+//	maxLimit: limit never be greater than it
+//	algorithm: how to adjust limit, see NewAimd, NewGradient
+func NewSyncAdaptiveLimiter(initLimit, minLimit, maxLimit int, algorithm LimitAlgorithm) *SyncAdaptiveLimiter {
+	s := &SyncAdaptiveLimiter{
+		minLimit:  minLimit,
+		maxLimit:  maxLimit,
+		algorithm: algorithm,
+		nowFn:     gtime.SysNow,
+	}
+	s.limit = s.clamp(float64(initLimit))
+	return s
+}
+
+// AdaptiveLimiter implementation using "sync.Mutex"
+type SyncAdaptiveLimiter struct {
+	lock      sync.Mutex
+	limit     float64 // 当前limit，小数部分用于平滑调整
+	inFlight  int
+	minLimit  int
+	maxLimit  int
+	algorithm LimitAlgorithm
+	nowFn     gtime.NowFunc
+	observable
+}
+
+func (s *SyncAdaptiveLimiter) Acquire() (release func(success bool), ok bool) {
+	release, ok = s.acquire()
+	s.observe(1, ok)
+	return release, ok
+}
+
+func (s *SyncAdaptiveLimiter) acquire() (release func(success bool), ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.inFlight >= int(s.limit) {
+		return nil, false
+	}
+	s.inFlight++
+
+	inFlight := s.inFlight
+	start := s.nowFn().UnixNano()
+	var once sync.Once
+	return func(success bool) {
+		once.Do(func() {
+			s.release(time.Duration(s.nowFn().UnixNano()-start), inFlight, success)
+		})
+	}, true
+}
+
+func (s *SyncAdaptiveLimiter) Capacity() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.maxLimit
+}
+
+func (s *SyncAdaptiveLimiter) SetCapacity(newCap int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.maxLimit = newCap
+	s.limit = s.clamp(s.limit)
+}
+
+func (s *SyncAdaptiveLimiter) SetLimitRange(minLimit, maxLimit int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.minLimit = minLimit
+	s.maxLimit = maxLimit
+	s.limit = s.clamp(s.limit)
+}
+
+func (s *SyncAdaptiveLimiter) Limit() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return int(s.limit)
+}
+
+func (s *SyncAdaptiveLimiter) InFlight() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.inFlight
+}
+
+// Usage ratio of in flight requests to current limit
+func (s *SyncAdaptiveLimiter) Usage() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return usageRatio(float64(s.inFlight), float64(int(s.limit)))
+}
+
+func (s *SyncAdaptiveLimiter) release(rtt time.Duration, inFlight int, success bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.inFlight--
+	s.limit = s.clamp(s.algorithm.Update(s.limit, rtt, inFlight, success))
+}
+
+// limit in [minLimit, maxLimit], must hold s.lock
+func (s *SyncAdaptiveLimiter) clamp(limit float64) float64 {
+	if limit > float64(s.maxLimit) {
+		limit = float64(s.maxLimit)
+	}
+	if limit < float64(s.minLimit) {
+		limit = float64(s.minLimit)
+	}
+	return limit
+}
@@ -0,0 +1,175 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncAdaptiveLimiter_Acquire(t *testing.T) {
+	s := NewSyncAdaptiveLimiter(2, 1, 10, NewAimd(0.5, 0))
+
+	release1, ok := s.Acquire()
+	if !ok {
+		t.Errorf("Acquire() ok = %v, want %v", ok, true)
+	}
+	release2, ok := s.Acquire()
+	if !ok {
+		t.Errorf("Acquire() ok = %v, want %v", ok, true)
+	}
+	if _, ok := s.Acquire(); ok {
+		t.Errorf("Acquire() ok = %v, want %v", ok, false)
+	}
+	if got, want := s.InFlight(), 2; got != want {
+		t.Errorf("InFlight() = %v, want %v", got, want)
+	}
+
+	release1(true)
+	// 重复release没有影响
+	release1(true)
+	if got, want := s.InFlight(), 1; got != want {
+		t.Errorf("InFlight() = %v, want %v", got, want)
+	}
+	if got, want := s.Limit(), 3; got != want {
+		t.Errorf("Limit() = %v, want %v", got, want)
+	}
+
+	release2(false)
+	if got, want := s.InFlight(), 0; got != want {
+		t.Errorf("InFlight() = %v, want %v", got, want)
+	}
+	if got, want := s.Limit(), 1; got != want {
+		t.Errorf("Limit() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncAdaptiveLimiter_SetLimitRange(t *testing.T) {
+	s := NewSyncAdaptiveLimiter(20, 1, 10, NewAimd(0.5, 0))
+	if got, want := s.Limit(), 10; got != want {
+		t.Errorf("Limit() = %v, want %v", got, want)
+	}
+	if got, want := s.Capacity(), 10; got != want {
+		t.Errorf("Capacity() = %v, want %v", got, want)
+	}
+
+	s.SetCapacity(5)
+	if got, want := s.Limit(), 5; got != want {
+		t.Errorf("Limit() = %v, want %v", got, want)
+	}
+
+	s.SetLimitRange(8, 20)
+	if got, want := s.Limit(), 8; got != want {
+		t.Errorf("Limit() = %v, want %v", got, want)
+	}
+	for i := 0; i < 100; i++ {
+		release, _ := s.Acquire()
+		release(false)
+	}
+	if got, want := s.Limit(), 8; got != want {
+		t.Errorf("Limit() = %v, want %v", got, want)
+	}
+}
+
+func Test_aimd_Update(t *testing.T) {
+	a := NewAimd(0.9, 100*time.Millisecond)
+	tests := []struct {
+		name     string
+		limit    float64
+		rtt      time.Duration
+		inFlight int
+		success  bool
+		want     float64
+	}{
+		{"success, utilized", 10, time.Millisecond, 5, true, 11},
+		{"success, not utilized", 10, time.Millisecond, 4, true, 10},
+		{"failure", 10, time.Millisecond, 5, false, 9},
+		{"timeout", 10, 101 * time.Millisecond, 5, true, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.Update(tt.limit, tt.rtt, tt.inFlight, tt.success); got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gradient_Update(t *testing.T) {
+
+	t.Run("stable latency increases limit", func(t *testing.T) {
+		g := NewGradient(1.5, 1, 10)
+		limit := 16.0
+		for i := 0; i < 10; i++ {
+			limit = g.Update(limit, 10*time.Millisecond, int(limit), true)
+		}
+		if limit <= 16 {
+			t.Errorf("limit = %v, want > 16", limit)
+		}
+	})
+
+	t.Run("increasing latency decreases limit", func(t *testing.T) {
+		g := NewGradient(1.5, 1, 10)
+		limit := 100.0
+		for i := 0; i < 10; i++ {
+			limit = g.Update(limit, 10*time.Millisecond, int(limit), true)
+		}
+		stable := limit
+		limit = g.Update(limit, 100*time.Millisecond, int(limit), true)
+		if want := stable*0.5 + 20; limit > want {
+			t.Errorf("limit = %v, want <= %v", limit, want)
+		}
+	})
+
+	t.Run("not utilized", func(t *testing.T) {
+		g := NewGradient(1.5, 1, 10)
+		if got := g.Update(16, 10*time.Millisecond, 1, true); got != 16 {
+			t.Errorf("Update() = %v, want %v", got, 16)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		g := NewGradient(1.5, 1, 10)
+		if got := g.Update(16, 10*time.Millisecond, 16, false); got != 8 {
+			t.Errorf("Update() = %v, want %v", got, 8)
+		}
+	})
+}
+
+func TestSyncAdaptiveLimiter_concurrent(t *testing.T) {
+	s := NewSyncAdaptiveLimiter(10, 1, 20, NewGradient(1.5, 0.2, 100))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if release, ok := s.Acquire(); ok {
+					if got := s.InFlight(); got > 20 {
+						t.Errorf("InFlight() = %v, want <= %v", got, 20)
+					}
+					release(j%10 != 0)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := s.InFlight(), 0; got != want {
+		t.Errorf("InFlight() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	gtime "github.com/chanjarster/gears/util/time"
+	"sync"
+	"time"
+)
+
+// Rule at most Capacity requests in WindowSize, rule with Capacity <= 0 or WindowSize <= 0 is ignored
+type Rule struct {
+	Capacity   int
+	WindowSize time.Duration
+}
+
+// RuleOf convert TtlRateLimiterParams to Rule, timeout is ignored
+func RuleOf(params TtlRateLimiterParams) Rule {
+	return Rule{
+		Capacity:   params.GetCapacity(),
+		WindowSize: time.Duration(params.GetWindowSizeSeconds()) * time.Second,
+	}
+}
+
+// Tier rules applied on a key, e.g.
+//
+//	Tier{Key: "user:1", Rules: []Rule{{10, time.Second}, {500, time.Hour}, {5000, 24 * time.Hour}}}
+//	Tier{Key: "tenant:a", Rules: []Rule{{1000, time.Second}}}
+type Tier struct {
+	Key   string
+	Rules []Rule
+}
+
+// CompositeResult result of CompositeLimiter
+type CompositeResult struct {
+	Acquired   bool
+	Key        string        // key of the first rejecting tier
+	Rule       Rule          // the first rejecting rule
+	RetryAfter time.Duration // how long to wait until all rules pass
+}
+
+// CompositeLimiter evaluates multiple tiers of rules in one atomic step,
+// permissions are consumed only when all rules of all tiers pass.
+//
+// Each rule is a sliding window counter, same as SyncSlidingWindowCounter.
+type CompositeLimiter interface {
+	// Acquire 1 permission from all tiers
+	Acquire(tiers ...Tier) *CompositeResult
+
+	// AcquireContext same as Acquire, returns error if limiter backend failed
+	AcquireContext(ctx context.Context, tiers ...Tier) (*CompositeResult, error)
+}
+
+// how often SyncCompositeLimiter purges idle counters
+const syncCompositeSweepInterval = int64(time.Minute)
+
+// NewSyncCompositeLimiter New a in-memory CompositeLimiter
+func NewSyncCompositeLimiter() *SyncCompositeLimiter {
+	return &SyncCompositeLimiter{
+		counters: make(map[compositeCounterKey]*windowCounter),
+		nowFn:    gtime.SysNow,
+	}
+}
+
+type compositeCounterKey struct {
+	key        string
+	windowSize int64
+}
+
+// SyncCompositeLimiter in-memory CompositeLimiter, a counter is kept for each key and window size
+type SyncCompositeLimiter struct {
+	lock      sync.Mutex
+	counters  map[compositeCounterKey]*windowCounter
+	nextSweep int64
+	nowFn     gtime.NowFunc
+	observable
+}
+
+func (s *SyncCompositeLimiter) Acquire(tiers ...Tier) *CompositeResult {
+	result, _ := s.AcquireContext(context.Background(), tiers...)
+	return result
+}
+
+func (s *SyncCompositeLimiter) AcquireContext(ctx context.Context, tiers ...Tier) (*CompositeResult, error) {
+	result, err := s.acquire(ctx, tiers...)
+	if err == nil {
+		s.observe(1, result.Acquired)
+	}
+	return result, err
+}
+
+func (s *SyncCompositeLimiter) acquire(ctx context.Context, tiers ...Tier) (*CompositeResult, error) {
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sweep(now)
+
+	result := &CompositeResult{Acquired: true}
+	// same key and window size in different tiers share the counter, it should be consumed only once
+	passed := make(map[*windowCounter]bool)
+	for _, tier := range tiers {
+		for _, rule := range tier.Rules {
+			if rule.Capacity <= 0 || rule.WindowSize <= 0 {
+				continue
+			}
+			windowSize := int64(rule.WindowSize)
+			counterKey := compositeCounterKey{key: tier.Key, windowSize: windowSize}
+			counter := s.counters[counterKey]
+			if counter == nil {
+				counter = &windowCounter{}
+				s.counters[counterKey] = counter
+			}
+			counter.slide(now, windowSize)
+			delay := counter.delay(now, rule.Capacity, windowSize, 1)
+			if delay == 0 {
+				passed[counter] = true
+				continue
+			}
+			if result.Acquired {
+				result.Acquired = false
+				result.Key = tier.Key
+				result.Rule = rule
+			}
+			if time.Duration(delay) > result.RetryAfter {
+				result.RetryAfter = time.Duration(delay)
+			}
+		}
+	}
+
+	if result.Acquired {
+		for counter := range passed {
+			counter.currCount++
+		}
+	}
+	return result, nil
+}
+
+// purge counters idle for more than 2 windows periodically, must be called with lock held
+func (s *SyncCompositeLimiter) sweep(now int64) {
+	if now < s.nextSweep {
+		return
+	}
+	s.nextSweep = now + syncCompositeSweepInterval
+	for counterKey, counter := range s.counters {
+		if now-counter.currStart >= 2*counterKey.windowSize {
+			delete(s.counters, counterKey)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	"github.com/chanjarster/gears/simplelog"
+	gtime "github.com/chanjarster/gears/util/time"
+	"github.com/go-redis/redis/v7"
+	"strconv"
+	"time"
+)
+
+const (
+	compositePrefix = "_rl:cp:"
+
+	// a hash for each key and window size: {s: current interval start, c: current count, p: previous count},
+	// check all of them first, consume only if all passed. time is in milliseconds.
+	//
+	// return acquired, index of first rejecting key, delay(milliseconds)
+	compositeScript = `local now = tonumber(ARGV[1])
+local states = {}
+local rejected = 0
+local delay = 0
+
+for i, key in ipairs(KEYS)
+do
+  local cap = tonumber(ARGV[i * 2])
+  local win = tonumber(ARGV[i * 2 + 1])
+  local start = now - now % win
+
+  local st = redis.call('HMGET', key, 's', 'c', 'p')
+  local s = tonumber(st[1]) or 0
+  local c = tonumber(st[2]) or 0
+  local p = tonumber(st[3]) or 0
+  if start > s
+  then
+    if start - s == win
+    then
+      p = c
+    else
+      p = 0
+    end
+    c = 0
+    s = start
+  end
+  states[i] = {s, c, p}
+
+  local d = 0
+  local room = cap - c - 1
+  if room >= 0
+  then
+    if p * (1 - (now - s) / win) > room
+    then
+      d = s + math.ceil(win * (1 - room / p)) - now + 1
+    end
+  else
+    d = s + win + math.ceil(win * (1 - (cap - 1) / c)) - now + 1
+  end
+
+  if d > 0
+  then
+    if rejected == 0
+    then
+      rejected = i
+    end
+    if d > delay
+    then
+      delay = d
+    end
+  end
+end
+
+if rejected > 0
+then
+  return {0, rejected - 1, delay}
+end
+
+-- same key appears more than once is consumed only once, for states are read before any writing
+for i, key in ipairs(KEYS)
+do
+  local st = states[i]
+  redis.call('HMSET', key, 's', string.format('%.0f', st[1]), 'c', st[2] + 1, 'p', st[3])
+  redis.call('PEXPIRE', key, tonumber(ARGV[i * 2 + 1]) * 2)
+end
+return {1, -1, 0}
+`
+)
+
+var (
+	compositeScriptSha = ""
+)
+
+// NewRedisCompositeLimiter New a CompositeLimiter backed by redis, all rules are evaluated in one script.
+//
+// Call LoadScript before using it.
+//
+// Precision of window size is millisecond.
+func NewRedisCompositeLimiter(redisClient *redis.Client) CompositeLimiter {
+	return &redisCompositeLimiter{
+		redisClient: redisClient,
+		nowFn:       gtime.SysNow,
+	}
+}
+
+// NewRedisCompositeLimiterCluster New a redis CompositeLimiter for Redis Cluster environment.
+//
+//	hashTag: redis hash tag value, keys of all tiers must be in the same slot to be evaluated in one script.
+//
+// see: https://redis.io/topics/cluster-tutorial#redis-cluster-data-sharding
+func NewRedisCompositeLimiterCluster(redisClient *redis.Client, hashTag string) CompositeLimiter {
+	return &redisCompositeLimiter{
+		redisClient: redisClient,
+		hashTag:     formatHashTag(hashTag),
+		nowFn:       gtime.SysNow,
+	}
+}
+
+type redisCompositeLimiter struct {
+	redisClient *redis.Client
+	hashTag     string
+	nowFn       gtime.NowFunc
+	observable
+}
+
+func (r *redisCompositeLimiter) Acquire(tiers ...Tier) *CompositeResult {
+	result, err := r.AcquireContext(context.Background(), tiers...)
+	if err != nil {
+		simplelog.ErrLogger.Println("eval compositeScript ", compositeScriptSha, "error", err)
+		r.observe(1, true)
+		return &CompositeResult{Acquired: true}
+	}
+	return result
+}
+
+func (r *redisCompositeLimiter) AcquireContext(ctx context.Context, tiers ...Tier) (*CompositeResult, error) {
+	result, err := r.acquire(ctx, tiers...)
+	if err == nil {
+		r.observe(1, result.Acquired)
+	}
+	return result, err
+}
+
+func (r *redisCompositeLimiter) acquire(ctx context.Context, tiers ...Tier) (*CompositeResult, error) {
+	type keyRule struct {
+		key  string
+		rule Rule
+	}
+	var (
+		keyRules []keyRule
+		keys     []string
+	)
+	for _, tier := range tiers {
+		for _, rule := range tier.Rules {
+			if rule.Capacity <= 0 || rule.WindowSize.Milliseconds() <= 0 {
+				continue
+			}
+			keyRules = append(keyRules, keyRule{key: tier.Key, rule: rule})
+			keys = append(keys, compositePrefix+tier.Key+":"+strconv.FormatInt(rule.WindowSize.Milliseconds(), 10)+r.hashTag)
+		}
+	}
+	if len(keys) == 0 {
+		return &CompositeResult{Acquired: true}, nil
+	}
+
+	//local now = tonumber(ARGV[1])
+	//local cap = tonumber(ARGV[i * 2])
+	//local win = tonumber(ARGV[i * 2 + 1])
+	args := make([]interface{}, 0, 1+len(keyRules)*2)
+	args = append(args, r.nowFn().UnixNano()/int64(time.Millisecond))
+	for _, kr := range keyRules {
+		args = append(args, kr.rule.Capacity, kr.rule.WindowSize.Milliseconds())
+	}
+
+	raw, err := r.redisClient.WithContext(ctx).EvalSha(compositeScriptSha, keys, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	arr := raw.([]interface{})
+	if arr[0].(int64) == 1 {
+		return &CompositeResult{Acquired: true}, nil
+	}
+	rejected := keyRules[arr[1].(int64)]
+	return &CompositeResult{
+		Key:        rejected.key,
+		Rule:       rejected.rule,
+		RetryAfter: time.Duration(arr[2].(int64)) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,56 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"github.com/chanjarster/gears/confs"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_redisCompositeLimiter(t *testing.T) {
+
+	val, hit := os.LookupEnv("INTEGRATION_TEST")
+	if !hit || val != "true" {
+		t.Skip("skip integration test")
+	}
+
+	redisClient := confs.NewRedisClient(&confs.RedisConf{
+		Host:     "localhost",
+		Port:     6379,
+		Password: "",
+		Pool:     10,
+		MinIdle:  1,
+	}, nil)
+	redisClient.FlushAll()
+	defer redisClient.Close()
+
+	LoadScript(redisClient)
+
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+	limiter := NewRedisCompositeLimiter(redisClient).(*redisCompositeLimiter)
+	limiter.nowFn = clock.Now
+	testCompositeLimiter(t, limiter, clock, 2*time.Millisecond)
+
+	redisClient.FlushAll()
+	clock = &mockTtlClock{now: time.Unix(1600002000, 0)}
+	limiter = NewRedisCompositeLimiterCluster(redisClient, "tag").(*redisCompositeLimiter)
+	limiter.nowFn = clock.Now
+	testCompositeLimiter(t, limiter, clock, 2*time.Millisecond)
+}
@@ -0,0 +1,99 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testCompositeLimiter all CompositeLimiter implementations must pass.
+//
+//	clock: time of the limiter, starts at a time aligned to hour
+//	precision: RetryAfter can be longer than expected by precision
+func testCompositeLimiter(t *testing.T, limiter CompositeLimiter, clock *mockTtlClock, precision time.Duration) {
+	user := func(id string) Tier {
+		return Tier{Key: "user:" + id, Rules: []Rule{{2, time.Second}, {3, time.Minute}, {0, time.Hour}}}
+	}
+	tenant := Tier{Key: "tenant:a", Rules: []Rule{{4, time.Second}}}
+
+	steps := []struct {
+		sleep time.Duration
+		tiers []Tier
+		want  CompositeResult
+	}{
+		{tiers: nil, want: CompositeResult{Acquired: true}},
+		{tiers: []Tier{user("1"), tenant}, want: CompositeResult{Acquired: true}},
+		{tiers: []Tier{user("1"), tenant}, want: CompositeResult{Acquired: true}},
+		// wait until the weight of current interval drops to 1
+		{tiers: []Tier{user("1"), tenant}, want: CompositeResult{Key: "user:1", Rule: Rule{2, time.Second}, RetryAfter: 1500 * time.Millisecond}},
+		{tiers: []Tier{user("2"), tenant}, want: CompositeResult{Acquired: true}},
+		{tiers: []Tier{user("2"), tenant}, want: CompositeResult{Acquired: true}},
+		{tiers: []Tier{user("3"), tenant}, want: CompositeResult{Key: "tenant:a", Rule: Rule{4, time.Second}, RetryAfter: 1250 * time.Millisecond}},
+		// rejected requests consumed nothing
+		{sleep: 2 * time.Second, tiers: []Tier{user("1"), tenant}, want: CompositeResult{Acquired: true}},
+		{tiers: []Tier{user("1"), tenant}, want: CompositeResult{Key: "user:1", Rule: Rule{3, time.Minute}, RetryAfter: 78 * time.Second}},
+		{tiers: []Tier{user("3"), tenant}, want: CompositeResult{Acquired: true}},
+		// same key in different tiers consumed only once
+		{sleep: 2 * time.Second, tiers: []Tier{user("4"), user("4")}, want: CompositeResult{Acquired: true}},
+		{tiers: []Tier{user("4")}, want: CompositeResult{Acquired: true}},
+	}
+
+	for i, step := range steps {
+		if step.sleep != 0 {
+			clock.Sleep(step.sleep)
+		}
+		got, err := limiter.AcquireContext(context.Background(), step.tiers...)
+		if err != nil {
+			t.Fatalf("#%d AcquireContext() error = %v", i, err)
+		}
+		want := step.want
+		// RetryAfter is in [want, want + precision]
+		if got.RetryAfter >= want.RetryAfter && got.RetryAfter <= want.RetryAfter+precision {
+			want.RetryAfter = got.RetryAfter
+		}
+		if *got != want {
+			t.Errorf("#%d AcquireContext() = %+v, want %+v", i, *got, step.want)
+		}
+	}
+}
+
+func TestSyncCompositeLimiter(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+	limiter := NewSyncCompositeLimiter()
+	limiter.nowFn = clock.Now
+	testCompositeLimiter(t, limiter, clock, time.Microsecond)
+}
+
+func TestSyncCompositeLimiter_sweep(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+	limiter := NewSyncCompositeLimiter()
+	limiter.nowFn = clock.Now
+
+	limiter.Acquire(Tier{Key: "foo", Rules: []Rule{{1, time.Second}, {1, time.Hour}}})
+	if got := len(limiter.counters); got != 2 {
+		t.Errorf("counters = %v, want %v", got, 2)
+	}
+
+	clock.Sleep(time.Minute)
+	limiter.Acquire()
+	if got := len(limiter.counters); got != 1 {
+		t.Errorf("counters = %v, want %v", got, 1)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"errors"
+	"github.com/chanjarster/gears/confstore"
+	"github.com/chanjarster/gears/simplelog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConfstoreKeys config keys of a rate limiter in confstore, with their default values.
+//
+// Capacity is registered with confstore.IntGtZero, window size and timeout with confstore.Duration, e.g. "1m".
+// A key already registered is shared, its default value is not changed.
+type ConfstoreKeys struct {
+	CapacityKey       string
+	DefaultCapacity   int
+	WindowSizeKey     string
+	DefaultWindowSize time.Duration
+	TimeoutKey        string
+	DefaultTimeout    time.Duration
+}
+
+func (k *ConfstoreKeys) registerCapacity(store confstore.Interface) error {
+	return registerConfstoreKey(store, k.CapacityKey, strconv.Itoa(k.DefaultCapacity), confstore.IntGtZero)
+}
+
+func (k *ConfstoreKeys) registerWindowSize(store confstore.Interface) error {
+	return registerConfstoreKey(store, k.WindowSizeKey, k.DefaultWindowSize.String(), confstore.Duration)
+}
+
+func (k *ConfstoreKeys) registerTimeout(store confstore.Interface) error {
+	return registerConfstoreKey(store, k.TimeoutKey, k.DefaultTimeout.String(), confstore.Duration)
+}
+
+func registerConfstoreKey(store confstore.Interface, key string, defaultValue string, vp confstore.ValueProcessor) error {
+	if _, hit := store.GetValue(key); hit {
+		return nil
+	}
+	return store.RegisterKey(key, defaultValue, vp)
+}
+
+// NewConfstoreTtlRateLimiterParams register capacity, window size and timeout keys in store,
+// returns a TtlRateLimiterParams reading current values of them.
+//
+// Precision of window size and timeout is second.
+func NewConfstoreTtlRateLimiterParams(store confstore.Interface, keys ConfstoreKeys) (TtlRateLimiterParams, error) {
+	if err := keys.registerCapacity(store); err != nil {
+		return nil, err
+	}
+	if err := keys.registerWindowSize(store); err != nil {
+		return nil, err
+	}
+	if err := keys.registerTimeout(store); err != nil {
+		return nil, err
+	}
+	return &confstoreTtlRateLimiterParams{store: store, keys: keys}, nil
+}
+
+type confstoreTtlRateLimiterParams struct {
+	store confstore.Interface
+	keys  ConfstoreKeys
+}
+
+func (c *confstoreTtlRateLimiterParams) GetWindowSizeSeconds() int {
+	return int(c.store.MustGetValue(c.keys.WindowSizeKey).(time.Duration) / time.Second)
+}
+
+func (c *confstoreTtlRateLimiterParams) GetCapacity() int {
+	return c.store.MustGetValue(c.keys.CapacityKey).(int)
+}
+
+func (c *confstoreTtlRateLimiterParams) GetTimeoutSeconds() int {
+	return int(c.store.MustGetValue(c.keys.TimeoutKey).(time.Duration) / time.Second)
+}
+
+var errStoreNotObservable = errors.New("ratelimiter: confstore doesn't implement confstore.Observable")
+
+// BindConfigurable register capacity key in store, limiter.SetCapacity is called with current value
+// and whenever the value changes.
+//
+// store must implement confstore.Observable.
+func BindConfigurable(store confstore.Interface, keys ConfstoreKeys, limiter Configurable) error {
+	observable, ok := store.(confstore.Observable)
+	if !ok {
+		return errStoreNotObservable
+	}
+	if err := keys.registerCapacity(store); err != nil {
+		return err
+	}
+
+	observable.AddListener(keys.CapacityKey, func(key string, value interface{}) {
+		limiter.SetCapacity(value.(int))
+	})
+	limiter.SetCapacity(store.MustGetValue(keys.CapacityKey).(int))
+	return nil
+}
+
+// BindConfigurableWindow register capacity and window size keys in store, limiter.UpdateConfig is called with
+// current values and whenever any of them changes. Non-positive window size is ignored.
+//
+// store must implement confstore.Observable.
+func BindConfigurableWindow(store confstore.Interface, keys ConfstoreKeys, limiter ConfigurableWindow) error {
+	observable, ok := store.(confstore.Observable)
+	if !ok {
+		return errStoreNotObservable
+	}
+	if err := keys.registerCapacity(store); err != nil {
+		return err
+	}
+	if err := keys.registerWindowSize(store); err != nil {
+		return err
+	}
+
+	// listeners may be called during store loading values from Persister (by UpdateNoPersist in Persister.Load),
+	// LoadPolicy may be holding its lock, so don't read store in them
+	var lock sync.Mutex
+	capacity := store.MustGetValue(keys.CapacityKey).(int)
+	windowSize := store.MustGetValue(keys.WindowSizeKey).(time.Duration)
+	update := func() {
+		if windowSize <= 0 {
+			simplelog.ErrLogger.Printf("ignore invalid window size of key[%s]: %v\n", keys.WindowSizeKey, windowSize)
+			return
+		}
+		limiter.UpdateConfig(capacity, windowSize)
+	}
+
+	observable.AddListener(keys.CapacityKey, func(key string, value interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		capacity = value.(int)
+		update()
+	})
+	observable.AddListener(keys.WindowSizeKey, func(key string, value interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		windowSize = value.(time.Duration)
+		update()
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	update()
+	return nil
+}
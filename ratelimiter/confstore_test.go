@@ -0,0 +1,114 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"github.com/chanjarster/gears/confstore"
+	"testing"
+	"time"
+)
+
+var testConfstoreKeys = ConfstoreKeys{
+	CapacityKey:       "rl.capacity",
+	DefaultCapacity:   10,
+	WindowSizeKey:     "rl.window",
+	DefaultWindowSize: time.Minute,
+	TimeoutKey:        "rl.timeout",
+	DefaultTimeout:    5 * time.Minute,
+}
+
+func TestNewConfstoreTtlRateLimiterParams(t *testing.T) {
+	store := confstore.NewStore(confstore.NoopPersister, confstore.NoopLoadPolicy)
+	params, err := NewConfstoreTtlRateLimiterParams(store, testConfstoreKeys)
+	if err != nil {
+		t.Fatalf("NewConfstoreTtlRateLimiterParams() error = %v", err)
+	}
+
+	if got := params.GetCapacity(); got != 10 {
+		t.Errorf("GetCapacity() = %v, want %v", got, 10)
+	}
+	if got := params.GetWindowSizeSeconds(); got != 60 {
+		t.Errorf("GetWindowSizeSeconds() = %v, want %v", got, 60)
+	}
+	if got := params.GetTimeoutSeconds(); got != 300 {
+		t.Errorf("GetTimeoutSeconds() = %v, want %v", got, 300)
+	}
+
+	store.Update("rl.capacity", "20")
+	store.Update("rl.window", "1s")
+	store.Update("rl.timeout", "1h")
+	if got := params.GetCapacity(); got != 20 {
+		t.Errorf("GetCapacity() = %v, want %v", got, 20)
+	}
+	if got := params.GetWindowSizeSeconds(); got != 1 {
+		t.Errorf("GetWindowSizeSeconds() = %v, want %v", got, 1)
+	}
+	if got := params.GetTimeoutSeconds(); got != 3600 {
+		t.Errorf("GetTimeoutSeconds() = %v, want %v", got, 3600)
+	}
+
+	// keys are shared
+	if _, err := NewConfstoreTtlRateLimiterParams(store, testConfstoreKeys); err != nil {
+		t.Errorf("NewConfstoreTtlRateLimiterParams() error = %v", err)
+	}
+}
+
+func TestBindConfigurable(t *testing.T) {
+	store := confstore.NewStore(confstore.NoopPersister, confstore.NoopLoadPolicy)
+	limiter := NewAtomicGcra(1, 1)
+	if err := BindConfigurable(store, testConfstoreKeys, limiter); err != nil {
+		t.Fatalf("BindConfigurable() error = %v", err)
+	}
+	if got := limiter.Capacity(); got != 10 {
+		t.Errorf("Capacity() = %v, want %v", got, 10)
+	}
+
+	store.Update("rl.capacity", "20")
+	if got := limiter.Capacity(); got != 20 {
+		t.Errorf("Capacity() = %v, want %v", got, 20)
+	}
+}
+
+func TestBindConfigurableWindow(t *testing.T) {
+	store := confstore.NewStore(confstore.NoopPersister, confstore.NoopLoadPolicy)
+	limiter := NewSyncSlidingWindowCounter(1, time.Second)
+	if err := BindConfigurableWindow(store, testConfstoreKeys, limiter); err != nil {
+		t.Fatalf("BindConfigurableWindow() error = %v", err)
+	}
+	if got := limiter.Capacity(); got != 10 {
+		t.Errorf("Capacity() = %v, want %v", got, 10)
+	}
+	if got := limiter.WindowSize(); got != time.Minute {
+		t.Errorf("WindowSize() = %v, want %v", got, time.Minute)
+	}
+
+	store.Update("rl.capacity", "20")
+	store.Update("rl.window", "1h")
+	if got := limiter.Capacity(); got != 20 {
+		t.Errorf("Capacity() = %v, want %v", got, 20)
+	}
+	if got := limiter.WindowSize(); got != time.Hour {
+		t.Errorf("WindowSize() = %v, want %v", got, time.Hour)
+	}
+
+	// ignored
+	store.Update("rl.window", "0s")
+	if got := limiter.WindowSize(); got != time.Hour {
+		t.Errorf("WindowSize() = %v, want %v", got, time.Hour)
+	}
+}
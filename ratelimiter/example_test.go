@@ -1,7 +1,9 @@
 package ratelimiter
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"time"
 )
 
@@ -23,6 +25,18 @@ func Example_tokenBucketSync() {
 	}
 }
 
+func Example_tokenBucketWait() {
+	bucket := NewAtomicTokenBucket(100, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// acquire 5 permissions, wait if not enough
+	if err := bucket.WaitN(ctx, 5); err != nil {
+		fmt.Println("Canceled", err)
+	} else {
+		fmt.Println("Acquired")
+	}
+}
+
 func Example_slidingWindow() {
 	bucket := NewSyncSlidingWindow(100, time.Second)
 	if bucket.Acquire() {
@@ -32,6 +46,15 @@ func Example_slidingWindow() {
 	}
 }
 
+func Example_slidingWindowCounter() {
+	bucket := NewSyncSlidingWindowCounter(100000, time.Minute)
+	if bucket.Acquire() {
+		fmt.Println("Acquired")
+	} else {
+		fmt.Println("Rejected")
+	}
+}
+
 func Example_fixedWindow() {
 	bucket := NewSyncFixedWindow(100, time.Second)
 	if bucket.Acquire() {
@@ -39,4 +62,95 @@ func Example_fixedWindow() {
 	} else {
 		fmt.Println("Rejected")
 	}
-}
\ No newline at end of file
+}
+func Example_gcra() {
+	// 10 requests per second, allow bursts of 100 requests
+	limiter := NewAtomicGcra(100, 10)
+	if limiter.Acquire() {
+		fmt.Println("Acquired")
+	} else {
+		fmt.Println("Rejected")
+	}
+}
+
+func Example_adaptiveLimiter() {
+	limiter := NewSyncAdaptiveLimiter(20, 5, 200, NewAimd(0.9, time.Second))
+	release, ok := limiter.Acquire()
+	if !ok {
+		fmt.Println("Rejected")
+		return
+	}
+	err := doQuery()
+	release(err == nil)
+}
+
+func doQuery() error {
+	return nil
+}
+
+func Example_keyedLimiter() {
+	// each user has a token bucket, evict users that are idle for 10 minutes
+	limiter := NewKeyedLimiter(TokenBucketFactory(100, 10), WithIdleTimeout(10*time.Minute), WithMaxKeys(100000))
+	if limiter.Acquire("user-1") {
+		fmt.Println("Acquired")
+	} else {
+		fmt.Println("Rejected")
+	}
+}
+
+func Example_ttlRateLimiterAdmin() {
+	// at most 5 login failures in 60 seconds, otherwise block for 300 seconds
+	limiter := NewSyncTtlRateLimiter(NewFixedTtlRateLimiterParams(5, 60, 300), nil)
+	if result := limiter.ShouldBlock("user-1", "too many login failures"); result.Block {
+		fmt.Println("Blocked, retry after", result.Ttl, "seconds")
+	}
+
+	// release a wrongly blocked user
+	admin := limiter.(TtlRateLimiterAdmin)
+	if err := admin.Unblock("user-1"); err != nil {
+		fmt.Println("Unblock error", err)
+	}
+	if err := admin.Reset("user-1"); err != nil {
+		fmt.Println("Reset error", err)
+	}
+}
+
+func Example_compositeLimiter() {
+	limiter := NewSyncCompositeLimiter()
+	// 10/second AND 500/hour AND 5000/day per user, 1000/second per tenant
+	result := limiter.Acquire(
+		Tier{Key: "user:1", Rules: []Rule{{10, time.Second}, {500, time.Hour}, {5000, 24 * time.Hour}}},
+		Tier{Key: "tenant:a", Rules: []Rule{{1000, time.Second}}},
+	)
+	if result.Acquired {
+		fmt.Println("Acquired")
+	} else {
+		fmt.Println("Rejected by", result.Key, "retry after", result.RetryAfter)
+	}
+}
+
+func Example_metricsRegistry() {
+	registry := NewMetricsRegistry()
+	limiter := NewSyncSlidingWindow(100, time.Minute)
+	if _, err := registry.Observe("api", limiter); err != nil {
+		fmt.Println("Observe error", err)
+	}
+
+	// Prometheus scrapes /metrics, expvar is served at /debug/vars
+	http.Handle("/metrics", registry)
+	registry.PublishExpvar("ratelimiter")
+
+	limiter.Acquire()
+}
+
+func Example_leakyBucket() {
+	// call sms api at 10/s, at most 100 calls are queued
+	bucket := NewSyncLeakyBucket(100, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := bucket.Wait(ctx); err != nil {
+		fmt.Println("Rejected", err)
+		return
+	}
+	fmt.Println("Send sms")
+}
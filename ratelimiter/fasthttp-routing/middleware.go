@@ -0,0 +1,216 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Rate limiter middleware for fasthttp-routing.
+package fasthttp_routing
+
+import (
+	"encoding/json"
+	"github.com/chanjarster/gears/ratelimiter"
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderRetryAfter         = "Retry-After"
+	HeaderRateLimitLimit     = "X-RateLimit-Limit"
+	HeaderRateLimitRemaining = "X-RateLimit-Remaining"
+	HeaderRateLimitReset     = "X-RateLimit-Reset"
+
+	defaultMsg = "too many requests"
+)
+
+// KeyFunc extract rate limit key from request, empty key means the request is not limited
+type KeyFunc func(rCtx *routing.Context) string
+
+// ClientIPKey use remote ip of the connection as key.
+// If the service is behind a proxy, use HeaderKey("X-Real-Ip") or similar instead.
+func ClientIPKey() KeyFunc {
+	return func(rCtx *routing.Context) string {
+		return rCtx.RemoteIP().String()
+	}
+}
+
+// HeaderKey use the value of request header `name` as key
+func HeaderKey(name string) KeyFunc {
+	return func(rCtx *routing.Context) string {
+		return string(rCtx.Request.Header.Peek(name))
+	}
+}
+
+// RouteKey use "METHOD:path" as key, e.g. "GET:/user/1".
+// fasthttp-routing doesn't expose the matched route pattern, so it is the request path.
+func RouteKey() KeyFunc {
+	return func(rCtx *routing.Context) string {
+		return string(rCtx.Method()) + ":" + string(rCtx.Path())
+	}
+}
+
+// Rejection describes why a request is rejected
+type Rejection struct {
+	Limit      int           // capacity of the rate limiter
+	RetryAfter time.Duration // how long the client should wait before retry, 0 if unknown
+	Msg        string        // message for client
+}
+
+// RejectHandler write the response for rejected request, headers are already set before it's called
+type RejectHandler func(rCtx *routing.Context, r *Rejection) error
+
+// DefaultRejectHandler respond 429 with json body: {"msg": "...", "ttl": seconds}
+func DefaultRejectHandler(rCtx *routing.Context, r *Rejection) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"msg": r.Msg,
+		"ttl": ceilSeconds(r.RetryAfter),
+	})
+	if err != nil {
+		return err
+	}
+	rCtx.SetStatusCode(fasthttp.StatusTooManyRequests)
+	rCtx.SetContentType("application/json; charset=utf-8")
+	rCtx.SetBody(body)
+	return nil
+}
+
+type options struct {
+	msg           string
+	rejectHandler RejectHandler
+}
+
+type Option func(opts *options)
+
+// WithMsg message for rejected request, for TtlRateLimiter it's the message recorded when first time blocking
+func WithMsg(msg string) Option {
+	return func(opts *options) {
+		opts.msg = msg
+	}
+}
+
+// WithRejectHandler customize the response for rejected request
+func WithRejectHandler(handler RejectHandler) Option {
+	return func(opts *options) {
+		opts.rejectHandler = handler
+	}
+}
+
+func newOptions(opts []Option) *options {
+	options := &options{
+		msg:           defaultMsg,
+		rejectHandler: DefaultRejectHandler,
+	}
+	for _, o := range opts {
+		o(options)
+	}
+	return options
+}
+
+// NewMiddleware limit all requests with one limiter
+func NewMiddleware(limiter ratelimiter.Interface, opts ...Option) routing.Handler {
+	return newMiddleware(nil, func(string) ratelimiter.Interface {
+		return limiter
+	}, opts)
+}
+
+// NewKeyedMiddleware limit requests with limiter of the key extracted by keyFn
+func NewKeyedMiddleware(keyFn KeyFunc, limiters *ratelimiter.KeyedLimiter, opts ...Option) routing.Handler {
+	return newMiddleware(keyFn, limiters.Get, opts)
+}
+
+func newMiddleware(keyFn KeyFunc, getLimiter func(key string) ratelimiter.Interface, opts []Option) routing.Handler {
+	options := newOptions(opts)
+
+	return func(rCtx *routing.Context) error {
+		key := ""
+		if keyFn != nil {
+			if key = keyFn(rCtx); key == "" {
+				return rCtx.Next()
+			}
+		}
+
+		limiter := getLimiter(key)
+		rCtx.Response.Header.Set(HeaderRateLimitLimit, strconv.Itoa(limiter.Capacity()))
+
+		acquired, retryAfter := ratelimiter.TryAcquire(rCtx.RequestCtx, limiter)
+		setQuotaHeaders(rCtx, limiter)
+		if acquired {
+			return rCtx.Next()
+		}
+		return reject(rCtx, options, &Rejection{
+			Limit:      limiter.Capacity(),
+			RetryAfter: retryAfter,
+			Msg:        options.msg,
+		})
+	}
+}
+
+// NewTtlMiddleware limit requests with TtlRateLimiter, key extracted by keyFn.
+//
+// Result.Ttl and Result.Msg are used as Retry-After and message of the response.
+// TtlRateLimiter doesn't report its quota, so X-RateLimit-Remaining and X-RateLimit-Reset are only set on rejection.
+func NewTtlMiddleware(keyFn KeyFunc, limiter ratelimiter.TtlRateLimiter, opts ...Option) routing.Handler {
+	options := newOptions(opts)
+
+	return func(rCtx *routing.Context) error {
+		key := keyFn(rCtx)
+		if key == "" {
+			return rCtx.Next()
+		}
+
+		rCtx.Response.Header.Set(HeaderRateLimitLimit, strconv.Itoa(limiter.GetCapacity()))
+
+		result := limiter.ShouldBlockContext(rCtx.RequestCtx, key, options.msg)
+		if !result.Block {
+			return rCtx.Next()
+		}
+		msg := result.Msg
+		if msg == "" {
+			msg = options.msg
+		}
+		return reject(rCtx, options, &Rejection{
+			Limit:      limiter.GetCapacity(),
+			RetryAfter: time.Duration(result.Ttl) * time.Second,
+			Msg:        msg,
+		})
+	}
+}
+
+// set X-RateLimit-Remaining and X-RateLimit-Reset if limiter implements ratelimiter.QuotaReporter
+func setQuotaHeaders(rCtx *routing.Context, limiter ratelimiter.Interface) {
+	reporter, ok := limiter.(ratelimiter.QuotaReporter)
+	if !ok {
+		return
+	}
+	remaining, reset := reporter.Quota()
+	rCtx.Response.Header.Set(HeaderRateLimitRemaining, strconv.Itoa(remaining))
+	rCtx.Response.Header.Set(HeaderRateLimitReset, strconv.FormatInt(ceilSeconds(reset), 10))
+}
+
+func reject(rCtx *routing.Context, options *options, r *Rejection) error {
+	rCtx.Abort()
+	rCtx.Response.Header.Set(HeaderRateLimitRemaining, "0")
+	if r.RetryAfter > 0 {
+		seconds := strconv.FormatInt(ceilSeconds(r.RetryAfter), 10)
+		rCtx.Response.Header.Set(HeaderRetryAfter, seconds)
+		rCtx.Response.Header.Set(HeaderRateLimitReset, seconds)
+	}
+	return options.rejectHandler(rCtx, r)
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
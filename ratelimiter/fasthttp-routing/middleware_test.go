@@ -0,0 +1,201 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fasthttp_routing
+
+import (
+	"context"
+	"github.com/chanjarster/gears/ratelimiter"
+	routing "github.com/qiangxue/fasthttp-routing"
+	"github.com/valyala/fasthttp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRouter(middleware routing.Handler) *routing.Router {
+	r := routing.New()
+	r.Use(middleware)
+	r.Get("/user/<id>", func(rCtx *routing.Context) error {
+		rCtx.SetBodyString("ok")
+		return nil
+	})
+	return r
+}
+
+func doGet(r *routing.Router, path string, header map[string]string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, nil, nil)
+	ctx.Request.Header.SetMethod(fasthttp.MethodGet)
+	ctx.Request.SetRequestURI(path)
+	for k, v := range header {
+		ctx.Request.Header.Set(k, v)
+	}
+	r.HandleRequest(ctx)
+	return ctx
+}
+
+func TestNewMiddleware(t *testing.T) {
+	r := newRouter(NewMiddleware(ratelimiter.NewSyncFixedWindow(1, time.Minute)))
+
+	ctx := doGet(r, "/user/1", nil)
+	if got := ctx.Response.StatusCode(); got != fasthttp.StatusOK {
+		t.Errorf("status = %v, want %v", got, fasthttp.StatusOK)
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRateLimitLimit)); got != "1" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitLimit, got, "1")
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRateLimitRemaining)); got != "0" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitRemaining, got, "0")
+	}
+	if got, _ := strconv.Atoi(string(ctx.Response.Header.Peek(HeaderRateLimitReset))); got <= 0 || got > 60 {
+		t.Errorf("%s = %v, want (0, 60]", HeaderRateLimitReset, got)
+	}
+
+	ctx = doGet(r, "/user/2", nil)
+	if got := ctx.Response.StatusCode(); got != fasthttp.StatusTooManyRequests {
+		t.Errorf("status = %v, want %v", got, fasthttp.StatusTooManyRequests)
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRateLimitRemaining)); got != "0" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitRemaining, got, "0")
+	}
+	// fixed window is aligned to window size, so retry after is in (0, 60]
+	retryAfter := string(ctx.Response.Header.Peek(HeaderRetryAfter))
+	if got, _ := strconv.Atoi(retryAfter); got <= 0 || got > 60 {
+		t.Errorf("%s = %v, want (0, 60]", HeaderRetryAfter, got)
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRateLimitReset)); got != retryAfter {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitReset, got, retryAfter)
+	}
+	if got := string(ctx.Response.Body()); !strings.Contains(got, defaultMsg) {
+		t.Errorf("body = %v, want contains %v", got, defaultMsg)
+	}
+}
+
+func TestNewKeyedMiddleware(t *testing.T) {
+	limiters := ratelimiter.NewKeyedLimiter(ratelimiter.FixedWindowFactory(1, time.Minute))
+	r := newRouter(NewKeyedMiddleware(HeaderKey("X-Api-Key"), limiters, WithMsg("slow down")))
+
+	tests := []struct {
+		apiKey string
+		want   int
+	}{
+		{"a", fasthttp.StatusOK},
+		{"b", fasthttp.StatusOK},
+		{"a", fasthttp.StatusTooManyRequests},
+		{"", fasthttp.StatusOK},
+		{"", fasthttp.StatusOK},
+	}
+	for i, tt := range tests {
+		ctx := doGet(r, "/user/1", map[string]string{"X-Api-Key": tt.apiKey})
+		if got := ctx.Response.StatusCode(); got != tt.want {
+			t.Errorf("#%d status = %v, want %v", i, got, tt.want)
+		}
+		if body := string(ctx.Response.Body()); tt.want == fasthttp.StatusTooManyRequests && !strings.Contains(body, "slow down") {
+			t.Errorf("#%d body = %v, want contains %v", i, body, "slow down")
+		}
+	}
+}
+
+func TestNewTtlMiddleware(t *testing.T) {
+	limiter := &mockTtlRateLimiter{
+		TtlRateLimiterParams: ratelimiter.NewFixedTtlRateLimiterParams(10, 1, 30),
+		blocked:              map[string]bool{"1.2.3.4": true},
+	}
+	rejected := 0
+	r := newRouter(NewTtlMiddleware(HeaderKey("X-Real-Ip"), limiter, WithRejectHandler(func(rCtx *routing.Context, r *Rejection) error {
+		rejected++
+		rCtx.SetStatusCode(fasthttp.StatusTooManyRequests)
+		rCtx.SetBodyString(r.Msg)
+		return nil
+	})))
+
+	ctx := doGet(r, "/user/1", map[string]string{"X-Real-Ip": "5.6.7.8"})
+	if got := ctx.Response.StatusCode(); got != fasthttp.StatusOK {
+		t.Errorf("status = %v, want %v", got, fasthttp.StatusOK)
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRateLimitLimit)); got != "10" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitLimit, got, "10")
+	}
+
+	ctx = doGet(r, "/user/1", map[string]string{"X-Real-Ip": "1.2.3.4"})
+	if got := ctx.Response.StatusCode(); got != fasthttp.StatusTooManyRequests {
+		t.Errorf("status = %v, want %v", got, fasthttp.StatusTooManyRequests)
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRetryAfter)); got != "30" {
+		t.Errorf("%s = %v, want %v", HeaderRetryAfter, got, "30")
+	}
+	if got := string(ctx.Response.Body()); got != "blocked: 1.2.3.4" {
+		t.Errorf("body = %v, want %v", got, "blocked: 1.2.3.4")
+	}
+	if rejected != 1 {
+		t.Errorf("rejected = %v, want %v", rejected, 1)
+	}
+	if limiter.ctx != context.Context(ctx) {
+		t.Errorf("ShouldBlockContext() ctx = %v, want the request ctx", limiter.ctx)
+	}
+}
+
+func TestQuotaHeaders(t *testing.T) {
+	r := newRouter(NewMiddleware(ratelimiter.NewAtomicTokenBucketRate(10, 1)))
+
+	ctx := doGet(r, "/user/1", nil)
+	if got := ctx.Response.StatusCode(); got != fasthttp.StatusOK {
+		t.Errorf("status = %v, want %v", got, fasthttp.StatusOK)
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRateLimitRemaining)); got != "9" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitRemaining, got, "9")
+	}
+	if got := string(ctx.Response.Header.Peek(HeaderRateLimitReset)); got != "1" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitReset, got, "1")
+	}
+}
+
+type mockTtlRateLimiter struct {
+	ratelimiter.TtlRateLimiterParams
+	blocked map[string]bool
+	ctx     context.Context // ctx passed to ShouldBlockContext
+}
+
+func (m *mockTtlRateLimiter) ShouldBlock(key string, msg string) *ratelimiter.Result {
+	return m.ShouldBlock2(key, key, msg)
+}
+
+func (m *mockTtlRateLimiter) IsBlocked(blockKey string) *ratelimiter.Result {
+	if m.blocked[blockKey] {
+		return &ratelimiter.Result{Block: true, Ttl: m.GetTimeoutSeconds(), Msg: "blocked: " + blockKey}
+	}
+	return &ratelimiter.Result{}
+}
+
+func (m *mockTtlRateLimiter) ShouldBlock2(key string, blockKey string, msg string) *ratelimiter.Result {
+	return m.IsBlocked(blockKey)
+}
+
+func (m *mockTtlRateLimiter) ShouldBlockContext(ctx context.Context, key string, msg string) *ratelimiter.Result {
+	m.ctx = ctx
+	return m.ShouldBlock(key, msg)
+}
+
+func (m *mockTtlRateLimiter) IsBlockedContext(ctx context.Context, blockKey string) *ratelimiter.Result {
+	return m.IsBlocked(blockKey)
+}
+
+func (m *mockTtlRateLimiter) ShouldBlock2Context(ctx context.Context, key string, blockKey string, msg string) *ratelimiter.Result {
+	return m.ShouldBlock2(key, blockKey, msg)
+}
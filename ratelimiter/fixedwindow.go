@@ -18,6 +18,7 @@
 package ratelimiter
 
 import (
+	"context"
 	gtime "github.com/chanjarster/gears/util/time"
 	"math"
 	"sync"
@@ -40,6 +41,7 @@ import (
 // 不过固定时间窗口也具有它的优势：节省内存，它只需要计数就行了，而不需要记录每次请求的时间戳。
 type FixedWindow interface {
 	Interface
+	Waitable
 	WindowSize() time.Duration
 }
 
@@ -61,9 +63,34 @@ type SyncFixedWindow struct {
 	until      int64 // 该时间窗口能够覆盖到的未来的某个时间
 	count      int   // 时间窗口里的请求数量
 	nowFn      gtime.NowFunc
+	observable
 }
 
 func (s *SyncFixedWindow) Acquire() bool {
+	return s.AcquireN(1)
+}
+
+func (s *SyncFixedWindow) AcquireN(n int) bool {
+	acquired, _, _ := s.tryAcquireN(n)
+	return s.observe(n, acquired)
+}
+
+func (s *SyncFixedWindow) Wait(ctx context.Context) error {
+	return s.WaitN(ctx, 1)
+}
+
+func (s *SyncFixedWindow) WaitN(ctx context.Context, n int) error {
+	return s.observeWait(n, waitN(ctx, n, s.tryAcquireN))
+}
+
+func (s *SyncFixedWindow) tryAcquireN(n int) (bool, time.Duration, error) {
+	if n <= 0 {
+		return true, 0, nil
+	}
+	if n > s.capacity {
+		return false, 0, ErrExceedCapacity
+	}
+
 	now := s.nowFn().UnixNano()
 
 	s.lock.Lock()
@@ -73,11 +100,12 @@ func (s *SyncFixedWindow) Acquire() bool {
 		s.reset(now)
 	}
 
-	if s.count < s.capacity {
-		s.count++
-		return true
+	if s.count+n <= s.capacity {
+		s.count += n
+		return true, 0, nil
 	}
-	return false
+	// 等到下一个interval开始
+	return false, time.Duration(s.until - now + 1), nil
 
 }
 
@@ -85,6 +113,36 @@ func (s *SyncFixedWindow) Capacity() int {
 	return s.capacity
 }
 
+// Usage ratio of requests in current interval
+func (s *SyncFixedWindow) Usage() float64 {
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.contains(now) {
+		return 0
+	}
+	return usageRatio(float64(s.count), float64(s.capacity))
+}
+
+// Quota remaining requests in current interval, and how long until next interval
+func (s *SyncFixedWindow) Quota() (int, time.Duration) {
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.contains(now) || s.count == 0 {
+		return s.capacity, 0
+	}
+	remaining := s.capacity - s.count
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, time.Duration(s.until - now + 1)
+}
+
 func (s *SyncFixedWindow) WindowSize() time.Duration {
 	return time.Duration(s.windowSize)
 }
@@ -0,0 +1,150 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"github.com/chanjarster/gears/simplelog"
+	"github.com/go-redis/redis/v7"
+	"time"
+)
+
+const (
+	fixedWindowPrefix = "_rl:fw:"
+
+	// the counter key expires after window size, so a window starts at the first request after previous window ends.
+	//
+	// return acquired, delay(microseconds)
+	fixedWindowScript = `local key = KEYS[1]
+local cap = tonumber(ARGV[1])
+local win = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local count = tonumber(redis.call('GET', key) or '0')
+if count + n > cap
+then
+  local ttl = redis.call('PTTL', key)
+  if ttl < 0
+  then
+    redis.call('PEXPIRE', key, win)
+    ttl = win
+  end
+  return {0, ttl * 1000 + 1}
+end
+
+count = redis.call('INCRBY', key, n)
+if count == n
+then
+  redis.call('PEXPIRE', key, win)
+end
+return {1, 0}
+`
+)
+
+var (
+	fixedWindowScriptSha = ""
+)
+
+// NewRedisFixedWindow New a FixedWindow backed by redis, all instances of same key share the same window.
+//
+// Different from SyncFixedWindow, a window starts at the first request after previous window ends.
+//
+// Call LoadScript before using it.
+//
+//	key: window's name
+//	capacity: window capacity
+//	windowSize: time interval for each window, precision is millisecond
+func NewRedisFixedWindow(redisClient *redis.Client, key string, capacity int, windowSize time.Duration) FixedWindow {
+	return &redisFixedWindow{
+		redisClient: redisClient,
+		key:         fixedWindowPrefix + key,
+		capacity:    capacity,
+		windowSize:  windowSize,
+	}
+}
+
+// NewRedisFixedWindowCluster New a redis FixedWindow for Redis Cluster environment.
+//
+//	hashTag: redis hash tag value, helps to ensure all keys be in the same slot.
+//
+// see: https://redis.io/topics/cluster-tutorial#redis-cluster-data-sharding
+func NewRedisFixedWindowCluster(redisClient *redis.Client, key string, capacity int, windowSize time.Duration, hashTag string) FixedWindow {
+	return &redisFixedWindow{
+		redisClient: redisClient,
+		key:         fixedWindowPrefix + key + formatHashTag(hashTag),
+		capacity:    capacity,
+		windowSize:  windowSize,
+	}
+}
+
+type redisFixedWindow struct {
+	redisClient *redis.Client
+	key         string
+	capacity    int
+	windowSize  time.Duration
+	observable
+}
+
+func (r *redisFixedWindow) Capacity() int {
+	return r.capacity
+}
+
+func (r *redisFixedWindow) WindowSize() time.Duration {
+	return r.windowSize
+}
+
+func (r *redisFixedWindow) Acquire() bool {
+	return r.AcquireN(1)
+}
+
+func (r *redisFixedWindow) AcquireN(n int) bool {
+	acquired, _, err := r.tryAcquireN(context.Background(), n)
+	if err != nil && err != ErrExceedCapacity {
+		simplelog.ErrLogger.Println("eval fixedWindowScript ", fixedWindowScriptSha, "error", err)
+		return r.observe(n, true)
+	}
+	return r.observe(n, acquired)
+}
+
+func (r *redisFixedWindow) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+func (r *redisFixedWindow) WaitN(ctx context.Context, n int) error {
+	err := waitN(ctx, n, func(n int) (bool, time.Duration, error) {
+		return r.tryAcquireN(ctx, n)
+	})
+	return r.observeWait(n, err)
+}
+
+func (r *redisFixedWindow) tryAcquireN(ctx context.Context, n int) (bool, time.Duration, error) {
+	if n <= 0 {
+		return true, 0, nil
+	}
+	if n > r.capacity {
+		return false, 0, ErrExceedCapacity
+	}
+
+	//local cap = tonumber(ARGV[1])
+	//local win = tonumber(ARGV[2])
+	//local n = tonumber(ARGV[3])
+	return evalAcquireScript(ctx, r.redisClient, fixedWindowScriptSha, r.key,
+		r.capacity,
+		r.windowSize.Milliseconds(),
+		n,
+	)
+}
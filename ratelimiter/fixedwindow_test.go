@@ -18,6 +18,7 @@
 package ratelimiter
 
 import (
+	"context"
 	gtime "github.com/chanjarster/gears/util/time"
 	"reflect"
 	"testing"
@@ -220,3 +221,63 @@ func TestSyncFixedWindow_reset(t *testing.T) {
 		})
 	}
 }
+
+func TestSyncFixedWindow_AcquireN(t *testing.T) {
+	now := time.Now()
+	ratelimiter := NewSyncFixedWindow(10, 50*time.Millisecond)
+	ratelimiter.nowFn = gtime.FixedNow(now)
+
+	if got := ratelimiter.AcquireN(11); got {
+		t.Errorf("AcquireN(11) = %v, want %v", got, false)
+	}
+	if got := ratelimiter.AcquireN(6); !got {
+		t.Errorf("AcquireN(6) = %v, want %v", got, true)
+	}
+	if got := ratelimiter.AcquireN(5); got {
+		t.Errorf("AcquireN(5) = %v, want %v", got, false)
+	}
+	if got := ratelimiter.AcquireN(4); !got {
+		t.Errorf("AcquireN(4) = %v, want %v", got, true)
+	}
+
+	ratelimiter.nowFn = gtime.FixedNow(now.Add(60 * time.Millisecond))
+	if got := ratelimiter.AcquireN(10); !got {
+		t.Errorf("AcquireN(10) = %v, want %v", got, true)
+	}
+}
+
+func TestSyncFixedWindow_WaitN(t *testing.T) {
+	new := func() *SyncFixedWindow {
+		return NewSyncFixedWindow(10, 50*time.Millisecond)
+	}
+
+	t.Run("wait more than capacity", func(t *testing.T) {
+		ratelimiter := new()
+		if got := ratelimiter.WaitN(context.Background(), 11); got != ErrExceedCapacity {
+			t.Errorf("WaitN(11) = %v, want %v", got, ErrExceedCapacity)
+		}
+	})
+
+	t.Run("wait until next interval", func(t *testing.T) {
+		ratelimiter := new()
+		ratelimiter.AcquireN(10)
+		until := time.Unix(0, ratelimiter.until)
+		if got := ratelimiter.Wait(context.Background()); got != nil {
+			t.Errorf("Wait() = %v, want %v", got, nil)
+		}
+		if now := time.Now(); now.Before(until) {
+			t.Errorf("Wait() returned at %v, want after %v", now, until)
+		}
+	})
+
+	t.Run("wait canceled", func(t *testing.T) {
+		ratelimiter := new()
+		ratelimiter.nowFn = gtime.FixedNow(time.Now())
+		ratelimiter.AcquireN(10)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if got := ratelimiter.Wait(ctx); got != context.DeadlineExceeded {
+			t.Errorf("Wait() = %v, want %v", got, context.DeadlineExceeded)
+		}
+	})
+}
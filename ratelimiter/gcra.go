@@ -0,0 +1,142 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	gtime "github.com/chanjarster/gears/util/time"
+	"sync/atomic"
+	"time"
+)
+
+// Gcra Generic Cell Rate Algorithm rate limiter
+//
+// Requests are expected to arrive every emission interval(1s / rate), GCRA only records the
+// "theoretical arrival time"(TAT) of next request. A request is allowed if it doesn't arrive earlier than
+// TAT - capacity * interval, i.e. at most capacity requests can burst.
+//
+// It gives smooth, burst-controlled limiting with O(1) memory.
+//
+// see: https://en.wikipedia.org/wiki/Generic_cell_rate_algorithm
+type Gcra interface {
+	Interface
+	Waitable
+	Configurable
+}
+
+// NewAtomicGcra New a AtomicGcra
+//
+//	capacity: max burst requests
+//	ratePerSecond: sustained rate(per second), <= 0 means rejecting all requests
+func NewAtomicGcra(capacity int, ratePerSecond float64) *AtomicGcra {
+	return &AtomicGcra{
+		capacity:         int64(capacity),
+		emissionInterval: int64(rateToInterval(ratePerSecond)),
+		nowFn:            gtime.SysNow,
+	}
+}
+
+// Gcra implementation using "sync/atomic" package, lock-free.
+type AtomicGcra struct {
+	capacity         int64 // max burst requests
+	emissionInterval int64 // nanoseconds between requests, <= 0 means rejecting all requests
+	tat              int64 // theoretical arrival time
+	nowFn            gtime.NowFunc
+	observable
+}
+
+func (g *AtomicGcra) Capacity() int {
+	return int(atomic.LoadInt64(&g.capacity))
+}
+
+func (g *AtomicGcra) SetCapacity(newCap int) {
+	atomic.StoreInt64(&g.capacity, int64(newCap))
+}
+
+// Usage ratio of burst in use
+func (g *AtomicGcra) Usage() float64 {
+	if g.emissionInterval <= 0 {
+		return 0
+	}
+	now := g.nowFn().UnixNano()
+	used := float64(atomic.LoadInt64(&g.tat)-now) / float64(g.emissionInterval)
+	return usageRatio(used, float64(atomic.LoadInt64(&g.capacity)))
+}
+
+// Quota remaining burst, and how long until all burst is available again
+func (g *AtomicGcra) Quota() (int, time.Duration) {
+	if g.emissionInterval <= 0 {
+		return 0, 0
+	}
+	now := g.nowFn().UnixNano()
+	tat := atomic.LoadInt64(&g.tat)
+	if tat < now {
+		tat = now
+	}
+	remaining := int(atomic.LoadInt64(&g.capacity) - (tat-now+g.emissionInterval-1)/g.emissionInterval)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, time.Duration(tat - now)
+}
+
+func (g *AtomicGcra) Acquire() bool {
+	return g.AcquireN(1)
+}
+
+func (g *AtomicGcra) AcquireN(n int) bool {
+	acquired, _, _ := g.tryAcquireN(n)
+	return g.observe(n, acquired)
+}
+
+func (g *AtomicGcra) Wait(ctx context.Context) error {
+	return g.WaitN(ctx, 1)
+}
+
+func (g *AtomicGcra) WaitN(ctx context.Context, n int) error {
+	return g.observeWait(n, waitN(ctx, n, g.tryAcquireN))
+}
+
+func (g *AtomicGcra) tryAcquireN(n int) (bool, time.Duration, error) {
+	if n <= 0 {
+		return true, 0, nil
+	}
+	capacity := atomic.LoadInt64(&g.capacity)
+	if int64(n) > capacity {
+		return false, 0, ErrExceedCapacity
+	}
+	if g.emissionInterval <= 0 {
+		return false, delayForever, nil
+	}
+
+	now := g.nowFn().UnixNano()
+	for {
+		tat := atomic.LoadInt64(&g.tat)
+		newTat := tat
+		if newTat < now {
+			newTat = now
+		}
+		newTat += int64(n) * g.emissionInterval
+
+		if allowAt := newTat - capacity*g.emissionInterval; allowAt > now {
+			return false, time.Duration(allowAt - now), nil
+		}
+		if atomic.CompareAndSwapInt64(&g.tat, tat, newTat) {
+			return true, 0, nil
+		}
+	}
+}
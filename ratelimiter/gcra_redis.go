@@ -0,0 +1,157 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"github.com/chanjarster/gears/simplelog"
+	gtime "github.com/chanjarster/gears/util/time"
+	"github.com/go-redis/redis/v7"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	gcraPrefix = "_rl:gcra:"
+
+	// same algorithm as AtomicGcra, TAT(microseconds) is stored in a string key
+	//
+	// return acquired, delay(microseconds)
+	gcraScript = `local key = KEYS[1]
+local cap = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local tat = tonumber(redis.call('GET', key) or '0')
+if tat < now
+then
+  tat = now
+end
+
+local newTat = tat + n * interval
+local allowAt = newTat - cap * interval
+if allowAt > now
+then
+  return {0, math.ceil(allowAt - now)}
+end
+
+redis.call('SET', key, string.format('%.0f', newTat), 'PX', math.ceil((newTat - now) / 1000) + 1)
+return {1, 0}
+`
+)
+
+var (
+	gcraScriptSha = ""
+)
+
+// NewRedisGcra New a Gcra backed by redis, all instances of same key share the same TAT.
+//
+// Call LoadScript before using it.
+//
+//	key: rate limiter's name
+//	capacity: max burst requests
+//	ratePerSecond: sustained rate(per second), <= 0 means rejecting all requests
+func NewRedisGcra(redisClient *redis.Client, key string, capacity int, ratePerSecond float64) Gcra {
+	return &redisGcra{
+		redisClient:      redisClient,
+		key:              gcraPrefix + key,
+		capacity:         int64(capacity),
+		emissionInterval: int64(rateToInterval(ratePerSecond)),
+		nowFn:            gtime.SysNow,
+	}
+}
+
+// NewRedisGcraCluster New a redis Gcra for Redis Cluster environment.
+//
+//	hashTag: redis hash tag value, helps to ensure all keys be in the same slot.
+//
+// see: https://redis.io/topics/cluster-tutorial#redis-cluster-data-sharding
+func NewRedisGcraCluster(redisClient *redis.Client, key string, capacity int, ratePerSecond float64, hashTag string) Gcra {
+	return &redisGcra{
+		redisClient:      redisClient,
+		key:              gcraPrefix + key + formatHashTag(hashTag),
+		capacity:         int64(capacity),
+		emissionInterval: int64(rateToInterval(ratePerSecond)),
+		nowFn:            gtime.SysNow,
+	}
+}
+
+type redisGcra struct {
+	redisClient      *redis.Client
+	key              string
+	capacity         int64
+	emissionInterval int64 // nanoseconds between requests, <= 0 means rejecting all requests
+	nowFn            gtime.NowFunc
+	observable
+}
+
+func (r *redisGcra) Capacity() int {
+	return int(atomic.LoadInt64(&r.capacity))
+}
+
+func (r *redisGcra) SetCapacity(newCap int) {
+	atomic.StoreInt64(&r.capacity, int64(newCap))
+}
+
+func (r *redisGcra) Acquire() bool {
+	return r.AcquireN(1)
+}
+
+func (r *redisGcra) AcquireN(n int) bool {
+	acquired, _, err := r.tryAcquireN(context.Background(), n)
+	if err != nil && err != ErrExceedCapacity {
+		simplelog.ErrLogger.Println("eval gcraScript ", gcraScriptSha, "error", err)
+		return r.observe(n, true)
+	}
+	return r.observe(n, acquired)
+}
+
+func (r *redisGcra) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+func (r *redisGcra) WaitN(ctx context.Context, n int) error {
+	err := waitN(ctx, n, func(n int) (bool, time.Duration, error) {
+		return r.tryAcquireN(ctx, n)
+	})
+	return r.observeWait(n, err)
+}
+
+func (r *redisGcra) tryAcquireN(ctx context.Context, n int) (bool, time.Duration, error) {
+	if n <= 0 {
+		return true, 0, nil
+	}
+	capacity := atomic.LoadInt64(&r.capacity)
+	if int64(n) > capacity {
+		return false, 0, ErrExceedCapacity
+	}
+	if r.emissionInterval <= 0 {
+		return false, delayForever, nil
+	}
+
+	//local cap = tonumber(ARGV[1])
+	//local interval = tonumber(ARGV[2])
+	//local now = tonumber(ARGV[3])
+	//local n = tonumber(ARGV[4])
+	return evalAcquireScript(ctx, r.redisClient, gcraScriptSha, r.key,
+		capacity,
+		float64(r.emissionInterval)/float64(time.Microsecond),
+		r.nowFn().UnixNano()/int64(time.Microsecond),
+		n,
+	)
+}
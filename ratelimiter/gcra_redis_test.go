@@ -0,0 +1,94 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"github.com/chanjarster/gears/confs"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_redisGcra(t *testing.T) {
+
+	val, hit := os.LookupEnv("INTEGRATION_TEST")
+	if !hit || val != "true" {
+		t.Skip("skip integration test")
+	}
+
+	redisClient := confs.NewRedisClient(&confs.RedisConf{
+		Host:     "localhost",
+		Port:     6379,
+		Password: "",
+		Pool:     10,
+		MinIdle:  1,
+	}, nil)
+	redisClient.FlushAll()
+	defer redisClient.Close()
+
+	LoadScript(redisClient)
+
+	t.Run("acquire", func(t *testing.T) {
+		g := NewRedisGcra(redisClient, "acquire", 10, 10)
+		g2 := NewRedisGcra(redisClient, "acquire", 10, 10)
+		if got := g.AcquireN(6); !got {
+			t.Errorf("AcquireN(6) = %v, want %v", got, true)
+		}
+		if got := g2.AcquireN(5); got {
+			t.Errorf("AcquireN(5) = %v, want %v", got, false)
+		}
+		if got := g2.AcquireN(4); !got {
+			t.Errorf("AcquireN(4) = %v, want %v", got, true)
+		}
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		time.Sleep(110 * time.Millisecond)
+		if got := g.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("set capacity", func(t *testing.T) {
+		g := NewRedisGcra(redisClient, "capacity", 10, 10)
+		g.AcquireN(10)
+		g.SetCapacity(11)
+		if got := g.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		g := NewRedisGcraCluster(redisClient, "wait", 10, 10, "foo")
+		g.AcquireN(10)
+		start := time.Now()
+		if got := g.WaitN(context.Background(), 2); got != nil {
+			t.Errorf("WaitN(2) = %v, want %v", got, nil)
+		}
+		if elapse := time.Since(start); elapse < 190*time.Millisecond {
+			t.Errorf("WaitN(2) elapse = %v, want about 200ms", elapse)
+		}
+		if got := redisClient.Exists(gcraPrefix + "wait{foo}").Val(); got != 1 {
+			t.Errorf("Exists() = %v, want %v", got, 1)
+		}
+	})
+
+}
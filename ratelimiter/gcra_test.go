@@ -0,0 +1,163 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewAtomicGcra(t *testing.T) {
+	g := NewAtomicGcra(10, 100)
+
+	if got, want := g.Capacity(), 10; got != want {
+		t.Errorf("NewAtomicGcra().Capacity() = %v, want %v", got, want)
+	}
+	if got, want := g.emissionInterval, int64(10*time.Millisecond); got != want {
+		t.Errorf("NewAtomicGcra().emissionInterval = %v, want %v", got, want)
+	}
+	if got, want := g.tat, int64(0); got != want {
+		t.Errorf("NewAtomicGcra().tat = %v, want %v", got, want)
+	}
+	if got := g.nowFn; got == nil {
+		t.Errorf("NewAtomicGcra().nowFn is nil, want not nil")
+	}
+}
+
+func TestAtomicGcra_Acquire(t *testing.T) {
+	new := func() (*AtomicGcra, *time.Time) {
+		clock := time.Now()
+		g := NewAtomicGcra(10, 100)
+		g.nowFn = func() time.Time {
+			return clock
+		}
+		return g, &clock
+	}
+
+	t.Run("burst", func(t *testing.T) {
+		g, _ := new()
+		for i := 0; i < g.Capacity(); i++ {
+			if got := g.Acquire(); !got {
+				t.Errorf("Acquire() = %v, want %v", got, true)
+			}
+		}
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("emission interval", func(t *testing.T) {
+		g, clock := new()
+		g.AcquireN(10)
+		*clock = clock.Add(9 * time.Millisecond)
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		*clock = clock.Add(time.Millisecond)
+		if got := g.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("idle doesn't accumulate more than capacity", func(t *testing.T) {
+		g, clock := new()
+		*clock = clock.Add(time.Hour)
+		if got := g.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("tryAcquireN delay", func(t *testing.T) {
+		g, _ := new()
+		g.AcquireN(5)
+		if _, got, _ := g.tryAcquireN(8); got != 30*time.Millisecond {
+			t.Errorf("tryAcquireN(8) delay = %v, want %v", got, 30*time.Millisecond)
+		}
+		if _, _, got := g.tryAcquireN(11); got != ErrExceedCapacity {
+			t.Errorf("tryAcquireN(11) error = %v, want %v", got, ErrExceedCapacity)
+		}
+	})
+
+	t.Run("set capacity", func(t *testing.T) {
+		g, _ := new()
+		g.AcquireN(10)
+		g.SetCapacity(15)
+		if got := g.AcquireN(5); !got {
+			t.Errorf("AcquireN(5) = %v, want %v", got, true)
+		}
+		g.SetCapacity(5)
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("zero rate", func(t *testing.T) {
+		g := NewAtomicGcra(10, 0)
+		if got := g.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+}
+
+func TestAtomicGcra_WaitN(t *testing.T) {
+	g := NewAtomicGcra(10, 100)
+	g.AcquireN(10)
+	start := time.Now()
+	if got := g.WaitN(context.Background(), 5); got != nil {
+		t.Errorf("WaitN(5) = %v, want %v", got, nil)
+	}
+	if elapse := time.Since(start); elapse < 45*time.Millisecond {
+		t.Errorf("WaitN(5) elapse = %v, want about 50ms", elapse)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if got := g.WaitN(ctx, 10); got != context.DeadlineExceeded {
+		t.Errorf("WaitN(10) = %v, want %v", got, context.DeadlineExceeded)
+	}
+}
+
+func TestAtomicGcra_concurrent(t *testing.T) {
+	g := NewAtomicGcra(100, 0.001)
+	var acquired int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if g.Acquire() {
+					atomic.AddInt64(&acquired, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := acquired, int64(100); got != want {
+		t.Errorf("acquired = %v, want %v", got, want)
+	}
+}
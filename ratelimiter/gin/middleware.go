@@ -0,0 +1,222 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Rate limiter middleware for gin.
+package gin_middleware
+
+import (
+	"context"
+	"github.com/chanjarster/gears/ratelimiter"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderRetryAfter         = "Retry-After"
+	HeaderRateLimitLimit     = "X-RateLimit-Limit"
+	HeaderRateLimitRemaining = "X-RateLimit-Remaining"
+	HeaderRateLimitReset     = "X-RateLimit-Reset"
+
+	defaultMsg = "too many requests"
+)
+
+// KeyFunc extract rate limit key from request, empty key means the request is not limited
+type KeyFunc func(c *gin.Context) string
+
+// ClientIPKey use client ip as key
+func ClientIPKey() KeyFunc {
+	return func(c *gin.Context) string {
+		return c.ClientIP()
+	}
+}
+
+// HeaderKey use the value of request header `name` as key
+func HeaderKey(name string) KeyFunc {
+	return func(c *gin.Context) string {
+		return c.GetHeader(name)
+	}
+}
+
+// RouteKey use "METHOD:route pattern" as key, e.g. "GET:/user/:id"
+func RouteKey() KeyFunc {
+	return func(c *gin.Context) string {
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+		return c.Request.Method + ":" + path
+	}
+}
+
+// Rejection describes why a request is rejected
+type Rejection struct {
+	Limit      int           // capacity of the rate limiter
+	RetryAfter time.Duration // how long the client should wait before retry, 0 if unknown
+	Msg        string        // message for client
+}
+
+// RejectHandler write the response for rejected request, headers are already set before it's called
+type RejectHandler func(c *gin.Context, r *Rejection)
+
+// DefaultRejectHandler respond 429 with json body: {"msg": "...", "ttl": seconds}
+func DefaultRejectHandler(c *gin.Context, r *Rejection) {
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"msg": r.Msg,
+		"ttl": ceilSeconds(r.RetryAfter),
+	})
+}
+
+type options struct {
+	msg           string
+	rejectHandler RejectHandler
+}
+
+type Option func(opts *options)
+
+// WithMsg message for rejected request, for TtlRateLimiter it's the message recorded when first time blocking
+func WithMsg(msg string) Option {
+	return func(opts *options) {
+		opts.msg = msg
+	}
+}
+
+// WithRejectHandler customize the response for rejected request
+func WithRejectHandler(handler RejectHandler) Option {
+	return func(opts *options) {
+		opts.rejectHandler = handler
+	}
+}
+
+func newOptions(opts []Option) *options {
+	options := &options{
+		msg:           defaultMsg,
+		rejectHandler: DefaultRejectHandler,
+	}
+	for _, o := range opts {
+		o(options)
+	}
+	return options
+}
+
+// NewMiddleware limit all requests with one limiter
+func NewMiddleware(limiter ratelimiter.Interface, opts ...Option) gin.HandlerFunc {
+	return newMiddleware(nil, func(string) ratelimiter.Interface {
+		return limiter
+	}, opts)
+}
+
+// NewKeyedMiddleware limit requests with limiter of the key extracted by keyFn
+func NewKeyedMiddleware(keyFn KeyFunc, limiters *ratelimiter.KeyedLimiter, opts ...Option) gin.HandlerFunc {
+	return newMiddleware(keyFn, limiters.Get, opts)
+}
+
+func newMiddleware(keyFn KeyFunc, getLimiter func(key string) ratelimiter.Interface, opts []Option) gin.HandlerFunc {
+	options := newOptions(opts)
+
+	return func(c *gin.Context) {
+		key := ""
+		if keyFn != nil {
+			if key = keyFn(c); key == "" {
+				c.Next()
+				return
+			}
+		}
+
+		limiter := getLimiter(key)
+		c.Header(HeaderRateLimitLimit, strconv.Itoa(limiter.Capacity()))
+
+		acquired, retryAfter := ratelimiter.TryAcquire(requestContext(c), limiter)
+		setQuotaHeaders(c, limiter)
+		if acquired {
+			c.Next()
+			return
+		}
+		reject(c, options, &Rejection{
+			Limit:      limiter.Capacity(),
+			RetryAfter: retryAfter,
+			Msg:        options.msg,
+		})
+	}
+}
+
+// NewTtlMiddleware limit requests with TtlRateLimiter, key extracted by keyFn.
+//
+// Result.Ttl and Result.Msg are used as Retry-After and message of the response.
+// TtlRateLimiter doesn't report its quota, so X-RateLimit-Remaining and X-RateLimit-Reset are only set on rejection.
+func NewTtlMiddleware(keyFn KeyFunc, limiter ratelimiter.TtlRateLimiter, opts ...Option) gin.HandlerFunc {
+	options := newOptions(opts)
+
+	return func(c *gin.Context) {
+		key := keyFn(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		c.Header(HeaderRateLimitLimit, strconv.Itoa(limiter.GetCapacity()))
+
+		result := limiter.ShouldBlockContext(requestContext(c), key, options.msg)
+		if !result.Block {
+			c.Next()
+			return
+		}
+		msg := result.Msg
+		if msg == "" {
+			msg = options.msg
+		}
+		reject(c, options, &Rejection{
+			Limit:      limiter.GetCapacity(),
+			RetryAfter: time.Duration(result.Ttl) * time.Second,
+			Msg:        msg,
+		})
+	}
+}
+
+// set X-RateLimit-Remaining and X-RateLimit-Reset if limiter implements ratelimiter.QuotaReporter
+func setQuotaHeaders(c *gin.Context, limiter ratelimiter.Interface) {
+	reporter, ok := limiter.(ratelimiter.QuotaReporter)
+	if !ok {
+		return
+	}
+	remaining, reset := reporter.Quota()
+	c.Header(HeaderRateLimitRemaining, strconv.Itoa(remaining))
+	c.Header(HeaderRateLimitReset, strconv.FormatInt(ceilSeconds(reset), 10))
+}
+
+func reject(c *gin.Context, options *options, r *Rejection) {
+	c.Header(HeaderRateLimitRemaining, "0")
+	if r.RetryAfter > 0 {
+		seconds := strconv.FormatInt(ceilSeconds(r.RetryAfter), 10)
+		c.Header(HeaderRetryAfter, seconds)
+		c.Header(HeaderRateLimitReset, seconds)
+	}
+	options.rejectHandler(c, r)
+	c.Abort()
+}
+
+func requestContext(c *gin.Context) context.Context {
+	if c.Request != nil {
+		return c.Request.Context()
+	}
+	return context.Background()
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,207 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gin_middleware
+
+import (
+	"context"
+	"github.com/chanjarster/gears/ratelimiter"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newEngine(middleware gin.HandlerFunc) *gin.Engine {
+	r := gin.New()
+	r.Use(middleware)
+	r.GET("/user/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return r
+}
+
+func doGet(r http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestNewMiddleware(t *testing.T) {
+	r := newEngine(NewMiddleware(ratelimiter.NewSyncFixedWindow(1, time.Minute)))
+
+	w := doGet(r, "/user/1", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get(HeaderRateLimitLimit); got != "1" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitLimit, got, "1")
+	}
+	if got := w.Header().Get(HeaderRateLimitRemaining); got != "0" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitRemaining, got, "0")
+	}
+	if got, _ := strconv.Atoi(w.Header().Get(HeaderRateLimitReset)); got <= 0 || got > 60 {
+		t.Errorf("%s = %v, want (0, 60]", HeaderRateLimitReset, got)
+	}
+
+	w = doGet(r, "/user/2", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get(HeaderRateLimitRemaining); got != "0" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitRemaining, got, "0")
+	}
+	// fixed window is aligned to window size, so retry after is in (0, 60]
+	if got, _ := strconv.Atoi(w.Header().Get(HeaderRetryAfter)); got <= 0 || got > 60 {
+		t.Errorf("%s = %v, want (0, 60]", HeaderRetryAfter, got)
+	}
+	if got, want := w.Header().Get(HeaderRateLimitReset), w.Header().Get(HeaderRetryAfter); got != want {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitReset, got, want)
+	}
+	if got := w.Body.String(); !strings.Contains(got, defaultMsg) {
+		t.Errorf("body = %v, want contains %v", got, defaultMsg)
+	}
+}
+
+func TestNewKeyedMiddleware(t *testing.T) {
+	limiters := ratelimiter.NewKeyedLimiter(ratelimiter.FixedWindowFactory(1, time.Minute))
+	r := newEngine(NewKeyedMiddleware(HeaderKey("X-Api-Key"), limiters, WithMsg("slow down")))
+
+	tests := []struct {
+		apiKey string
+		want   int
+	}{
+		{"a", http.StatusOK},
+		{"b", http.StatusOK},
+		{"a", http.StatusTooManyRequests},
+		{"", http.StatusOK},
+		{"", http.StatusOK},
+	}
+	for i, tt := range tests {
+		w := doGet(r, "/user/1", map[string]string{"X-Api-Key": tt.apiKey})
+		if w.Code != tt.want {
+			t.Errorf("#%d status = %v, want %v", i, w.Code, tt.want)
+		}
+		if tt.want == http.StatusTooManyRequests && !strings.Contains(w.Body.String(), "slow down") {
+			t.Errorf("#%d body = %v, want contains %v", i, w.Body.String(), "slow down")
+		}
+	}
+}
+
+func TestQuotaHeaders(t *testing.T) {
+	r := newEngine(NewMiddleware(ratelimiter.NewAtomicTokenBucketRate(10, 1)))
+
+	w := doGet(r, "/user/1", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get(HeaderRateLimitRemaining); got != "9" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitRemaining, got, "9")
+	}
+	if got := w.Header().Get(HeaderRateLimitReset); got != "1" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitReset, got, "1")
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	var got string
+	r := gin.New()
+	r.GET("/user/:id", func(c *gin.Context) {
+		got = RouteKey()(c)
+	})
+	doGet(r, "/user/1", nil)
+	if want := "GET:/user/:id"; got != want {
+		t.Errorf("RouteKey() = %v, want %v", got, want)
+	}
+}
+
+func TestNewTtlMiddleware(t *testing.T) {
+	limiter := &mockTtlRateLimiter{
+		TtlRateLimiterParams: ratelimiter.NewFixedTtlRateLimiterParams(10, 1, 30),
+		blocked:              map[string]bool{"1.2.3.4": true},
+	}
+	rejected := 0
+	r := newEngine(NewTtlMiddleware(HeaderKey("X-Real-Ip"), limiter, WithRejectHandler(func(c *gin.Context, r *Rejection) {
+		rejected++
+		c.String(http.StatusTooManyRequests, r.Msg)
+	})))
+
+	w := doGet(r, "/user/1", map[string]string{"X-Real-Ip": "5.6.7.8"})
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get(HeaderRateLimitLimit); got != "10" {
+		t.Errorf("%s = %v, want %v", HeaderRateLimitLimit, got, "10")
+	}
+
+	w = doGet(r, "/user/1", map[string]string{"X-Real-Ip": "1.2.3.4"})
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get(HeaderRetryAfter); got != "30" {
+		t.Errorf("%s = %v, want %v", HeaderRetryAfter, got, "30")
+	}
+	if got := w.Body.String(); got != "blocked: 1.2.3.4" {
+		t.Errorf("body = %v, want %v", got, "blocked: 1.2.3.4")
+	}
+	if rejected != 1 {
+		t.Errorf("rejected = %v, want %v", rejected, 1)
+	}
+}
+
+type mockTtlRateLimiter struct {
+	ratelimiter.TtlRateLimiterParams
+	blocked map[string]bool
+}
+
+func (m *mockTtlRateLimiter) ShouldBlock(key string, msg string) *ratelimiter.Result {
+	return m.ShouldBlock2(key, key, msg)
+}
+
+func (m *mockTtlRateLimiter) IsBlocked(blockKey string) *ratelimiter.Result {
+	if m.blocked[blockKey] {
+		return &ratelimiter.Result{Block: true, Ttl: m.GetTimeoutSeconds(), Msg: "blocked: " + blockKey}
+	}
+	return &ratelimiter.Result{}
+}
+
+func (m *mockTtlRateLimiter) ShouldBlock2(key string, blockKey string, msg string) *ratelimiter.Result {
+	return m.IsBlocked(blockKey)
+}
+
+func (m *mockTtlRateLimiter) ShouldBlockContext(ctx context.Context, key string, msg string) *ratelimiter.Result {
+	return m.ShouldBlock(key, msg)
+}
+
+func (m *mockTtlRateLimiter) IsBlockedContext(ctx context.Context, blockKey string) *ratelimiter.Result {
+	return m.IsBlocked(blockKey)
+}
+
+func (m *mockTtlRateLimiter) ShouldBlock2Context(ctx context.Context, key string, blockKey string, msg string) *ratelimiter.Result {
+	return m.ShouldBlock2(key, blockKey, msg)
+}
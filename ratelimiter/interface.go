@@ -17,7 +17,10 @@
 
 package ratelimiter
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type Interface interface {
 	// Acquire a permission, return false if be rejected.
@@ -27,6 +30,20 @@ type Interface interface {
 	Capacity() int
 }
 
+// Waitable rate limiter that can acquire multiple permissions at once,
+// and can wait until permissions are available instead of being rejected.
+type Waitable interface {
+	// AcquireN acquire n permissions at once, return false if be rejected.
+	AcquireN(n int) (acquired bool)
+
+	// Wait same as WaitN(ctx, 1)
+	Wait(ctx context.Context) error
+
+	// WaitN blocks until n permissions are acquired or ctx is done.
+	// Return ErrExceedCapacity if n > Capacity(), because it will never be satisfied.
+	WaitN(ctx context.Context, n int) error
+}
+
 type Configurable interface {
 	SetCapacity(newCap int)
 }
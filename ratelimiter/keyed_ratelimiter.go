@@ -0,0 +1,247 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"container/list"
+	gtime "github.com/chanjarster/gears/util/time"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const keyedLimiterShards = 16
+
+// LimiterFactory create a rate limiter for key
+type LimiterFactory func(key string) Interface
+
+// TokenBucketFactory a LimiterFactory creating AtomicTokenBucket
+func TokenBucketFactory(capacity int, issueRatePerSecond float64) LimiterFactory {
+	return func(key string) Interface {
+		return NewAtomicTokenBucketRate(capacity, issueRatePerSecond)
+	}
+}
+
+// FixedWindowFactory a LimiterFactory creating SyncFixedWindow
+func FixedWindowFactory(capacity int, windowSize time.Duration) LimiterFactory {
+	return func(key string) Interface {
+		return NewSyncFixedWindow(capacity, windowSize)
+	}
+}
+
+// SlidingWindowFactory a LimiterFactory creating SyncSlidingWindow
+func SlidingWindowFactory(capacity int, windowSize time.Duration) LimiterFactory {
+	return func(key string) Interface {
+		return NewSyncSlidingWindow(capacity, windowSize)
+	}
+}
+
+type keyedOptions struct {
+	idleTimeout time.Duration
+	maxKeys     int
+}
+
+type KeyedOption func(opts *keyedOptions)
+
+// WithIdleTimeout evict keys which are not accessed for idleTimeout
+func WithIdleTimeout(idleTimeout time.Duration) KeyedOption {
+	return func(opts *keyedOptions) {
+		opts.idleTimeout = idleTimeout
+	}
+}
+
+// WithMaxKeys evict least recently used keys if live keys exceed maxKeys.
+// Live keys may exceed maxKeys for a moment when keys are being added concurrently.
+func WithMaxKeys(maxKeys int) KeyedOption {
+	return func(opts *keyedOptions) {
+		opts.maxKeys = maxKeys
+	}
+}
+
+// NewKeyedLimiter New a KeyedLimiter, limiter of a key is created by factory when it's first time accessed.
+//
+// Without options keys will never be evicted.
+func NewKeyedLimiter(factory LimiterFactory, opts ...KeyedOption) *KeyedLimiter {
+	options := &keyedOptions{}
+	for _, o := range opts {
+		o(options)
+	}
+
+	k := &KeyedLimiter{
+		factory:     factory,
+		idleTimeout: int64(options.idleTimeout),
+		maxKeys:     int64(options.maxKeys),
+		nowFn:       gtime.SysNow,
+	}
+	for i := range k.shards {
+		k.shards[i] = &keyedShard{
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+	}
+	return k
+}
+
+// KeyedLimiter rate limit per key(user, ip, api key...), each key has its own rate limiter.
+//
+// Keys are spread into shards to reduce lock contention,
+// when live keys exceed maxKeys, the least recently used key among all shards is evicted.
+type KeyedLimiter struct {
+	factory     LimiterFactory
+	idleTimeout int64 // <= 0 means never evict idle keys
+	maxKeys     int64 // <= 0 means no limit
+	size        int64 // live keys
+	shards      [keyedLimiterShards]*keyedShard
+	nowFn       gtime.NowFunc
+}
+
+type keyedShard struct {
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+}
+
+type keyedEntry struct {
+	key        string
+	limiter    Interface
+	lastAccess int64
+}
+
+// Acquire a permission of key, return false if be rejected.
+func (k *KeyedLimiter) Acquire(key string) bool {
+	return k.Get(key).Acquire()
+}
+
+// Get the rate limiter of key, create it if not exists.
+func (k *KeyedLimiter) Get(key string) Interface {
+	now := k.nowFn().UnixNano()
+	shard := k.shard(key)
+
+	shard.lock.Lock()
+	k.evictIdle(shard, now)
+
+	if e, hit := shard.entries[key]; hit {
+		entry := e.Value.(*keyedEntry)
+		entry.lastAccess = now
+		shard.lru.MoveToFront(e)
+		shard.lock.Unlock()
+		return entry.limiter
+	}
+
+	entry := &keyedEntry{
+		key:        key,
+		limiter:    k.factory(key),
+		lastAccess: now,
+	}
+	shard.entries[key] = shard.lru.PushFront(entry)
+	atomic.AddInt64(&k.size, 1)
+	shard.lock.Unlock()
+
+	// 不能在持有shard.lock时锁其它shard，否则会死锁
+	for k.maxKeys > 0 && atomic.LoadInt64(&k.size) > k.maxKeys {
+		if !k.evictOldest(entry) {
+			break
+		}
+	}
+	return entry.limiter
+}
+
+// evictOldest evict the least recently used key among all shards except the one just added,
+// return false if nothing can be evicted
+func (k *KeyedLimiter) evictOldest(added *keyedEntry) bool {
+	var oldest *keyedShard
+	oldestAccess := int64(0)
+	for _, shard := range k.shards {
+		shard.lock.Lock()
+		if e := shard.lru.Back(); e != nil && e.Value != added {
+			if lastAccess := e.Value.(*keyedEntry).lastAccess; oldest == nil || lastAccess < oldestAccess {
+				oldest, oldestAccess = shard, lastAccess
+			}
+		}
+		shard.lock.Unlock()
+	}
+	if oldest == nil {
+		return false
+	}
+
+	oldest.lock.Lock()
+	defer oldest.lock.Unlock()
+	// 其它goroutine可能已经淘汰过了
+	if atomic.LoadInt64(&k.size) <= k.maxKeys {
+		return true
+	}
+	if e := oldest.lru.Back(); e != nil && e.Value != added {
+		k.remove(oldest, e)
+	}
+	return true
+}
+
+// Remove the rate limiter of key
+func (k *KeyedLimiter) Remove(key string) {
+	shard := k.shard(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if e, hit := shard.entries[key]; hit {
+		k.remove(shard, e)
+	}
+}
+
+// Len how many keys are live, idle keys are evicted before counting
+func (k *KeyedLimiter) Len() int {
+	k.EvictIdle()
+	return int(atomic.LoadInt64(&k.size))
+}
+
+// EvictIdle evict all idle keys. Idle keys are also evicted lazily on accessing,
+// call it periodically if you want to release memory in time.
+func (k *KeyedLimiter) EvictIdle() {
+	now := k.nowFn().UnixNano()
+	for _, shard := range k.shards {
+		shard.lock.Lock()
+		k.evictIdle(shard, now)
+		shard.lock.Unlock()
+	}
+}
+
+func (k *KeyedLimiter) shard(key string) *keyedShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return k.shards[h.Sum32()%keyedLimiterShards]
+}
+
+// evict idle keys from the least recently used one, must hold shard.lock
+func (k *KeyedLimiter) evictIdle(shard *keyedShard, now int64) {
+	if k.idleTimeout <= 0 {
+		return
+	}
+	for e := shard.lru.Back(); e != nil; e = shard.lru.Back() {
+		if now-e.Value.(*keyedEntry).lastAccess <= k.idleTimeout {
+			return
+		}
+		k.remove(shard, e)
+	}
+}
+
+// must hold shard.lock
+func (k *KeyedLimiter) remove(shard *keyedShard, e *list.Element) {
+	shard.lru.Remove(e)
+	delete(shard.entries, e.Value.(*keyedEntry).key)
+	atomic.AddInt64(&k.size, -1)
+}
@@ -0,0 +1,185 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiter_Acquire(t *testing.T) {
+	created := 0
+	k := NewKeyedLimiter(func(key string) Interface {
+		created++
+		return NewSyncFixedWindow(2, time.Hour)
+	})
+
+	for _, key := range []string{"foo", "bar"} {
+		for i := 0; i < 2; i++ {
+			if got := k.Acquire(key); !got {
+				t.Errorf("Acquire(%s) = %v, want %v", key, got, true)
+			}
+		}
+		if got := k.Acquire(key); got {
+			t.Errorf("Acquire(%s) = %v, want %v", key, got, false)
+		}
+	}
+	if got, want := created, 2; got != want {
+		t.Errorf("created = %v, want %v", got, want)
+	}
+	if got, want := k.Len(), 2; got != want {
+		t.Errorf("Len() = %v, want %v", got, want)
+	}
+
+	k.Remove("foo")
+	k.Remove("zoo")
+	if got, want := k.Len(), 1; got != want {
+		t.Errorf("Len() = %v, want %v", got, want)
+	}
+	// 被删除的key重新创建
+	if got := k.Acquire("foo"); !got {
+		t.Errorf("Acquire(foo) = %v, want %v", got, true)
+	}
+}
+
+func TestKeyedLimiter_idleTimeout(t *testing.T) {
+	clock := time.Now()
+	k := NewKeyedLimiter(FixedWindowFactory(1, time.Hour), WithIdleTimeout(time.Minute))
+	k.nowFn = func() time.Time {
+		return clock
+	}
+
+	k.Acquire("foo")
+	clock = clock.Add(30 * time.Second)
+	k.Acquire("bar")
+	if got, want := k.Len(), 2; got != want {
+		t.Errorf("Len() = %v, want %v", got, want)
+	}
+
+	// foo闲置超过1分钟，bar没有
+	clock = clock.Add(31 * time.Second)
+	if got, want := k.Len(), 1; got != want {
+		t.Errorf("Len() = %v, want %v", got, want)
+	}
+	if got := k.Acquire("foo"); !got {
+		t.Errorf("Acquire(foo) = %v, want %v", got, true)
+	}
+	if got := k.Acquire("bar"); got {
+		t.Errorf("Acquire(bar) = %v, want %v", got, false)
+	}
+
+	// 访问会刷新闲置时间
+	clock = clock.Add(59 * time.Second)
+	k.Get("bar")
+	clock = clock.Add(59 * time.Second)
+	if got := k.Acquire("bar"); got {
+		t.Errorf("Acquire(bar) = %v, want %v", got, false)
+	}
+}
+
+func TestKeyedLimiter_maxKeys(t *testing.T) {
+	k := NewKeyedLimiter(TokenBucketFactory(1, 1), WithMaxKeys(100))
+	for i := 0; i < 1000; i++ {
+		k.Acquire(strconv.Itoa(i))
+		if got := k.Len(); got > 100 {
+			t.Fatalf("Len() = %v, want <= %v", got, 100)
+		}
+	}
+
+	t.Run("less than shards", func(t *testing.T) {
+		k := NewKeyedLimiter(TokenBucketFactory(1, 1), WithMaxKeys(3))
+		for i := 0; i < 100; i++ {
+			k.Acquire(strconv.Itoa(i))
+			if got := k.Len(); got > 3 {
+				t.Fatalf("Len() = %v, want <= %v", got, 3)
+			}
+		}
+	})
+
+	t.Run("least recently used among shards is evicted", func(t *testing.T) {
+		k := NewKeyedLimiter(TokenBucketFactory(1, 0), WithMaxKeys(2))
+		clock := time.Now()
+		k.nowFn = func() time.Time {
+			clock = clock.Add(time.Millisecond)
+			return clock
+		}
+		// 让2个key落在不同的shard里
+		keys := []string{"0"}
+		for i := 1; len(keys) < 3; i++ {
+			if key := strconv.Itoa(i); k.shard(key) != k.shard(keys[len(keys)-1]) && k.shard(key) != k.shard(keys[0]) {
+				keys = append(keys, key)
+			}
+		}
+		k.Acquire(keys[0])
+		k.Acquire(keys[1])
+		k.Get(keys[0])
+		k.Acquire(keys[2])
+		if got := k.Acquire(keys[0]); got {
+			t.Errorf("Acquire(%s) = %v, want %v", keys[0], got, false)
+		}
+		// keys[1]被淘汰了，重新创建
+		if got := k.Acquire(keys[1]); !got {
+			t.Errorf("Acquire(%s) = %v, want %v", keys[1], got, true)
+		}
+	})
+
+	t.Run("least recently used is evicted", func(t *testing.T) {
+		k := NewKeyedLimiter(TokenBucketFactory(1, 0), WithMaxKeys(2))
+		// 让3个key落在同一个shard里
+		keys := make([]string, 0, 3)
+		shard := k.shard("0")
+		for i := 0; len(keys) < 3; i++ {
+			if key := strconv.Itoa(i); k.shard(key) == shard {
+				keys = append(keys, key)
+			}
+		}
+		k.Acquire(keys[0])
+		k.Acquire(keys[1])
+		k.Get(keys[0])
+		k.Acquire(keys[2])
+		if got, want := k.Len(), 2; got != want {
+			t.Errorf("Len() = %v, want %v", got, want)
+		}
+		if got := k.Acquire(keys[0]); got {
+			t.Errorf("Acquire(%s) = %v, want %v", keys[0], got, false)
+		}
+		// keys[1]被淘汰了，重新创建
+		if got := k.Acquire(keys[1]); !got {
+			t.Errorf("Acquire(%s) = %v, want %v", keys[1], got, true)
+		}
+	})
+}
+
+func TestKeyedLimiter_concurrent(t *testing.T) {
+	k := NewKeyedLimiter(SlidingWindowFactory(10, time.Hour), WithMaxKeys(50), WithIdleTimeout(time.Second))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k.Acquire(strconv.Itoa((g*1000 + i) % 100))
+			}
+		}(g)
+	}
+	wg.Wait()
+	if got := k.Len(); got <= 0 || got > 50 {
+		t.Errorf("Len() = %v, want (0, %v]", got, 50)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	gtime "github.com/chanjarster/gears/util/time"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull returned by LeakyBucket when the queue is full
+var ErrQueueFull = errors.New("ratelimiter: queue is full")
+
+// LeakyBucket 漏桶限流器
+//
+// 请求先进入一个有界队列，然后以固定速率从队列中放行，所以它不会拒绝突发的请求，而是把它们排队、平滑成恒定速率，
+// 只有队列满了才会拒绝。适合调用有严格速率限制的第三方接口，比如短信接口要求不超过 N次/秒。
+//
+// 和 TokenBucket 不同，TokenBucket 允许 capacity 个请求突发通过，而 LeakyBucket 放行的请求之间至少间隔 1 / rate。
+type LeakyBucket interface {
+	// Capacity max requests waiting in the queue, not including the one being released
+	Capacity() int
+
+	// Wait blocks until it's the caller's turn.
+	// Return ErrQueueFull immediately if the queue is full,
+	// return ctx.Err() if ctx is done before the turn, context.DeadlineExceeded immediately if the deadline is earlier than the turn.
+	Wait(ctx context.Context) error
+
+	// Submit put task into the queue and return immediately, task is run in a new goroutine when its turn comes,
+	// it's dropped if ctx is done before that.
+	// Return ErrQueueFull if the queue is full.
+	Submit(ctx context.Context, task func()) error
+}
+
+// NewSyncLeakyBucket New a SyncLeakyBucket
+//
+//	capacity: max requests waiting in the queue
+//	ratePerSecond: releasing rate(per second), <= 0 means rejecting all requests
+func NewSyncLeakyBucket(capacity int, ratePerSecond float64) *SyncLeakyBucket {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &SyncLeakyBucket{
+		capacity:        capacity,
+		releaseInterval: int64(rateToInterval(ratePerSecond)),
+		nowFn:           gtime.SysNow,
+	}
+}
+
+// LeakyBucket implementation using "sync.Mutex"
+//
+// The queue is virtual: it only records the time of next release, each request takes a release time and sleeps until then,
+// so there is no background goroutine.
+// If a waiting request is canceled, its release time is given back only if no one is queued after it,
+// otherwise the rate is a little lower than expected for a moment.
+type SyncLeakyBucket struct {
+	lock            sync.Mutex
+	capacity        int
+	releaseInterval int64 // nanoseconds between releases, <= 0 means rejecting all requests
+	next            int64 // time of next release
+	nowFn           gtime.NowFunc
+	observable
+}
+
+func (s *SyncLeakyBucket) Capacity() int {
+	return s.capacity
+}
+
+// Usage ratio of waiting requests in the queue
+func (s *SyncLeakyBucket) Usage() float64 {
+	if s.releaseInterval <= 0 {
+		return 0
+	}
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	waiting := float64(s.next-now)/float64(s.releaseInterval) - 1
+	return usageRatio(waiting, float64(s.capacity))
+}
+
+func (s *SyncLeakyBucket) Wait(ctx context.Context) error {
+	return s.observeWait(1, s.wait(ctx))
+}
+
+func (s *SyncLeakyBucket) wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := s.nowFn().UnixNano()
+	turn, err := s.take(ctx, now)
+	if err != nil {
+		return err
+	}
+	if turn <= now {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(turn - now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		s.giveBack(turn)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (s *SyncLeakyBucket) Submit(ctx context.Context, task func()) error {
+	return s.observeWait(1, s.submit(ctx, task))
+}
+
+func (s *SyncLeakyBucket) submit(ctx context.Context, task func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := s.nowFn().UnixNano()
+	turn, err := s.take(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	time.AfterFunc(time.Duration(turn-now), func() {
+		if ctx.Err() != nil {
+			s.giveBack(turn)
+			return
+		}
+		task()
+	})
+	return nil
+}
+
+// take the next release time, return error if the queue is full or ctx deadline is earlier than it
+func (s *SyncLeakyBucket) take(ctx context.Context, now int64) (int64, error) {
+	if s.releaseInterval <= 0 {
+		return 0, ErrQueueFull
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	turn := s.next
+	if turn < now {
+		turn = now
+	}
+	if turn-now > int64(s.capacity)*s.releaseInterval {
+		return 0, ErrQueueFull
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.UnixNano() < turn {
+		return 0, context.DeadlineExceeded
+	}
+	s.next = turn + s.releaseInterval
+	return turn, nil
+}
+
+// give back the release time if no one is queued after it
+func (s *SyncLeakyBucket) giveBack(turn int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.next == turn+s.releaseInterval {
+		s.next = turn
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewSyncLeakyBucket(t *testing.T) {
+	s := NewSyncLeakyBucket(-1, 100)
+	if got, want := s.Capacity(), 0; got != want {
+		t.Errorf("NewSyncLeakyBucket().Capacity() = %v, want %v", got, want)
+	}
+	if got, want := s.releaseInterval, int64(10*time.Millisecond); got != want {
+		t.Errorf("NewSyncLeakyBucket().releaseInterval = %v, want %v", got, want)
+	}
+	if got := s.nowFn; got == nil {
+		t.Errorf("NewSyncLeakyBucket().nowFn is nil, want not nil")
+	}
+
+	if err := NewSyncLeakyBucket(1, 0).Wait(context.Background()); err != ErrQueueFull {
+		t.Errorf("Wait() error = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestSyncLeakyBucket_take(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+	s := NewSyncLeakyBucket(2, 1)
+	s.nowFn = clock.Now
+	now := clock.Now().UnixNano()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		turn, err := s.take(ctx, now)
+		if err != nil {
+			t.Fatalf("take() error = %v", err)
+		}
+		if got, want := time.Duration(turn-now), time.Duration(i)*time.Second; got != want {
+			t.Errorf("take() turn = now + %v, want now + %v", got, want)
+		}
+	}
+	if got, want := s.Usage(), 1.0; got != want {
+		t.Errorf("Usage() = %v, want %v", got, want)
+	}
+	if _, err := s.take(ctx, now); err != ErrQueueFull {
+		t.Errorf("take() error = %v, want %v", err, ErrQueueFull)
+	}
+
+	// released one
+	clock.Sleep(time.Second)
+	now = clock.Now().UnixNano()
+	if got, want := s.Usage(), 0.5; got != want {
+		t.Errorf("Usage() = %v, want %v", got, want)
+	}
+	deadlineCtx, cancel := context.WithDeadline(ctx, clock.Now().Add(time.Second))
+	defer cancel()
+	if _, err := s.take(deadlineCtx, now); err != context.DeadlineExceeded {
+		t.Errorf("take() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	turn, err := s.take(ctx, now)
+	if err != nil {
+		t.Fatalf("take() error = %v", err)
+	}
+	if got, want := time.Duration(turn-now), 2*time.Second; got != want {
+		t.Errorf("take() turn = now + %v, want now + %v", got, want)
+	}
+
+	// the last one is given back
+	s.giveBack(turn - s.releaseInterval)
+	if got, want := s.next, turn+s.releaseInterval; got != want {
+		t.Errorf("giveBack() not the last one, next = %v, want %v", got, want)
+	}
+	s.giveBack(turn)
+	if got, want := s.next, turn; got != want {
+		t.Errorf("giveBack() the last one, next = %v, want %v", got, want)
+	}
+
+	// idle for a long time, doesn't accumulate
+	clock.Sleep(time.Hour)
+	if got, want := s.Usage(), 0.0; got != want {
+		t.Errorf("Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncLeakyBucket_Wait(t *testing.T) {
+	s := NewSyncLeakyBucket(2, 50)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := s.Wait(ctx); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 Wait() took %v, want >= %v", elapsed, 40*time.Millisecond)
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := s.Wait(cancelCtx); err != context.Canceled {
+		t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+	}
+
+	// canceled while waiting, the turn is given back
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Hour)
+	time.AfterFunc(5*time.Millisecond, cancel)
+	if err := s.Wait(timeoutCtx); err != context.Canceled {
+		t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+	}
+	if err := s.Wait(ctx); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
+
+func TestSyncLeakyBucket_Submit(t *testing.T) {
+	s := NewSyncLeakyBucket(3, 50)
+	ctx := context.Background()
+
+	released := make(chan time.Time, 4)
+	task := func() {
+		released <- time.Now()
+	}
+	for i := 0; i < 4; i++ {
+		if err := s.Submit(ctx, task); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+	if err := s.Submit(ctx, task); err != ErrQueueFull {
+		t.Errorf("Submit() error = %v, want %v", err, ErrQueueFull)
+	}
+
+	var last time.Time
+	for i := 0; i < 4; i++ {
+		now := <-released
+		if i > 0 {
+			if gap := now.Sub(last); gap < 15*time.Millisecond {
+				t.Errorf("released gap = %v, want >= %v", gap, 15*time.Millisecond)
+			}
+		}
+		last = now
+	}
+
+	// dropped if ctx is done before its turn
+	cancelCtx, cancel := context.WithCancel(ctx)
+	if err := s.Submit(cancelCtx, task); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	cancel()
+	select {
+	case <-released:
+		t.Errorf("task of canceled ctx is run")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics an Observer counts accepted, rejected requests and triggered blockings of a rate limiter.
+// Requests are counted rather than permissions, i.e. AcquireN(5) counts 1.
+type Metrics struct {
+	name      string
+	accepted  int64
+	rejected  int64
+	triggered int64
+	usage     UsageReporter // nil if the limiter doesn't report usage
+}
+
+func (m *Metrics) OnAcquire(n int, acquired bool) {
+	if acquired {
+		atomic.AddInt64(&m.accepted, 1)
+	} else {
+		atomic.AddInt64(&m.rejected, 1)
+	}
+}
+
+func (m *Metrics) OnTrigger() {
+	atomic.AddInt64(&m.triggered, 1)
+}
+
+func (m *Metrics) Name() string {
+	return m.name
+}
+
+func (m *Metrics) Accepted() int64 {
+	return atomic.LoadInt64(&m.accepted)
+}
+
+func (m *Metrics) Rejected() int64 {
+	return atomic.LoadInt64(&m.rejected)
+}
+
+func (m *Metrics) Triggered() int64 {
+	return atomic.LoadInt64(&m.triggered)
+}
+
+// Usage current usage of the limiter, ok is false if the limiter doesn't implement UsageReporter
+func (m *Metrics) Usage() (usage float64, ok bool) {
+	if m.usage == nil {
+		return 0, false
+	}
+	return m.usage.Usage(), true
+}
+
+// MetricsRegistry holds Metrics of rate limiters, and exports them in Prometheus text format or by expvar,
+// so no metrics library is needed.
+//
+//	registry := ratelimiter.NewMetricsRegistry()
+//	registry.Observe("login", loginLimiter)
+//	http.Handle("/metrics", registry)
+//	registry.PublishExpvar("ratelimiter")
+type MetricsRegistry struct {
+	lock    sync.RWMutex
+	metrics map[string]*Metrics
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		metrics: make(map[string]*Metrics),
+	}
+}
+
+// Observe create Metrics named name and set it as limiter's observer, Metrics of the same name is replaced.
+// Return ErrNotObservable if limiter doesn't implement Observable.
+//
+//	name: name of the limiter, exported as label value
+//	limiter: rate limiter implements Observable, if it also implements UsageReporter, usage is exported too
+func (r *MetricsRegistry) Observe(name string, limiter interface{}) (*Metrics, error) {
+	o, ok := limiter.(Observable)
+	if !ok {
+		return nil, ErrNotObservable
+	}
+	m := &Metrics{name: name}
+	if u, ok := limiter.(UsageReporter); ok {
+		m.usage = u
+	}
+	o.SetObserver(m)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metrics[name] = m
+	return m, nil
+}
+
+// Remove Metrics of name, the limiter still reports to it but it isn't exported anymore
+func (r *MetricsRegistry) Remove(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.metrics, name)
+}
+
+// Get Metrics of name, nil if not found
+func (r *MetricsRegistry) Get(name string) *Metrics {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.metrics[name]
+}
+
+// all metrics sorted by name
+func (r *MetricsRegistry) sorted() []*Metrics {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	result := make([]*Metrics, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// WritePrometheus write metrics in Prometheus text exposition format
+//
+// see: https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+func (r *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	metrics := r.sorted()
+	bw := bufio.NewWriter(w)
+
+	writeFamily := func(family, help, typ string, valueFn func(m *Metrics) (string, bool)) {
+		fmt.Fprintf(bw, "# HELP %s %s\n", family, help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", family, typ)
+		for _, m := range metrics {
+			if value, ok := valueFn(m); ok {
+				fmt.Fprintf(bw, "%s{limiter=\"%s\"} %s\n", family, escapeLabelValue(m.name), value)
+			}
+		}
+	}
+	counter := func(fn func(m *Metrics) int64) func(m *Metrics) (string, bool) {
+		return func(m *Metrics) (string, bool) {
+			return strconv.FormatInt(fn(m), 10), true
+		}
+	}
+
+	writeFamily("ratelimiter_accepted_total", "Number of accepted requests.", "counter",
+		counter((*Metrics).Accepted))
+	writeFamily("ratelimiter_rejected_total", "Number of rejected requests.", "counter",
+		counter((*Metrics).Rejected))
+	writeFamily("ratelimiter_triggered_total", "Number of triggered blockings.", "counter",
+		counter((*Metrics).Triggered))
+	writeFamily("ratelimiter_usage", "Ratio of capacity in use.", "gauge",
+		func(m *Metrics) (string, bool) {
+			usage, ok := m.Usage()
+			return strconv.FormatFloat(usage, 'g', -1, 64), ok
+		})
+
+	return bw.Flush()
+}
+
+// ServeHTTP serve metrics in Prometheus text exposition format
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// PublishExpvar publish metrics as an expvar variable, value is like:
+//
+//	{"login": {"accepted": 10, "rejected": 2, "triggered": 1, "usage": 0.5}}
+//
+// usage is absent if the limiter doesn't report usage. Like expvar.Publish, it panics if name is already published.
+func (r *MetricsRegistry) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(r.expvarValue))
+}
+
+func (r *MetricsRegistry) expvarValue() interface{} {
+	result := make(map[string]map[string]interface{})
+	for _, m := range r.sorted() {
+		value := map[string]interface{}{
+			"accepted":  m.Accepted(),
+			"rejected":  m.Rejected(),
+			"triggered": m.Triggered(),
+		}
+		if usage, ok := m.Usage(); ok {
+			value["usage"] = usage
+		}
+		result[m.name] = value
+	}
+	return result
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
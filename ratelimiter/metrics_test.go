@@ -0,0 +1,139 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetricsRegistry_Observe(t *testing.T) {
+	registry := NewMetricsRegistry()
+
+	if _, err := registry.Observe("foo", struct{}{}); err != ErrNotObservable {
+		t.Errorf("Observe() error = %v, want %v", err, ErrNotObservable)
+	}
+
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+	ttl := NewSyncTtlRateLimiter(NewFixedTtlRateLimiterParams(1, 60, 60), clock.Now)
+	m, err := registry.Observe("ttl", ttl)
+	if err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	ttl.ShouldBlock("foo", "")
+	ttl.ShouldBlock("foo", "")
+	if m.Accepted() != 1 || m.Rejected() != 1 || m.Triggered() != 1 {
+		t.Errorf("accepted = %v, rejected = %v, triggered = %v, want 1, 1, 1", m.Accepted(), m.Rejected(), m.Triggered())
+	}
+	if _, ok := m.Usage(); ok {
+		t.Errorf("Usage() ok = %v, want %v", ok, false)
+	}
+	if got := registry.Get("ttl"); got != m {
+		t.Errorf("Get() = %v, want %v", got, m)
+	}
+
+	registry.Remove("ttl")
+	if got := registry.Get("ttl"); got != nil {
+		t.Errorf("Get() = %v, want nil", got)
+	}
+}
+
+func newTestMetricsRegistry(t *testing.T) *MetricsRegistry {
+	registry := NewMetricsRegistry()
+
+	window := NewSyncFixedWindow(4, time.Hour)
+	if _, err := registry.Observe("window", window); err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	window.AcquireN(2)
+	window.AcquireN(3)
+
+	ttl := NewSyncTtlRateLimiter(NewFixedTtlRateLimiterParams(1, 60, 60), nil)
+	if _, err := registry.Observe(`t"t\l`, ttl); err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	ttl.ShouldBlock("foo", "")
+	ttl.ShouldBlock("foo", "")
+	return registry
+}
+
+func TestMetricsRegistry_WritePrometheus(t *testing.T) {
+	registry := newTestMetricsRegistry(t)
+
+	want := `# HELP ratelimiter_accepted_total Number of accepted requests.
+# TYPE ratelimiter_accepted_total counter
+ratelimiter_accepted_total{limiter="t\"t\\l"} 1
+ratelimiter_accepted_total{limiter="window"} 1
+# HELP ratelimiter_rejected_total Number of rejected requests.
+# TYPE ratelimiter_rejected_total counter
+ratelimiter_rejected_total{limiter="t\"t\\l"} 1
+ratelimiter_rejected_total{limiter="window"} 1
+# HELP ratelimiter_triggered_total Number of triggered blockings.
+# TYPE ratelimiter_triggered_total counter
+ratelimiter_triggered_total{limiter="t\"t\\l"} 1
+ratelimiter_triggered_total{limiter="window"} 0
+# HELP ratelimiter_usage Ratio of capacity in use.
+# TYPE ratelimiter_usage gauge
+ratelimiter_usage{limiter="window"} 0.5
+`
+	buf := &bytes.Buffer{}
+	if err := registry.WritePrometheus(buf); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("WritePrometheus() =\n%v\nwant\n%v", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Body.String(); got != want {
+		t.Errorf("ServeHTTP() body =\n%v\nwant\n%v", got, want)
+	}
+	if got, want := rec.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("ServeHTTP() Content-Type = %v, want %v", got, want)
+	}
+}
+
+func TestMetricsRegistry_PublishExpvar(t *testing.T) {
+	registry := newTestMetricsRegistry(t)
+	registry.PublishExpvar("ratelimiter_test")
+
+	got := make(map[string]map[string]float64)
+	if err := json.Unmarshal([]byte(expvar.Get("ratelimiter_test").String()), &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	want := map[string]map[string]float64{
+		`t"t\l`:  {"accepted": 1, "rejected": 1, "triggered": 1},
+		"window": {"accepted": 1, "rejected": 1, "triggered": 0, "usage": 0.5},
+	}
+	for name, values := range want {
+		if len(got[name]) != len(values) {
+			t.Errorf("expvar %s = %v, want %v", name, got[name], values)
+			continue
+		}
+		for k, v := range values {
+			if got[name][k] != v {
+				t.Errorf("expvar %s.%s = %v, want %v", name, k, got[name][k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotObservable returned when the rate limiter doesn't implement Observable
+var ErrNotObservable = errors.New("ratelimiter: limiter is not observable")
+
+// Observer receives events of a rate limiter, it must be goroutine safe and fast,
+// because it's called in the acquiring path.
+type Observer interface {
+	// OnAcquire called after acquiring n permissions, acquired is false if be rejected.
+	// For TtlRateLimiter, n is always 1 and acquired is false if the request is blocked.
+	OnAcquire(n int, acquired bool)
+
+	// OnTrigger called when TtlRateLimiter triggers a blocking
+	OnTrigger()
+}
+
+// Observable rate limiter that accepts an Observer, all rate limiters in this package implement it.
+type Observable interface {
+	// SetObserver set the observer, nil means no observer. It's not goroutine safe, call it before using the limiter.
+	SetObserver(observer Observer)
+}
+
+// UsageReporter rate limiter that knows how much of its capacity is in use.
+// In-memory rate limiters implement it, redis backed ones don't, because it costs a round trip.
+type UsageReporter interface {
+	// Usage ratio of capacity in use, in [0, 1]
+	Usage() float64
+}
+
+// QuotaReporter rate limiter that knows its remaining quota, used by middlewares to set
+// X-RateLimit-Remaining and X-RateLimit-Reset. Like UsageReporter, only in-memory rate limiters implement it.
+type QuotaReporter interface {
+	// Quota how many permissions can be acquired now, and how long until all capacity is available again
+	Quota() (remaining int, reset time.Duration)
+}
+
+// embedded by rate limiters to implement Observable
+type observable struct {
+	observer Observer
+}
+
+func (o *observable) SetObserver(observer Observer) {
+	o.observer = observer
+}
+
+// notify observer the acquiring result, return acquired as is
+func (o *observable) observe(n int, acquired bool) bool {
+	if o.observer != nil {
+		o.observer.OnAcquire(n, acquired)
+	}
+	return acquired
+}
+
+// notify observer the waiting result, return err as is
+func (o *observable) observeWait(n int, err error) error {
+	o.observe(n, err == nil)
+	return err
+}
+
+// notify observer the TtlRateLimiter result, return result as is
+func (o *observable) observeResult(result *Result) *Result {
+	if o.observer != nil {
+		o.observer.OnAcquire(1, !result.Block)
+		if result.Triggered {
+			o.observer.OnTrigger()
+		}
+	}
+	return result
+}
+
+// used / capacity, clamped to [0, 1], 0 if capacity <= 0
+func usageRatio(used, capacity float64) float64 {
+	if capacity <= 0 || used <= 0 {
+		return 0
+	}
+	if used >= capacity {
+		return 1
+	}
+	return used / capacity
+}
@@ -0,0 +1,308 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+type recordingObserver struct {
+	accepted  int
+	rejected  int
+	triggered int
+}
+
+func (r *recordingObserver) OnAcquire(n int, acquired bool) {
+	if acquired {
+		r.accepted += n
+	} else {
+		r.rejected += n
+	}
+}
+
+func (r *recordingObserver) OnTrigger() {
+	r.triggered++
+}
+
+func TestObservable_Waitable(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+
+	tests := []struct {
+		name    string
+		limiter interface {
+			Interface
+			Waitable
+		}
+	}{
+		{"SyncTokenBucket", &SyncTokenBucket{capacity: 2, tokens: 2, nowFn: clock.Now}},
+		{"AtomicTokenBucket", &AtomicTokenBucket{capacity: 2, emptyTimestamp: math.MinInt64 / 2, nowFn: clock.Now}},
+		{"SyncFixedWindow", &SyncFixedWindow{capacity: 2, windowSize: int64(time.Hour), nowFn: clock.Now}},
+		{"SyncSlidingWindow", func() *SyncSlidingWindow {
+			s := NewSyncSlidingWindow(2, time.Hour)
+			s.nowFn = clock.Now
+			return s
+		}()},
+		{"SyncSlidingWindowCounter", &SyncSlidingWindowCounter{capacity: 2, windowSize: int64(time.Hour), nowFn: clock.Now}},
+		{"AtomicGcra", &AtomicGcra{capacity: 2, emissionInterval: int64(time.Hour), nowFn: clock.Now}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observer := &recordingObserver{}
+			tt.limiter.(Observable).SetObserver(observer)
+
+			tt.limiter.Acquire()
+			if got := tt.limiter.AcquireN(2); got {
+				t.Errorf("AcquireN(2) = %v, want %v", got, false)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := tt.limiter.WaitN(ctx, 1); err != nil {
+				t.Errorf("WaitN() error = %v", err)
+			}
+			if err := tt.limiter.WaitN(ctx, 1); err == nil {
+				t.Errorf("WaitN() error = nil, want error")
+			}
+			if acquired, _ := TryAcquire(context.Background(), tt.limiter); acquired {
+				t.Errorf("TryAcquire() = %v, want %v", acquired, false)
+			}
+
+			if got, want := observer.accepted, 2; got != want {
+				t.Errorf("accepted = %v, want %v", got, want)
+			}
+			if got, want := observer.rejected, 4; got != want {
+				t.Errorf("rejected = %v, want %v", got, want)
+			}
+			if got, want := tt.limiter.(UsageReporter).Usage(), 1.0; got != want {
+				t.Errorf("Usage() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestObservable_Reserve(t *testing.T) {
+	for _, tb := range []TokenBucket{NewSyncTokenBucketInterval(1, 0), NewAtomicTokenBucketInterval(1, 0)} {
+		observer := &recordingObserver{}
+		tb.(Observable).SetObserver(observer)
+
+		if got := tb.Reserve(1).OK(); !got {
+			t.Errorf("Reserve(1).OK() = %v, want %v", got, true)
+		}
+		if got := tb.Reserve(1).OK(); got {
+			t.Errorf("Reserve(1).OK() = %v, want %v", got, false)
+		}
+		if observer.accepted != 1 || observer.rejected != 1 {
+			t.Errorf("%T observed accepted = %v, rejected = %v, want 1, 1", tb, observer.accepted, observer.rejected)
+		}
+	}
+}
+
+func TestObservable_Adaptive(t *testing.T) {
+	s := NewSyncAdaptiveLimiter(1, 1, 1, NewAimd(0.5, time.Second))
+	observer := &recordingObserver{}
+	s.SetObserver(observer)
+
+	release, _ := s.Acquire()
+	if got, want := s.Usage(), 1.0; got != want {
+		t.Errorf("Usage() = %v, want %v", got, want)
+	}
+	s.Acquire()
+	release(true)
+	if got, want := s.Usage(), 0.0; got != want {
+		t.Errorf("Usage() = %v, want %v", got, want)
+	}
+	if observer.accepted != 1 || observer.rejected != 1 {
+		t.Errorf("observed accepted = %v, rejected = %v, want 1, 1", observer.accepted, observer.rejected)
+	}
+}
+
+func TestObservable_TtlRateLimiter(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+	limiter := NewSyncTtlRateLimiter(NewFixedTtlRateLimiterParams(1, 60, 60), clock.Now)
+	observer := &recordingObserver{}
+	limiter.(Observable).SetObserver(observer)
+
+	limiter.ShouldBlock("foo", "")
+	limiter.ShouldBlock("foo", "")
+	limiter.ShouldBlock("foo", "")
+	limiter.IsBlocked("foo")
+
+	if observer.accepted != 1 || observer.rejected != 2 || observer.triggered != 1 {
+		t.Errorf("observed accepted = %v, rejected = %v, triggered = %v, want 1, 2, 1",
+			observer.accepted, observer.rejected, observer.triggered)
+	}
+}
+
+func TestObservable_Composite(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+	limiter := NewSyncCompositeLimiter()
+	limiter.nowFn = clock.Now
+	observer := &recordingObserver{}
+	limiter.SetObserver(observer)
+
+	tier := Tier{Key: "foo", Rules: []Rule{{1, time.Minute}}}
+	limiter.Acquire(tier)
+	limiter.Acquire(tier)
+
+	if observer.accepted != 1 || observer.rejected != 1 {
+		t.Errorf("observed accepted = %v, rejected = %v, want 1, 1", observer.accepted, observer.rejected)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+
+	t.Run("SyncTokenBucket", func(t *testing.T) {
+		tb := &SyncTokenBucket{capacity: 4, tokens: 4, issueInterval: int64(time.Second), nowFn: clock.Now,
+			lastIssueTimestamp: clock.Now().UnixNano()}
+		tb.AcquireN(3)
+		clock.Sleep(time.Second)
+		if got, want := tb.Usage(), 0.5; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("AtomicTokenBucket", func(t *testing.T) {
+		tb := &AtomicTokenBucket{capacity: 4, issueInterval: int64(time.Second), nowFn: clock.Now}
+		tb.emptyTimestamp = clock.Now().UnixNano() - 4*int64(time.Second)
+		tb.AcquireN(3)
+		clock.Sleep(time.Second)
+		if got, want := tb.Usage(), 0.5; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("SyncFixedWindow", func(t *testing.T) {
+		s := &SyncFixedWindow{capacity: 4, windowSize: int64(time.Minute), nowFn: clock.Now}
+		s.AcquireN(2)
+		if got, want := s.Usage(), 0.5; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+		clock.Sleep(time.Minute)
+		if got, want := s.Usage(), 0.0; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("SyncSlidingWindow", func(t *testing.T) {
+		s := NewSyncSlidingWindow(4, time.Minute)
+		s.nowFn = clock.Now
+		s.Acquire()
+		clock.Sleep(30 * time.Second)
+		s.Acquire()
+		if got, want := s.Usage(), 0.5; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+		clock.Sleep(31 * time.Second)
+		if got, want := s.Usage(), 0.25; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("SyncSlidingWindowCounter", func(t *testing.T) {
+		clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+		s := NewSyncSlidingWindowCounter(4, time.Minute)
+		s.nowFn = clock.Now
+		s.AcquireN(4)
+		clock.Sleep(90 * time.Second)
+		if got, want := s.Usage(), 0.5; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("AtomicGcra", func(t *testing.T) {
+		g := NewAtomicGcra(4, 1)
+		g.nowFn = clock.Now
+		g.AcquireN(3)
+		clock.Sleep(time.Second)
+		if got, want := g.Usage(), 0.5; got != want {
+			t.Errorf("Usage() = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestQuota(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+
+	assertQuota := func(t *testing.T, reporter QuotaReporter, wantRemaining int, wantReset time.Duration) {
+		t.Helper()
+		if remaining, reset := reporter.Quota(); remaining != wantRemaining || reset != wantReset {
+			t.Errorf("Quota() = %v, %v, want %v, %v", remaining, reset, wantRemaining, wantReset)
+		}
+	}
+
+	t.Run("SyncTokenBucket", func(t *testing.T) {
+		tb := &SyncTokenBucket{capacity: 4, tokens: 4, issueInterval: int64(time.Second), nowFn: clock.Now,
+			lastIssueTimestamp: clock.Now().UnixNano()}
+		assertQuota(t, tb, 4, 0)
+		tb.AcquireN(3)
+		clock.Sleep(time.Second)
+		assertQuota(t, tb, 2, 2*time.Second)
+	})
+
+	t.Run("AtomicTokenBucket", func(t *testing.T) {
+		tb := &AtomicTokenBucket{capacity: 4, issueInterval: int64(time.Second), nowFn: clock.Now}
+		tb.emptyTimestamp = clock.Now().UnixNano() - 4*int64(time.Second)
+		assertQuota(t, tb, 4, 0)
+		tb.AcquireN(3)
+		clock.Sleep(time.Second)
+		assertQuota(t, tb, 2, 2*time.Second)
+	})
+
+	t.Run("SyncFixedWindow", func(t *testing.T) {
+		s := &SyncFixedWindow{capacity: 4, windowSize: int64(time.Minute), nowFn: clock.Now}
+		assertQuota(t, s, 4, 0)
+		s.AcquireN(2)
+		clock.Sleep(10 * time.Second)
+		assertQuota(t, s, 2, time.Duration(s.until-clock.Now().UnixNano()+1))
+		clock.Sleep(time.Minute)
+		assertQuota(t, s, 4, 0)
+	})
+
+	t.Run("SyncSlidingWindow", func(t *testing.T) {
+		s := NewSyncSlidingWindow(4, time.Minute)
+		s.nowFn = clock.Now
+		s.Acquire()
+		clock.Sleep(30 * time.Second)
+		s.Acquire()
+		assertQuota(t, s, 2, time.Minute+1)
+		clock.Sleep(31 * time.Second)
+		assertQuota(t, s, 3, 29*time.Second+1)
+	})
+
+	t.Run("SyncSlidingWindowCounter", func(t *testing.T) {
+		clock := &mockTtlClock{now: time.Unix(1600002000, 0)}
+		s := NewSyncSlidingWindowCounter(4, time.Minute)
+		s.nowFn = clock.Now
+		s.AcquireN(4)
+		assertQuota(t, s, 0, 2*time.Minute)
+		clock.Sleep(90 * time.Second)
+		assertQuota(t, s, 2, 30*time.Second)
+	})
+
+	t.Run("AtomicGcra", func(t *testing.T) {
+		g := NewAtomicGcra(4, 1)
+		g.nowFn = clock.Now
+		assertQuota(t, g, 4, 0)
+		g.AcquireN(3)
+		clock.Sleep(time.Second)
+		assertQuota(t, g, 2, 2*time.Second)
+	})
+}
@@ -39,11 +39,30 @@ func BenchmarkSyncSlidingWindow_Acquire(b *testing.B) {
 	benchmarkTokenBucket_Acquire(b, bucket)
 }
 
+func BenchmarkSyncSlidingWindowCounter_Acquire(b *testing.B) {
+	bucket := NewSyncSlidingWindowCounter(10, time.Second)
+	benchmarkTokenBucket_Acquire(b, bucket)
+}
+
 func BenchmarkSyncFixedWindow_Acquire(b *testing.B) {
 	bucket := NewSyncFixedWindow(10, time.Second)
 	benchmarkTokenBucket_Acquire(b, bucket)
 }
 
+func BenchmarkAtomicGcra_Acquire(b *testing.B) {
+	bucket := NewAtomicGcra(10, 10)
+	benchmarkTokenBucket_Acquire(b, bucket)
+}
+
+func BenchmarkKeyedLimiter_Acquire(b *testing.B) {
+	k := NewKeyedLimiter(TokenBucketFactory(10, 10), WithMaxKeys(1000), WithIdleTimeout(time.Minute))
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			k.Acquire(randWord())
+		}
+	})
+}
+
 func benchmarkTokenBucket_Acquire(b *testing.B, bucket Interface) {
 	capacity := bucket.Capacity()
 	var acquiredCount int64
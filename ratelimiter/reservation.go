@@ -0,0 +1,87 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	gtime "github.com/chanjarster/gears/util/time"
+	"sync"
+	"time"
+)
+
+// Reservation holds permissions reserved in advance, the permissions are consumed at the moment of reserving,
+// caller should wait Delay() before proceeding, or Cancel() to give them back.
+type Reservation interface {
+	// OK whether permissions are reserved. If false, Delay() is meaningless and Cancel() does nothing.
+	OK() bool
+
+	// Delay how long the caller should wait before proceeding, 0 means proceeding immediately.
+	Delay() time.Duration
+
+	// Cancel refund reserved permissions to the rate limiter, it's safe to be called multiple times.
+	// Permissions are not refunded once Delay() has elapsed, because they are considered spent.
+	Cancel()
+}
+
+// a Reservation that is not OK
+var notOkReservation Reservation = &reservation{}
+
+func newReservation(n int, timeToAct int64, nowFn gtime.NowFunc, refund func(n int)) Reservation {
+	return &reservation{
+		ok:        true,
+		n:         n,
+		timeToAct: timeToAct,
+		nowFn:     nowFn,
+		refund:    refund,
+	}
+}
+
+type reservation struct {
+	ok        bool
+	n         int   // reserved permissions
+	timeToAct int64 // when permissions are available
+	nowFn     gtime.NowFunc
+	refund    func(n int) // give back permissions
+	cancel    sync.Once
+}
+
+func (r *reservation) OK() bool {
+	return r.ok
+}
+
+func (r *reservation) Delay() time.Duration {
+	if !r.ok {
+		return 0
+	}
+	delay := r.timeToAct - r.nowFn().UnixNano()
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+func (r *reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.cancel.Do(func() {
+		if r.nowFn().UnixNano() > r.timeToAct {
+			return
+		}
+		r.refund(r.n)
+	})
+}
@@ -19,6 +19,7 @@ package ratelimiter
 
 import (
 	"container/list"
+	"context"
 	gtime "github.com/chanjarster/gears/util/time"
 	"sync"
 	"time"
@@ -31,6 +32,7 @@ import (
 // 举个具体的例子，当前时间的往前 1分钟 内，如果请求次数超过了 100次 那么就拒绝请求，这样就限定了请求速率恒定在 100次/分钟
 type SlidingWindow interface {
 	Interface
+	Waitable
 	ConfigurableWindow
 	WindowSize() time.Duration // time range the window look back
 }
@@ -57,33 +59,59 @@ type SyncSlidingWindow struct {
 	windowSize int64
 	records    *list.List // 请求记录，其实就是时间戳
 	nowFn      gtime.NowFunc
+	observable
 }
 
 func (s *SyncSlidingWindow) Acquire() bool {
+	return s.AcquireN(1)
+}
+
+func (s *SyncSlidingWindow) AcquireN(n int) bool {
+	acquired, _, _ := s.tryAcquireN(n)
+	return s.observe(n, acquired)
+}
+
+func (s *SyncSlidingWindow) Wait(ctx context.Context) error {
+	return s.WaitN(ctx, 1)
+}
+
+func (s *SyncSlidingWindow) WaitN(ctx context.Context, n int) error {
+	return s.observeWait(n, waitN(ctx, n, s.tryAcquireN))
+}
+
+func (s *SyncSlidingWindow) tryAcquireN(n int) (bool, time.Duration, error) {
 	now := s.nowFn().UnixNano()
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if s.capacity == 0 {
-		return true
+	if s.capacity == 0 || n <= 0 {
+		return true, 0, nil
+	}
+	if n > s.capacity {
+		return false, 0, ErrExceedCapacity
+	}
+
+	// 移除超过windowSize的请求记录
+	for s.records.Len() > 0 && now-s.records.Front().Value.(int64) > s.windowSize {
+		s.records.Remove(s.records.Front())
 	}
 
-	if s.records.Len() < s.capacity {
+	lack := s.records.Len() + n - s.capacity
+	if lack <= 0 {
 		// 未到容量，可以通过
-		s.records.PushBack(now)
-		return true
+		for i := 0; i < n; i++ {
+			s.records.PushBack(now)
+		}
+		return true, 0, nil
 	}
 
-	oldestV := s.records.Front()
-	oldest := time.Duration(oldestV.Value.(int64))
-	if now-int64(oldest) > s.windowSize {
-		// 容量超过，且最远的一次请求超过了windowSize，可以通过
-		s.records.Remove(oldestV)
-		s.records.PushBack(now)
-		return true
+	// 容量超过，要等到第lack个请求记录超过windowSize
+	e := s.records.Front()
+	for i := 1; i < lack; i++ {
+		e = e.Next()
 	}
-	return false
+	return false, time.Duration(e.Value.(int64) + s.windowSize - now + 1), nil
 }
 
 func (s *SyncSlidingWindow) Capacity() int {
@@ -92,6 +120,41 @@ func (s *SyncSlidingWindow) Capacity() int {
 	return s.capacity
 }
 
+// Usage ratio of requests in the window
+func (s *SyncSlidingWindow) Usage() float64 {
+	now := s.nowFn().UnixNano()
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	count := 0
+	for e := s.records.Back(); e != nil && now-e.Value.(int64) <= s.windowSize; e = e.Prev() {
+		count++
+	}
+	return usageRatio(float64(count), float64(s.capacity))
+}
+
+// Quota remaining requests in the window, and how long until the latest request slides out of the window
+func (s *SyncSlidingWindow) Quota() (int, time.Duration) {
+	now := s.nowFn().UnixNano()
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	count := 0
+	for e := s.records.Back(); e != nil && now-e.Value.(int64) <= s.windowSize; e = e.Prev() {
+		count++
+	}
+	remaining := s.capacity - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	if count == 0 {
+		return remaining, 0
+	}
+	return remaining, time.Duration(s.records.Back().Value.(int64) + s.windowSize - now + 1)
+}
+
 func (s *SyncSlidingWindow) WindowSize() time.Duration {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -0,0 +1,221 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	gtime "github.com/chanjarster/gears/util/time"
+	"math"
+	"sync"
+	"time"
+)
+
+// NewSyncSlidingWindowCounter New a SyncSlidingWindowCounter.
+//
+//	capacity: window capacity
+//	windowSize: time range the window look back, <= 0 means no limit
+func NewSyncSlidingWindowCounter(capacity int, windowSize time.Duration) *SyncSlidingWindowCounter {
+	if capacity < 0 {
+		capacity = 0
+	}
+	if windowSize < 0 {
+		windowSize = 0
+	}
+	return &SyncSlidingWindowCounter{
+		capacity:   capacity,
+		windowSize: int64(windowSize),
+		nowFn:      gtime.SysNow,
+	}
+}
+
+// SyncSlidingWindowCounter 近似的滑动时间窗口限流器，只需要两个计数器，内存占用恒定
+//
+// 和 SyncFixedWindow 一样将时间按照 windowSize 分割成一个一个interval，记录当前interval和上一个interval的请求数量，
+// 假设上一个interval的请求是均匀分布的，那么往前 windowSize 范围内的请求数量估算为:
+//
+//	上一个interval的请求数量 * (1 - 当前interval已经过去的时间 / windowSize) + 当前interval的请求数量
+//
+// 如果估算值超过 capacity 那么就拒绝请求。
+//
+// 和 SyncSlidingWindow 相比，它不记录每次请求的时间戳，所以适合 capacity 很大的场景。
+type SyncSlidingWindowCounter struct {
+	lock       sync.RWMutex
+	capacity   int
+	windowSize int64 // 0 表示不限流
+	counter    windowCounter
+	nowFn      gtime.NowFunc
+	observable
+}
+
+func (s *SyncSlidingWindowCounter) Acquire() bool {
+	return s.AcquireN(1)
+}
+
+func (s *SyncSlidingWindowCounter) AcquireN(n int) bool {
+	acquired, _, _ := s.tryAcquireN(n)
+	return s.observe(n, acquired)
+}
+
+func (s *SyncSlidingWindowCounter) Wait(ctx context.Context) error {
+	return s.WaitN(ctx, 1)
+}
+
+func (s *SyncSlidingWindowCounter) WaitN(ctx context.Context, n int) error {
+	return s.observeWait(n, waitN(ctx, n, s.tryAcquireN))
+}
+
+func (s *SyncSlidingWindowCounter) tryAcquireN(n int) (bool, time.Duration, error) {
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.capacity == 0 || s.windowSize == 0 || n <= 0 {
+		return true, 0, nil
+	}
+	if n > s.capacity {
+		return false, 0, ErrExceedCapacity
+	}
+
+	s.counter.slide(now, s.windowSize)
+	if delay := s.counter.delay(now, s.capacity, s.windowSize, n); delay > 0 {
+		return false, time.Duration(delay), nil
+	}
+	s.counter.currCount += n
+	return true, 0, nil
+}
+
+func (s *SyncSlidingWindowCounter) Capacity() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.capacity
+}
+
+// Usage ratio of estimated requests in the window
+func (s *SyncSlidingWindowCounter) Usage() float64 {
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.windowSize == 0 {
+		return 0
+	}
+	s.counter.slide(now, s.windowSize)
+	return usageRatio(s.counter.estimate(now, s.windowSize), float64(s.capacity))
+}
+
+// Quota remaining requests by estimation, and how long until the estimation drops to 0
+func (s *SyncSlidingWindowCounter) Quota() (int, time.Duration) {
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.windowSize == 0 {
+		return s.capacity, 0
+	}
+	s.counter.slide(now, s.windowSize)
+	remaining := int(math.Max(math.Floor(float64(s.capacity)-s.counter.estimate(now, s.windowSize)), 0))
+	switch {
+	case s.counter.currCount > 0:
+		return remaining, time.Duration(s.counter.currStart + 2*s.windowSize - now)
+	case s.counter.prevCount > 0:
+		return remaining, time.Duration(s.counter.currStart + s.windowSize - now)
+	default:
+		return remaining, 0
+	}
+}
+
+func (s *SyncSlidingWindowCounter) WindowSize() time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return time.Duration(s.windowSize)
+}
+
+// UpdateConfig windowSize <= 0 means no limit
+func (s *SyncSlidingWindowCounter) UpdateConfig(capacity int, windowSize time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if capacity < 0 {
+		capacity = 0
+	}
+	if windowSize < 0 {
+		windowSize = 0
+	}
+	s.capacity = capacity
+	if s.windowSize == int64(windowSize) {
+		return
+	}
+	s.windowSize = int64(windowSize)
+	if s.windowSize == 0 {
+		s.counter = windowCounter{}
+		return
+	}
+	// 按照新的windowSize对齐当前interval，保留计数
+	s.counter.currStart -= s.counter.currStart % s.windowSize
+}
+
+// 滑动窗口计数器的状态
+type windowCounter struct {
+	currStart int64 // 当前interval的开始时间
+	currCount int   // 当前interval的请求数量
+	prevCount int   // 上一个interval的请求数量
+}
+
+// 把当前interval滑动到包含now的interval
+func (w *windowCounter) slide(now, windowSize int64) {
+	start := now - now%windowSize
+	if start <= w.currStart {
+		return
+	}
+	if start-w.currStart == windowSize {
+		w.prevCount = w.currCount
+	} else {
+		w.prevCount = 0
+	}
+	w.currCount = 0
+	w.currStart = start
+}
+
+// 往前 windowSize 范围内的请求数量估算值，调用前需先slide
+func (w *windowCounter) estimate(now, windowSize int64) float64 {
+	prevWeight := 1 - float64(now-w.currStart)/float64(windowSize)
+	return float64(w.prevCount)*prevWeight + float64(w.currCount)
+}
+
+// 再放行n个请求需要等待的时间(ns)，0表示不需要等待，调用前需先slide，并且保证 n <= capacity
+func (w *windowCounter) delay(now int64, capacity int, windowSize int64, n int) int64 {
+	elapse := now - w.currStart
+	// 当前interval还能容纳的，上一个interval的请求数量
+	room := float64(capacity - w.currCount - n)
+	if room >= 0 {
+		prevWeight := 1 - float64(elapse)/float64(windowSize)
+		if float64(w.prevCount)*prevWeight <= room {
+			return 0
+		}
+		// 等到上一个interval的请求权重降下来
+		waitUntil := w.currStart + int64(math.Ceil(float64(windowSize)*(1-room/float64(w.prevCount))))
+		return waitUntil - now + 1
+	}
+
+	// 当前interval容量不够，等到下一个interval，当前interval的请求权重降下来
+	room = float64(capacity - n)
+	waitUntil := w.currStart + windowSize + int64(math.Ceil(float64(windowSize)*(1-room/float64(w.currCount))))
+	return waitUntil - now + 1
+}
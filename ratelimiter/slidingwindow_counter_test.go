@@ -0,0 +1,183 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewSyncSlidingWindowCounter(t *testing.T) {
+	cap := 500
+	windowSize := 500 * time.Millisecond
+
+	got := NewSyncSlidingWindowCounter(cap, windowSize)
+
+	if got, want := got.WindowSize(), windowSize; got != want {
+		t.Errorf("NewSyncSlidingWindowCounter().WindowSize() = %v, want %v", got, want)
+	}
+	if got, want := got.Capacity(), cap; got != want {
+		t.Errorf("NewSyncSlidingWindowCounter().Capacity() = %v, want %v", got, want)
+	}
+	if got := got.nowFn; got == nil {
+		t.Errorf("NewSyncSlidingWindowCounter().nowFn is nil, want not nil")
+	}
+}
+
+func TestSyncSlidingWindowCounter_Acquire(t *testing.T) {
+
+	var (
+		cap        = 10
+		windowSize = 100 * time.Millisecond
+	)
+
+	// 时钟对齐到interval的开始
+	new := func() (*SyncSlidingWindowCounter, *time.Time) {
+		clock := time.Unix(0, time.Now().UnixNano()/int64(windowSize)*int64(windowSize))
+		s := NewSyncSlidingWindowCounter(cap, windowSize)
+		s.nowFn = func() time.Time {
+			return clock
+		}
+		return s, &clock
+	}
+
+	t.Run("acquire 11 times", func(t *testing.T) {
+		ratelimiter, _ := new()
+		for i := 0; i < ratelimiter.Capacity(); i++ {
+			if got := ratelimiter.Acquire(); !got {
+				t.Errorf("acquire() = %v, want %v", got, true)
+			}
+		}
+		if got := ratelimiter.Acquire(); got {
+			t.Errorf("acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("previous interval is weighted", func(t *testing.T) {
+		ratelimiter, clock := new()
+		ratelimiter.AcquireN(10)
+
+		// 下一个interval过去了30%，上一个interval的权重是70%，估算为7个请求
+		*clock = clock.Add(130 * time.Millisecond)
+		if got := ratelimiter.AcquireN(4); got {
+			t.Errorf("AcquireN(4) = %v, want %v", got, false)
+		}
+		if got := ratelimiter.AcquireN(3); !got {
+			t.Errorf("AcquireN(3) = %v, want %v", got, true)
+		}
+
+		// 过去了80%，估算为2+3个请求
+		*clock = clock.Add(50 * time.Millisecond)
+		if got := ratelimiter.AcquireN(6); got {
+			t.Errorf("AcquireN(6) = %v, want %v", got, false)
+		}
+		if got := ratelimiter.AcquireN(5); !got {
+			t.Errorf("AcquireN(5) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("previous interval expired", func(t *testing.T) {
+		ratelimiter, clock := new()
+		ratelimiter.AcquireN(10)
+		*clock = clock.Add(210 * time.Millisecond)
+		if got := ratelimiter.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("tryAcquireN delay", func(t *testing.T) {
+		ratelimiter, clock := new()
+		ratelimiter.AcquireN(10)
+		// 下一个interval过去了60%才能再接受6个请求
+		if _, got, _ := ratelimiter.tryAcquireN(6); got != 160*time.Millisecond+1 {
+			t.Errorf("tryAcquireN(6) delay = %v, want %v", got, 160*time.Millisecond+1)
+		}
+		*clock = clock.Add(120 * time.Millisecond)
+		if _, got, _ := ratelimiter.tryAcquireN(6); got != 40*time.Millisecond+1 {
+			t.Errorf("tryAcquireN(6) delay = %v, want %v", got, 40*time.Millisecond+1)
+		}
+		if _, _, got := ratelimiter.tryAcquireN(11); got != ErrExceedCapacity {
+			t.Errorf("tryAcquireN(11) error = %v, want %v", got, ErrExceedCapacity)
+		}
+	})
+
+	t.Run("update config", func(t *testing.T) {
+		ratelimiter, _ := new()
+		ratelimiter.AcquireN(10)
+		ratelimiter.UpdateConfig(cap+1, windowSize)
+		if got := ratelimiter.Acquire(); !got {
+			t.Errorf("acquire() = %v, want %v", got, true)
+		}
+		ratelimiter.UpdateConfig(cap, windowSize*2)
+		if got := ratelimiter.Acquire(); got {
+			t.Errorf("acquire() = %v, want %v", got, false)
+		}
+		ratelimiter.UpdateConfig(0, windowSize)
+		if got := ratelimiter.AcquireN(100); !got {
+			t.Errorf("AcquireN(100) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("non-positive window size", func(t *testing.T) {
+		for _, windowSize := range []time.Duration{0, -time.Second} {
+			ratelimiter := NewSyncSlidingWindowCounter(cap, windowSize)
+			if got := ratelimiter.WindowSize(); got != 0 {
+				t.Errorf("WindowSize() = %v, want %v", got, 0)
+			}
+			if got := ratelimiter.AcquireN(cap); !got {
+				t.Errorf("AcquireN(%v) = %v, want %v", cap, got, true)
+			}
+			if got := ratelimiter.Usage(); got != 0 {
+				t.Errorf("Usage() = %v, want %v", got, 0)
+			}
+		}
+
+		ratelimiter, _ := new()
+		ratelimiter.AcquireN(10)
+		ratelimiter.UpdateConfig(cap, 0)
+		if got := ratelimiter.Acquire(); !got {
+			t.Errorf("acquire() = %v, want %v", got, true)
+		}
+		ratelimiter.UpdateConfig(cap, -windowSize)
+		if got := ratelimiter.WindowSize(); got != 0 {
+			t.Errorf("WindowSize() = %v, want %v", got, 0)
+		}
+		ratelimiter.UpdateConfig(cap, windowSize)
+		if got := ratelimiter.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+		if got := ratelimiter.Acquire(); got {
+			t.Errorf("acquire() = %v, want %v", got, false)
+		}
+	})
+}
+
+func TestSyncSlidingWindowCounter_WaitN(t *testing.T) {
+	ratelimiter := NewSyncSlidingWindowCounter(10, 50*time.Millisecond)
+	ratelimiter.AcquireN(10)
+	if got := ratelimiter.WaitN(context.Background(), 5); got != nil {
+		t.Errorf("WaitN(5) = %v, want %v", got, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ratelimiter.AcquireN(5)
+	if got := ratelimiter.WaitN(ctx, 10); got != context.DeadlineExceeded {
+		t.Errorf("WaitN(10) = %v, want %v", got, context.DeadlineExceeded)
+	}
+}
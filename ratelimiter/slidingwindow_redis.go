@@ -0,0 +1,193 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"github.com/chanjarster/gears/simplelog"
+	gtime "github.com/chanjarster/gears/util/time"
+	"github.com/go-redis/redis/v7"
+	"sync"
+	"time"
+)
+
+const (
+	slidingWindowPrefix = "_rl:sw:"
+
+	// same algorithm as SyncSlidingWindow, request records are stored in a sorted set,
+	// score is the timestamp, member is `nonce:i`.
+	//
+	// return acquired, delay(microseconds)
+	slidingWindowScript = `local key = KEYS[1]
+local cap = tonumber(ARGV[1])
+local win = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local nonce = ARGV[5]
+
+if cap <= 0
+then
+  return {1, 0}
+end
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', '(' .. string.format('%.0f', now - win))
+
+local size = redis.call('ZCARD', key)
+local lack = size + n - cap
+if lack > 0
+then
+  local oldest = redis.call('ZRANGE', key, lack - 1, lack - 1, 'WITHSCORES')
+  return {0, tonumber(oldest[2]) + win - now + 1}
+end
+
+for i = 1, n
+do
+  redis.call('ZADD', key, ARGV[3], nonce .. ':' .. i)
+end
+redis.call('PEXPIRE', key, math.ceil(win / 1000) + 1000)
+return {1, 0}
+`
+)
+
+var (
+	slidingWindowScriptSha = ""
+)
+
+// NewRedisSlidingWindow New a SlidingWindow backed by redis, all instances of same key share the same window.
+//
+// Call LoadScript before using it.
+//
+//	key: window's name
+//	capacity: window capacity
+//	windowSize: time range the window look back
+func NewRedisSlidingWindow(redisClient *redis.Client, key string, capacity int, windowSize time.Duration) SlidingWindow {
+	return newRedisSlidingWindow(redisClient, slidingWindowPrefix+key, capacity, windowSize)
+}
+
+// NewRedisSlidingWindowCluster New a redis SlidingWindow for Redis Cluster environment.
+//
+//	hashTag: redis hash tag value, helps to ensure all keys be in the same slot.
+//
+// see: https://redis.io/topics/cluster-tutorial#redis-cluster-data-sharding
+func NewRedisSlidingWindowCluster(redisClient *redis.Client, key string, capacity int, windowSize time.Duration, hashTag string) SlidingWindow {
+	return newRedisSlidingWindow(redisClient, slidingWindowPrefix+key+formatHashTag(hashTag), capacity, windowSize)
+}
+
+func newRedisSlidingWindow(redisClient *redis.Client, key string, capacity int, windowSize time.Duration) *redisSlidingWindow {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &redisSlidingWindow{
+		redisClient: redisClient,
+		key:         key,
+		capacity:    capacity,
+		windowSize:  int64(windowSize),
+		nowFn:       gtime.SysNow,
+	}
+}
+
+type redisSlidingWindow struct {
+	lock        sync.RWMutex
+	redisClient *redis.Client
+	key         string
+	capacity    int
+	windowSize  int64
+	nowFn       gtime.NowFunc
+	observable
+}
+
+func (r *redisSlidingWindow) Capacity() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.capacity
+}
+
+func (r *redisSlidingWindow) WindowSize() time.Duration {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return time.Duration(r.windowSize)
+}
+
+func (r *redisSlidingWindow) UpdateConfig(capacity int, windowSize time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if capacity < 0 {
+		capacity = 0
+	}
+	r.capacity = capacity
+	r.windowSize = int64(windowSize)
+}
+
+func (r *redisSlidingWindow) Acquire() bool {
+	return r.AcquireN(1)
+}
+
+func (r *redisSlidingWindow) AcquireN(n int) bool {
+	acquired, _, err := r.tryAcquireN(context.Background(), n)
+	if err != nil && err != ErrExceedCapacity {
+		simplelog.ErrLogger.Println("eval slidingWindowScript ", slidingWindowScriptSha, "error", err)
+		return r.observe(n, true)
+	}
+	return r.observe(n, acquired)
+}
+
+func (r *redisSlidingWindow) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+func (r *redisSlidingWindow) WaitN(ctx context.Context, n int) error {
+	err := waitN(ctx, n, func(n int) (bool, time.Duration, error) {
+		return r.tryAcquireN(ctx, n)
+	})
+	return r.observeWait(n, err)
+}
+
+func (r *redisSlidingWindow) tryAcquireN(ctx context.Context, n int) (bool, time.Duration, error) {
+	r.lock.RLock()
+	capacity, windowSize := r.capacity, r.windowSize
+	r.lock.RUnlock()
+
+	if capacity == 0 || n <= 0 {
+		return true, 0, nil
+	}
+	if n > capacity {
+		return false, 0, ErrExceedCapacity
+	}
+
+	//local cap = tonumber(ARGV[1])
+	//local win = tonumber(ARGV[2])
+	//local now = tonumber(ARGV[3])
+	//local n = tonumber(ARGV[4])
+	//local nonce = ARGV[5]
+	return evalAcquireScript(ctx, r.redisClient, slidingWindowScriptSha, r.key,
+		capacity,
+		windowSize/int64(time.Microsecond),
+		r.nowFn().UnixNano()/int64(time.Microsecond),
+		n,
+		randomNonce(),
+	)
+}
+
+// random string to make sorted set members unique among instances
+func randomNonce() string {
+	b := make([]byte, 8)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,100 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"github.com/chanjarster/gears/confs"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_redisSlidingWindow(t *testing.T) {
+
+	val, hit := os.LookupEnv("INTEGRATION_TEST")
+	if !hit || val != "true" {
+		t.Skip("skip integration test")
+	}
+
+	redisClient := confs.NewRedisClient(&confs.RedisConf{
+		Host:     "localhost",
+		Port:     6379,
+		Password: "",
+		Pool:     10,
+		MinIdle:  1,
+	}, nil)
+	redisClient.FlushAll()
+	defer redisClient.Close()
+
+	LoadScript(redisClient)
+
+	t.Run("acquire", func(t *testing.T) {
+		ratelimiter := NewRedisSlidingWindow(redisClient, "acquire", 10, 100*time.Millisecond)
+		ratelimiter2 := NewRedisSlidingWindow(redisClient, "acquire", 10, 100*time.Millisecond)
+		if got := ratelimiter.AcquireN(6); !got {
+			t.Errorf("AcquireN(6) = %v, want %v", got, true)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if got := ratelimiter2.AcquireN(5); got {
+			t.Errorf("AcquireN(5) = %v, want %v", got, false)
+		}
+		if got := ratelimiter2.AcquireN(4); !got {
+			t.Errorf("AcquireN(4) = %v, want %v", got, true)
+		}
+		// 前6个请求记录过期了
+		time.Sleep(70 * time.Millisecond)
+		if got := ratelimiter.AcquireN(7); got {
+			t.Errorf("AcquireN(7) = %v, want %v", got, false)
+		}
+		if got := ratelimiter.AcquireN(6); !got {
+			t.Errorf("AcquireN(6) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("update config", func(t *testing.T) {
+		ratelimiter := NewRedisSlidingWindow(redisClient, "update", 10, 100*time.Millisecond)
+		ratelimiter.AcquireN(10)
+		if got := ratelimiter.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		ratelimiter.UpdateConfig(11, 100*time.Millisecond)
+		if got := ratelimiter.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+		ratelimiter.UpdateConfig(0, 100*time.Millisecond)
+		if got := ratelimiter.AcquireN(100); !got {
+			t.Errorf("AcquireN(100) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		ratelimiter := NewRedisSlidingWindow(redisClient, "wait", 10, 100*time.Millisecond)
+		ratelimiter.AcquireN(10)
+		start := time.Now()
+		if got := ratelimiter.WaitN(context.Background(), 5); got != nil {
+			t.Errorf("WaitN(5) = %v, want %v", got, nil)
+		}
+		if elapse := time.Since(start); elapse < 90*time.Millisecond {
+			t.Errorf("WaitN(5) elapse = %v, want about 100ms", elapse)
+		}
+		if got := ratelimiter.WaitN(context.Background(), 11); got != ErrExceedCapacity {
+			t.Errorf("WaitN(11) = %v, want %v", got, ErrExceedCapacity)
+		}
+	})
+
+}
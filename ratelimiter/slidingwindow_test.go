@@ -19,6 +19,7 @@ package ratelimiter
 
 import (
 	"container/list"
+	"context"
 	gtime "github.com/chanjarster/gears/util/time"
 	"reflect"
 	"testing"
@@ -189,3 +190,88 @@ func TestSyncSlidingWindow_Acquire(t *testing.T) {
 		}
 	})
 }
+
+func TestSyncSlidingWindow_AcquireN(t *testing.T) {
+	now := time.Now()
+	ratelimiter := NewSyncSlidingWindow(10, 50*time.Millisecond)
+	ratelimiter.nowFn = gtime.FixedNow(now)
+
+	if got := ratelimiter.AcquireN(11); got {
+		t.Errorf("AcquireN(11) = %v, want %v", got, false)
+	}
+	if got := ratelimiter.AcquireN(6); !got {
+		t.Errorf("AcquireN(6) = %v, want %v", got, true)
+	}
+
+	ratelimiter.nowFn = gtime.FixedNow(now.Add(30 * time.Millisecond))
+	if got := ratelimiter.AcquireN(5); got {
+		t.Errorf("AcquireN(5) = %v, want %v", got, false)
+	}
+	if got := ratelimiter.AcquireN(4); !got {
+		t.Errorf("AcquireN(4) = %v, want %v", got, true)
+	}
+
+	// 前6个请求记录过期了
+	ratelimiter.nowFn = gtime.FixedNow(now.Add(60 * time.Millisecond))
+	if got := ratelimiter.AcquireN(7); got {
+		t.Errorf("AcquireN(7) = %v, want %v", got, false)
+	}
+	if got := ratelimiter.AcquireN(6); !got {
+		t.Errorf("AcquireN(6) = %v, want %v", got, true)
+	}
+}
+
+func TestSyncSlidingWindow_tryAcquireN(t *testing.T) {
+	now := time.Now()
+	ratelimiter := NewSyncSlidingWindow(10, 50*time.Millisecond)
+	ratelimiter.nowFn = gtime.FixedNow(now)
+	ratelimiter.AcquireN(6)
+	ratelimiter.nowFn = gtime.FixedNow(now.Add(30 * time.Millisecond))
+	ratelimiter.AcquireN(4)
+
+	if _, got, _ := ratelimiter.tryAcquireN(6); got != 20*time.Millisecond+1 {
+		t.Errorf("tryAcquireN(6) delay = %v, want %v", got, 20*time.Millisecond+1)
+	}
+	if _, got, _ := ratelimiter.tryAcquireN(7); got != 50*time.Millisecond+1 {
+		t.Errorf("tryAcquireN(7) delay = %v, want %v", got, 50*time.Millisecond+1)
+	}
+	if _, _, got := ratelimiter.tryAcquireN(11); got != ErrExceedCapacity {
+		t.Errorf("tryAcquireN(11) error = %v, want %v", got, ErrExceedCapacity)
+	}
+}
+
+func TestSyncSlidingWindow_WaitN(t *testing.T) {
+	new := func() *SyncSlidingWindow {
+		return NewSyncSlidingWindow(10, 50*time.Millisecond)
+	}
+
+	t.Run("wait more than capacity", func(t *testing.T) {
+		ratelimiter := new()
+		if got := ratelimiter.WaitN(context.Background(), 11); got != ErrExceedCapacity {
+			t.Errorf("WaitN(11) = %v, want %v", got, ErrExceedCapacity)
+		}
+	})
+
+	t.Run("wait until oldest expired", func(t *testing.T) {
+		ratelimiter := new()
+		ratelimiter.AcquireN(10)
+		start := time.Now()
+		if got := ratelimiter.WaitN(context.Background(), 5); got != nil {
+			t.Errorf("WaitN(5) = %v, want %v", got, nil)
+		}
+		if elapse := time.Since(start); elapse < 40*time.Millisecond {
+			t.Errorf("WaitN(5) elapse = %v, want about 50ms", elapse)
+		}
+	})
+
+	t.Run("wait canceled", func(t *testing.T) {
+		ratelimiter := new()
+		ratelimiter.nowFn = gtime.FixedNow(time.Now())
+		ratelimiter.AcquireN(10)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if got := ratelimiter.Wait(ctx); got != context.DeadlineExceeded {
+			t.Errorf("Wait() = %v, want %v", got, context.DeadlineExceeded)
+		}
+	})
+}
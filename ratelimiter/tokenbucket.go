@@ -14,28 +14,53 @@
  * See the License for the specific language governing permissions and
  * limitations under the License.
  */
-
 package ratelimiter
 
 import (
+	"context"
 	gtime "github.com/chanjarster/gears/util/time"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// TokenBucket issues tokens continuously with precision of nanosecond,
+// i.e, a bucket with rate 1000/s issues 1 token every 1ms, rather than 1000 tokens every 1s.
 type TokenBucket interface {
 	Interface
+	Waitable
+
+	// Reserve n tokens in advance, tokens are taken immediately even if they are not issued yet,
+	// Reservation.Delay() tells when they will be issued.
+	// Reservation.OK() is false if n > Capacity() or tokens will never be issued.
+	Reserve(n int) Reservation
 }
 
 // New a SyncTokenBucket
 //  capacity: token bucket's capacity
 //  issueRatePerSecond: token issuing rate(per second)
 func NewSyncTokenBucket(capacity, issueRatePerSecond int) TokenBucket {
+	return NewSyncTokenBucketRate(capacity, float64(issueRatePerSecond))
+}
+
+// NewSyncTokenBucketRate New a SyncTokenBucket with fractional rate, e.g. 0.5 means 1 token every 2 seconds.
+//
+//	capacity: token bucket's capacity
+//	issueRatePerSecond: token issuing rate(per second), <= 0 means never issue
+func NewSyncTokenBucketRate(capacity int, issueRatePerSecond float64) TokenBucket {
+	return NewSyncTokenBucketInterval(capacity, rateToInterval(issueRatePerSecond))
+}
+
+// NewSyncTokenBucketInterval New a SyncTokenBucket that issue 1 token every issueInterval.
+//
+//	capacity: token bucket's capacity
+//	issueInterval: time to issue 1 token, <= 0 means never issue
+func NewSyncTokenBucketInterval(capacity int, issueInterval time.Duration) TokenBucket {
 	return &SyncTokenBucket{
 		capacity:           capacity,
-		tokens:             capacity,
-		issueRatePerSecond: issueRatePerSecond,
+		tokens:             float64(capacity),
+		issueInterval:      int64(issueInterval),
 		lastIssueTimestamp: gtime.SysNow().UnixNano(),
 		nowFn:              gtime.SysNow,
 	}
@@ -45,133 +70,318 @@ func NewSyncTokenBucket(capacity, issueRatePerSecond int) TokenBucket {
 //  capacity: token bucket's capacity
 //  issueRatePerSecond: token issuing rate(per second)
 func NewAtomicTokenBucket(capacity, issueRatePerSecond int) TokenBucket {
-	return &AtomicTokenBucket{
-		capacity:           capacity,
-		tokens:             int64(capacity),
-		issueRatePerSecond: issueRatePerSecond,
-		lastIssueTimestamp: gtime.SysNow().UnixNano(),
-		nowFn:              gtime.SysNow,
+	return NewAtomicTokenBucketRate(capacity, float64(issueRatePerSecond))
+}
+
+// NewAtomicTokenBucketRate New a AtomicTokenBucket with fractional rate, e.g. 0.5 means 1 token every 2 seconds.
+//
+//	capacity: token bucket's capacity
+//	issueRatePerSecond: token issuing rate(per second), <= 0 means never issue
+func NewAtomicTokenBucketRate(capacity int, issueRatePerSecond float64) TokenBucket {
+	return NewAtomicTokenBucketInterval(capacity, rateToInterval(issueRatePerSecond))
+}
+
+// NewAtomicTokenBucketInterval New a AtomicTokenBucket that issue 1 token every issueInterval.
+//
+//	capacity: token bucket's capacity
+//	issueInterval: time to issue 1 token, <= 0 means never issue
+func NewAtomicTokenBucketInterval(capacity int, issueInterval time.Duration) TokenBucket {
+	t := &AtomicTokenBucket{
+		capacity:      capacity,
+		issueInterval: int64(issueInterval),
+		nowFn:         gtime.SysNow,
 	}
+	now, interval := t.clock()
+	t.emptyTimestamp = now - int64(capacity)*interval
+	return t
 }
 
 // TokenBucket implementation using "sync.Mutex"
 type SyncTokenBucket struct {
 	lock               sync.Mutex
-	capacity           int   // bucket capacity
-	tokens             int   // currently issued tokens amount
-	issueRatePerSecond int   // token issuing rate(per second)
-	lastIssueTimestamp int64 // last time of issuing tokens
+	capacity           int     // bucket capacity
+	tokens             float64 // currently issued tokens amount, fractional, negative if reserved in advance
+	issueInterval      int64   // nanoseconds to issue 1 token, <= 0 means never issue
+	lastIssueTimestamp int64   // last time of issuing tokens
 	nowFn              gtime.NowFunc
+	observable
 }
 
 func (t *SyncTokenBucket) Capacity() int {
 	return t.capacity
 }
 
+// Usage ratio of taken tokens
+func (t *SyncTokenBucket) Usage() float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.issueIfNecessary()
+	return usageRatio(float64(t.capacity)-t.tokens, float64(t.capacity))
+}
+
+// Quota available tokens, and how long until the bucket is full
+func (t *SyncTokenBucket) Quota() (int, time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.issueIfNecessary()
+	remaining := int(math.Max(math.Floor(t.tokens), 0))
+	if t.issueInterval <= 0 {
+		return remaining, 0
+	}
+	return remaining, time.Duration(math.Ceil((float64(t.capacity) - t.tokens) * float64(t.issueInterval)))
+}
+
 func (t *SyncTokenBucket) Acquire() bool {
+	return t.AcquireN(1)
+}
+
+func (t *SyncTokenBucket) AcquireN(n int) bool {
+	acquired, _, _ := t.tryAcquireN(n)
+	return t.observe(n, acquired)
+}
+
+func (t *SyncTokenBucket) Wait(ctx context.Context) error {
+	return t.WaitN(ctx, 1)
+}
+
+func (t *SyncTokenBucket) WaitN(ctx context.Context, n int) error {
+	return t.observeWait(n, waitN(ctx, n, t.tryAcquireN))
+}
+
+func (t *SyncTokenBucket) tryAcquireN(n int) (bool, time.Duration, error) {
+	if n <= 0 {
+		return true, 0, nil
+	}
+	if n > t.capacity {
+		return false, 0, ErrExceedCapacity
+	}
+
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
 	t.issueIfNecessary()
 
-	if t.tokens > 0 {
-		t.tokens--
-		return true
+	if t.tokens >= float64(n) {
+		t.tokens -= float64(n)
+		return true, 0, nil
 	}
-	return false
+
+	return false, tokenIssueDelay(float64(n)-t.tokens, t.issueInterval), nil
 
 }
 
-func (t *SyncTokenBucket) issueIfNecessary() {
-	if t.tokens >= t.capacity {
-		return
+func (t *SyncTokenBucket) Reserve(n int) Reservation {
+	rsv := t.reserve(n)
+	t.observe(n, rsv.OK())
+	return rsv
+}
+
+func (t *SyncTokenBucket) reserve(n int) Reservation {
+	if n <= 0 || n > t.capacity {
+		return notOkReservation
 	}
-	now := t.nowFn().UnixNano()
 
-	elapse := now - t.lastIssueTimestamp
-	delta := elapse / int64(time.Second) * int64(t.issueRatePerSecond)
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
-	if delta == 0 {
-		return
+	t.issueIfNecessary()
+
+	now := t.lastIssueTimestamp
+	if t.tokens >= float64(n) {
+		t.tokens -= float64(n)
+		return newReservation(n, now, t.nowFn, t.refund)
 	}
 
-	t.tokens += int(delta)
-	if t.tokens > t.capacity {
-		t.tokens = t.capacity
+	delay := tokenIssueDelay(float64(n)-t.tokens, t.issueInterval)
+	if delay == delayForever {
+		return notOkReservation
+	}
+	// tokens become negative, which will be paid back by later issuing
+	t.tokens -= float64(n)
+	return newReservation(n, now+int64(delay), t.nowFn, t.refund)
+}
+
+func (t *SyncTokenBucket) refund(n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.tokens += float64(n)
+	if t.tokens > float64(t.capacity) {
+		t.tokens = float64(t.capacity)
 	}
+}
 
+func (t *SyncTokenBucket) issueIfNecessary() {
+	now := t.nowFn().UnixNano()
+	elapse := now - t.lastIssueTimestamp
 	t.lastIssueTimestamp = now
+
+	if t.issueInterval <= 0 || elapse <= 0 || t.tokens >= float64(t.capacity) {
+		return
+	}
+
+	t.tokens += float64(elapse) / float64(t.issueInterval)
+	if t.tokens > float64(t.capacity) {
+		t.tokens = float64(t.capacity)
+	}
 }
 
 // TokenBucket implementation using "sync/atomic" package.
 // Has better concurrent performance than SyncTokenBucket.
+//
+// Instead of counting tokens, it records a virtual timestamp when the bucket was empty,
+// so available tokens = (now - emptyTimestamp) / issueInterval, no more than capacity.
+// Taking n tokens is moving emptyTimestamp forward by n * issueInterval, which is a single CAS operation.
 type AtomicTokenBucket struct {
-	capacity           int   // bucket capacity
-	tokens             int64 // currently issued tokens amount
-	issueRatePerSecond int   // token issuing rate(per second)
-	lastIssueTimestamp int64 // last time of issuing tokens
-	nowFn              gtime.NowFunc
+	capacity       int   // bucket capacity
+	issueInterval  int64 // nanoseconds to issue 1 token, <= 0 means never issue
+	emptyTimestamp int64 // virtual time of the bucket being empty
+	nowFn          gtime.NowFunc
+	observable
 }
 
 func (t *AtomicTokenBucket) Capacity() int {
 	return t.capacity
 }
 
+// Usage ratio of taken tokens
+func (t *AtomicTokenBucket) Usage() float64 {
+	now, interval := t.clock()
+	tokens := float64(now-atomic.LoadInt64(&t.emptyTimestamp)) / float64(interval)
+	return usageRatio(float64(t.capacity)-tokens, float64(t.capacity))
+}
+
+// Quota available tokens, and how long until the bucket is full
+func (t *AtomicTokenBucket) Quota() (int, time.Duration) {
+	now, interval := t.clock()
+	full := int64(t.capacity) * interval
+	elapse := now - atomic.LoadInt64(&t.emptyTimestamp)
+	if elapse > full {
+		elapse = full
+	}
+	remaining := int(elapse / interval)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if t.issueInterval <= 0 {
+		return remaining, 0
+	}
+	return remaining, time.Duration(full - elapse)
+}
+
 func (t *AtomicTokenBucket) Acquire() bool {
+	return t.AcquireN(1)
+}
 
-	t.issueIfNecessary()
+func (t *AtomicTokenBucket) AcquireN(n int) bool {
+	acquired, _, _ := t.tryAcquireN(n)
+	return t.observe(n, acquired)
+}
+
+func (t *AtomicTokenBucket) Wait(ctx context.Context) error {
+	return t.WaitN(ctx, 1)
+}
+
+func (t *AtomicTokenBucket) WaitN(ctx context.Context, n int) error {
+	return t.observeWait(n, waitN(ctx, n, t.tryAcquireN))
+}
+
+func (t *AtomicTokenBucket) tryAcquireN(n int) (bool, time.Duration, error) {
+	if n <= 0 {
+		return true, 0, nil
+	}
+	if n > t.capacity {
+		return false, 0, ErrExceedCapacity
+	}
 
+	now, interval := t.clock()
 	for {
-		if oldTokens := atomic.LoadInt64(&t.tokens); oldTokens > 0 {
-			swapped := atomic.CompareAndSwapInt64(&t.tokens, oldTokens, oldTokens-1)
-			if swapped {
-				break
+		oldEmpty, newEmpty := t.take(now, interval, n)
+		if newEmpty > now {
+			if t.issueInterval <= 0 {
+				return false, delayForever, nil
 			}
-		} else {
-			return false
+			return false, time.Duration(newEmpty - now), nil
+		}
+		if atomic.CompareAndSwapInt64(&t.emptyTimestamp, oldEmpty, newEmpty) {
+			return true, 0, nil
 		}
 	}
-	return true
 
 }
 
-func (t *AtomicTokenBucket) issueIfNecessary() {
+func (t *AtomicTokenBucket) Reserve(n int) Reservation {
+	rsv := t.reserve(n)
+	t.observe(n, rsv.OK())
+	return rsv
+}
 
+func (t *AtomicTokenBucket) reserve(n int) Reservation {
+	if n <= 0 || n > t.capacity {
+		return notOkReservation
+	}
+
+	now, interval := t.clock()
 	for {
-		oldTokens := atomic.LoadInt64(&t.tokens)
-		if oldTokens >= int64(t.capacity) {
-			return
+		oldEmpty, newEmpty := t.take(now, interval, n)
+		if newEmpty > now && t.issueInterval <= 0 {
+			return notOkReservation
 		}
-
-		oldLit := atomic.LoadInt64(&t.lastIssueTimestamp)
-		now := t.nowFn().UnixNano()
-
-		nowNano := now
-		elapse := nowNano - oldLit
-		delta := elapse / int64(time.Second) * int64(t.issueRatePerSecond)
-
-		if delta == 0 {
-			return
+		// emptyTimestamp may be in the future, which means tokens are negative
+		if atomic.CompareAndSwapInt64(&t.emptyTimestamp, oldEmpty, newEmpty) {
+			timeToAct := now
+			if newEmpty > now {
+				timeToAct = newEmpty
+			}
+			return newReservation(n, timeToAct, t.nowFn, t.refund)
 		}
+	}
+}
 
-		if oldTokens+delta > int64(t.capacity) {
-			delta = int64(t.capacity) - oldTokens
-		}
+func (t *AtomicTokenBucket) refund(n int) {
+	_, interval := t.clock()
+	atomic.AddInt64(&t.emptyTimestamp, -int64(n)*interval)
+}
 
-		s1 := atomic.CompareAndSwapInt64(&t.tokens, oldTokens, oldTokens+delta)
-		if !s1 {
-			continue
-		}
+// virtual clock and interval for calculating tokens.
+// If tokens are never issued, freeze the clock, so taken tokens never come back.
+func (t *AtomicTokenBucket) clock() (now int64, interval int64) {
+	if t.issueInterval <= 0 {
+		return 0, 1
+	}
+	return t.nowFn().UnixNano(), t.issueInterval
+}
 
-		s2 := atomic.CompareAndSwapInt64(&t.lastIssueTimestamp, oldLit, nowNano)
-		if !s2 {
-			if s1 {
-				atomic.AddInt64(&t.tokens, -delta)
-			}
-			continue
-		}
+// calculate emptyTimestamp after taking n tokens
+func (t *AtomicTokenBucket) take(now, interval int64, n int) (oldEmpty, newEmpty int64) {
+	oldEmpty = atomic.LoadInt64(&t.emptyTimestamp)
+	newEmpty = oldEmpty
+	// tokens never exceed capacity
+	if full := now - int64(t.capacity)*interval; newEmpty < full {
+		newEmpty = full
+	}
+	newEmpty += int64(n) * interval
+	return oldEmpty, newEmpty
+}
 
-		break
+// convert issuing rate(per second) to interval of issuing 1 token
+func rateToInterval(issueRatePerSecond float64) time.Duration {
+	if issueRatePerSecond <= 0 {
+		return 0
 	}
+	return time.Duration(float64(time.Second) / issueRatePerSecond)
+}
 
+// how long to wait until `lack` tokens are issued
+func tokenIssueDelay(lack float64, issueInterval int64) time.Duration {
+	if issueInterval <= 0 {
+		return delayForever
+	}
+	delay := time.Duration(math.Ceil(lack * float64(issueInterval)))
+	if delay <= 0 {
+		delay = 1
+	}
+	return delay
 }
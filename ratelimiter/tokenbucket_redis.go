@@ -0,0 +1,214 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"github.com/chanjarster/gears/simplelog"
+	gtime "github.com/chanjarster/gears/util/time"
+	"github.com/go-redis/redis/v7"
+	"time"
+)
+
+const (
+	tokenBucketPrefix = "_rl:tb:"
+
+	// same algorithm as SyncTokenBucket, state is stored in a hash: {tokens, ts}
+	//
+	// n < 0 means refunding -n tokens.
+	// if reserve == 1, tokens are taken even if they are not enough.
+	//
+	// return acquired, delay(microseconds, -1 means never)
+	tokenBucketScript = `local key = KEYS[1]
+local cap = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+local reserve = tonumber(ARGV[5])
+
+local state = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil
+then
+  tokens = cap
+  ts = now
+end
+
+if interval > 0 and now > ts and tokens < cap
+then
+  tokens = math.min(cap, tokens + (now - ts) / interval)
+end
+if now > ts
+then
+  ts = now
+end
+
+local delay = 0
+if tokens < n
+then
+  if interval <= 0
+  then
+    return {0, -1}
+  end
+  delay = math.ceil((n - tokens) * interval)
+  if reserve ~= 1
+  then
+    return {0, delay}
+  end
+end
+
+tokens = math.min(cap, tokens - n)
+redis.call('HMSET', key, 'tokens', tostring(tokens), 'ts', string.format('%.0f', ts))
+if interval > 0
+then
+  -- expire after bucket becomes full
+  redis.call('PEXPIRE', key, math.ceil((cap - tokens) * interval / 1000) + 1000)
+end
+return {1, delay}
+`
+)
+
+var (
+	tokenBucketScriptSha = ""
+)
+
+// NewRedisTokenBucket New a TokenBucket backed by redis, all instances of same key share the same tokens.
+//
+// Call LoadScript before using it.
+//
+//	key: bucket's name
+//	capacity: token bucket's capacity
+//	issueRatePerSecond: token issuing rate(per second), <= 0 means never issue
+func NewRedisTokenBucket(redisClient *redis.Client, key string, capacity int, issueRatePerSecond float64) TokenBucket {
+	return &redisTokenBucket{
+		redisClient:   redisClient,
+		key:           tokenBucketPrefix + key,
+		capacity:      capacity,
+		issueInterval: int64(rateToInterval(issueRatePerSecond)),
+		nowFn:         gtime.SysNow,
+	}
+}
+
+// NewRedisTokenBucketCluster New a redis TokenBucket for Redis Cluster environment.
+//
+//	hashTag: redis hash tag value, helps to ensure all keys be in the same slot.
+//
+// see: https://redis.io/topics/cluster-tutorial#redis-cluster-data-sharding
+func NewRedisTokenBucketCluster(redisClient *redis.Client, key string, capacity int, issueRatePerSecond float64, hashTag string) TokenBucket {
+	return &redisTokenBucket{
+		redisClient:   redisClient,
+		key:           tokenBucketPrefix + key + formatHashTag(hashTag),
+		capacity:      capacity,
+		issueInterval: int64(rateToInterval(issueRatePerSecond)),
+		nowFn:         gtime.SysNow,
+	}
+}
+
+type redisTokenBucket struct {
+	redisClient   *redis.Client
+	key           string
+	capacity      int
+	issueInterval int64 // nanoseconds to issue 1 token, <= 0 means never issue
+	nowFn         gtime.NowFunc
+	observable
+}
+
+func (r *redisTokenBucket) Capacity() int {
+	return r.capacity
+}
+
+func (r *redisTokenBucket) Acquire() bool {
+	return r.AcquireN(1)
+}
+
+func (r *redisTokenBucket) AcquireN(n int) bool {
+	acquired, _, err := r.tryAcquireN(context.Background(), n)
+	if err != nil && err != ErrExceedCapacity {
+		simplelog.ErrLogger.Println("eval tokenBucketScript ", tokenBucketScriptSha, "error", err)
+		return r.observe(n, true)
+	}
+	return r.observe(n, acquired)
+}
+
+func (r *redisTokenBucket) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+func (r *redisTokenBucket) WaitN(ctx context.Context, n int) error {
+	err := waitN(ctx, n, func(n int) (bool, time.Duration, error) {
+		return r.tryAcquireN(ctx, n)
+	})
+	return r.observeWait(n, err)
+}
+
+func (r *redisTokenBucket) Reserve(n int) Reservation {
+	rsv := r.reserve(n)
+	r.observe(n, rsv.OK())
+	return rsv
+}
+
+func (r *redisTokenBucket) reserve(n int) Reservation {
+	if n <= 0 || n > r.capacity {
+		return notOkReservation
+	}
+	now := r.nowFn().UnixNano()
+	acquired, delay, err := r.eval(context.Background(), n, true)
+	if err != nil {
+		simplelog.ErrLogger.Println("eval tokenBucketScript ", tokenBucketScriptSha, "error", err)
+		return notOkReservation
+	}
+	if !acquired || delay == delayForever {
+		return notOkReservation
+	}
+	return newReservation(n, now+int64(delay), r.nowFn, r.refund)
+}
+
+func (r *redisTokenBucket) tryAcquireN(ctx context.Context, n int) (bool, time.Duration, error) {
+	if n <= 0 {
+		return true, 0, nil
+	}
+	if n > r.capacity {
+		return false, 0, ErrExceedCapacity
+	}
+	return r.eval(ctx, n, false)
+}
+
+func (r *redisTokenBucket) refund(n int) {
+	if _, _, err := r.eval(context.Background(), -n, true); err != nil {
+		simplelog.ErrLogger.Println("eval tokenBucketScript ", tokenBucketScriptSha, "error", err)
+	}
+}
+
+func (r *redisTokenBucket) eval(ctx context.Context, n int, reserve bool) (bool, time.Duration, error) {
+	//local cap = tonumber(ARGV[1])
+	//local interval = tonumber(ARGV[2])
+	//local now = tonumber(ARGV[3])
+	//local n = tonumber(ARGV[4])
+	//local reserve = tonumber(ARGV[5])
+	reserveFlag := 0
+	if reserve {
+		reserveFlag = 1
+	}
+	return evalAcquireScript(ctx, r.redisClient, tokenBucketScriptSha, r.key,
+		r.capacity,
+		float64(r.issueInterval)/float64(time.Microsecond),
+		r.nowFn().UnixNano()/int64(time.Microsecond),
+		n,
+		reserveFlag,
+	)
+}
@@ -0,0 +1,128 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package ratelimiter
+
+import (
+	"context"
+	"github.com/chanjarster/gears/confs"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_redisTokenBucket(t *testing.T) {
+
+	val, hit := os.LookupEnv("INTEGRATION_TEST")
+	if !hit || val != "true" {
+		t.Skip("skip integration test")
+	}
+
+	redisClient := confs.NewRedisClient(&confs.RedisConf{
+		Host:     "localhost",
+		Port:     6379,
+		Password: "",
+		Pool:     10,
+		MinIdle:  1,
+	}, nil)
+	redisClient.FlushAll()
+	defer redisClient.Close()
+
+	LoadScript(redisClient)
+
+	t.Run("acquire", func(t *testing.T) {
+		bucket := NewRedisTokenBucket(redisClient, "acquire", 10, 10)
+		// 同一个key的实例共享令牌
+		bucket2 := NewRedisTokenBucket(redisClient, "acquire", 10, 10)
+		if got := bucket.AcquireN(6); !got {
+			t.Errorf("AcquireN(6) = %v, want %v", got, true)
+		}
+		if got := bucket2.AcquireN(5); got {
+			t.Errorf("AcquireN(5) = %v, want %v", got, false)
+		}
+		if got := bucket2.AcquireN(4); !got {
+			t.Errorf("AcquireN(4) = %v, want %v", got, true)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		if got := bucket.AcquireN(11); got {
+			t.Errorf("AcquireN(11) = %v, want %v", got, false)
+		}
+		time.Sleep(150 * time.Millisecond)
+		if got := bucket.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		bucket := NewRedisTokenBucket(redisClient, "wait", 10, 10)
+		bucket.AcquireN(10)
+		start := time.Now()
+		if got := bucket.WaitN(context.Background(), 2); got != nil {
+			t.Errorf("WaitN(2) = %v, want %v", got, nil)
+		}
+		if elapse := time.Since(start); elapse < 190*time.Millisecond {
+			t.Errorf("WaitN(2) elapse = %v, want about 200ms", elapse)
+		}
+		if got := bucket.WaitN(context.Background(), 11); got != ErrExceedCapacity {
+			t.Errorf("WaitN(11) = %v, want %v", got, ErrExceedCapacity)
+		}
+	})
+
+	t.Run("reserve and cancel", func(t *testing.T) {
+		bucket := NewRedisTokenBucket(redisClient, "reserve", 10, 10)
+		bucket.AcquireN(5)
+		r := bucket.Reserve(10)
+		if got := r.OK(); !got {
+			t.Errorf("OK() = %v, want %v", got, true)
+		}
+		if got := r.Delay(); got < 400*time.Millisecond || got > 500*time.Millisecond {
+			t.Errorf("Delay() = %v, want about 500ms", got)
+		}
+		r.Cancel()
+		if got := bucket.AcquireN(5); !got {
+			t.Errorf("AcquireN(5) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("never issue", func(t *testing.T) {
+		bucket := NewRedisTokenBucket(redisClient, "never", 10, 0)
+		bucket.AcquireN(10)
+		if got := bucket.Reserve(1).OK(); got {
+			t.Errorf("OK() = %v, want %v", got, false)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if got := bucket.Wait(ctx); got != context.DeadlineExceeded {
+			t.Errorf("Wait() = %v, want %v", got, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("cluster", func(t *testing.T) {
+		bucket := NewRedisTokenBucketCluster(redisClient, "cluster", 1, 1, "foo")
+		if got := bucket.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		if got := redisClient.Exists(tokenBucketPrefix + "cluster{foo}").Val(); got != 1 {
+			t.Errorf("Exists() = %v, want %v", got, 1)
+		}
+	})
+
+}
@@ -18,6 +18,7 @@
 package ratelimiter
 
 import (
+	"context"
 	gtime "github.com/chanjarster/gears/util/time"
 	"reflect"
 	"strconv"
@@ -34,14 +35,14 @@ func TestNewSyncTokenBucket(t *testing.T) {
 	if bucket.capacity != cap {
 		t.Errorf("NewSyncTokenBucket().capacity = %v, want %v", bucket.capacity, cap)
 	}
-	if bucket.tokens != cap {
+	if bucket.tokens != float64(cap) {
 		t.Errorf("NewSyncTokenBucket().tokens = %v, want %v", bucket.tokens, cap)
 	}
-	if bucket.issueRatePerSecond != irs {
-		t.Errorf("NewSyncTokenBucket().issueRatePerSecond = %v, want %v", bucket.issueRatePerSecond, irs)
+	if want := int64(time.Second) / int64(irs); bucket.issueInterval != want {
+		t.Errorf("NewSyncTokenBucket().issueInterval = %v, want %v", bucket.issueInterval, want)
 	}
 	if bucket.lastIssueTimestamp <= 0 {
-		t.Errorf("NewSyncTokenBucket().lastIssueTimestamp = %v, want > 0", bucket.issueRatePerSecond)
+		t.Errorf("NewSyncTokenBucket().lastIssueTimestamp = %v, want > 0", bucket.lastIssueTimestamp)
 	}
 
 }
@@ -67,14 +68,12 @@ func TestNewAtomicTokenBucket(t *testing.T) {
 	if bucket.capacity != cap {
 		t.Errorf("NewAtomicTokenBucket().capacity = %v, want %v", bucket.capacity, cap)
 	}
-	if bucket.tokens != int64(cap) {
-		t.Errorf("NewAtomicTokenBucket().tokens = %v, want %v", bucket.tokens, cap)
+	if want := int64(time.Second) / int64(irs); bucket.issueInterval != want {
+		t.Errorf("NewAtomicTokenBucket().issueInterval = %v, want %v", bucket.issueInterval, want)
 	}
-	if bucket.issueRatePerSecond != irs {
-		t.Errorf("NewAtomicTokenBucket().issueRatePerSecond = %v, want %v", bucket.issueRatePerSecond, irs)
-	}
-	if bucket.lastIssueTimestamp <= 0 {
-		t.Errorf("NewAtomicTokenBucket().lastIssueTimestamp = %v, want > 0", bucket.issueRatePerSecond)
+	// 桶是满的
+	if got, want := time.Now().UnixNano()-bucket.emptyTimestamp, int64(cap)*bucket.issueInterval; got < want {
+		t.Errorf("NewAtomicTokenBucket() tokens = %v, want %v", float64(got)/float64(bucket.issueInterval), cap)
 	}
 
 }
@@ -91,8 +90,362 @@ func TestAtomicTokenBucket_Acquire_bust(t *testing.T) {
 	testTokenBucket_Acquire_bust(t, capacity, rate, NewAtomicTokenBucket)
 }
 
+func TestSyncTokenBucket_AcquireN(t *testing.T) {
+	testTokenBucket_AcquireN(t, NewSyncTokenBucket)
+}
+
+func TestAtomicTokenBucket_AcquireN(t *testing.T) {
+	testTokenBucket_AcquireN(t, NewAtomicTokenBucket)
+}
+
+func TestSyncTokenBucket_WaitN(t *testing.T) {
+	testTokenBucket_WaitN(t, NewSyncTokenBucket)
+}
+
+func TestAtomicTokenBucket_WaitN(t *testing.T) {
+	testTokenBucket_WaitN(t, NewAtomicTokenBucket)
+}
+
+func TestSyncTokenBucket_Reserve(t *testing.T) {
+	testTokenBucket_Reserve(t, NewSyncTokenBucket)
+}
+
+func TestAtomicTokenBucket_Reserve(t *testing.T) {
+	testTokenBucket_Reserve(t, NewAtomicTokenBucket)
+}
+
 type tokenBucketCreator func(int, int) TokenBucket
 
+// 让TokenBucket的时间由clock决定，修改clock就能模拟时间流逝
+func mockTokenBucketClock(t *testing.T, bucket TokenBucket, clock *time.Time) {
+	nowFn := func() time.Time {
+		return *clock
+	}
+	if stb, ok := bucket.(*SyncTokenBucket); ok {
+		stb.nowFn = nowFn
+		stb.lastIssueTimestamp = clock.UnixNano()
+	} else if atb, ok := bucket.(*AtomicTokenBucket); ok {
+		atb.nowFn = nowFn
+		atb.emptyTimestamp = clock.UnixNano() - int64(atb.capacity)*atb.issueInterval
+	} else {
+		t.Errorf("Unsupported TokenBucket")
+	}
+}
+
+func testTokenBucket_Reserve(t *testing.T, new tokenBucketCreator) {
+
+	t.Run("reserve available tokens", func(t *testing.T) {
+		bucket := new(10, 10)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		r := bucket.Reserve(10)
+		if got := r.OK(); !got {
+			t.Errorf("OK() = %v, want %v", got, true)
+		}
+		if got := r.Delay(); got != 0 {
+			t.Errorf("Delay() = %v, want %v", got, 0)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("reserve tokens in advance", func(t *testing.T) {
+		bucket := new(10, 10)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		bucket.AcquireN(5)
+		r := bucket.Reserve(10)
+		if got := r.OK(); !got {
+			t.Errorf("OK() = %v, want %v", got, true)
+		}
+		if got := r.Delay(); got != 500*time.Millisecond {
+			t.Errorf("Delay() = %v, want %v", got, 500*time.Millisecond)
+		}
+		r2 := bucket.Reserve(10)
+		if got := r2.Delay(); got != 1500*time.Millisecond {
+			t.Errorf("Delay() = %v, want %v", got, 1500*time.Millisecond)
+		}
+		// 1秒之后，令牌仍然被预定的请求占用
+		clock = clock.Add(time.Second)
+		if got := r.Delay(); got != 0 {
+			t.Errorf("Delay() = %v, want %v", got, 0)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("cancel reservation", func(t *testing.T) {
+		bucket := new(10, 10)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		r := bucket.Reserve(10)
+		r2 := bucket.Reserve(5)
+		r2.Cancel()
+		r2.Cancel()
+		r.Cancel()
+		if got := bucket.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("cancel reservation after time to act", func(t *testing.T) {
+		bucket := new(10, 10)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		bucket.AcquireN(10)
+		r := bucket.Reserve(5)
+		clock = clock.Add(600 * time.Millisecond)
+		r.Cancel()
+		// 预定的令牌已经被使用，不再退还
+		if got := bucket.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("reserve more than capacity", func(t *testing.T) {
+		bucket := new(10, 10)
+		r := bucket.Reserve(11)
+		if got := r.OK(); got {
+			t.Errorf("OK() = %v, want %v", got, false)
+		}
+		r.Cancel()
+		if got := bucket.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("reserve never issued tokens", func(t *testing.T) {
+		bucket := new(10, 0)
+		bucket.AcquireN(10)
+		if got := bucket.Reserve(1).OK(); got {
+			t.Errorf("OK() = %v, want %v", got, false)
+		}
+	})
+
+}
+
+func testTokenBucket_AcquireN(t *testing.T, new tokenBucketCreator) {
+
+	t.Run("acquire 3+3+3, then 2", func(t *testing.T) {
+		bucket := new(10, 10)
+		for i := 0; i < 3; i++ {
+			if got := bucket.AcquireN(3); !got {
+				t.Errorf("AcquireN(3) = %v, want %v", got, true)
+			}
+		}
+		if got := bucket.AcquireN(2); got {
+			t.Errorf("AcquireN(2) = %v, want %v", got, false)
+		}
+		if got := bucket.AcquireN(1); !got {
+			t.Errorf("AcquireN(1) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("acquire more than capacity", func(t *testing.T) {
+		bucket := new(10, 10)
+		if got := bucket.AcquireN(11); got {
+			t.Errorf("AcquireN(11) = %v, want %v", got, false)
+		}
+		if got := bucket.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("acquire 0", func(t *testing.T) {
+		bucket := new(10, 10)
+		bucket.AcquireN(10)
+		if got := bucket.AcquireN(0); !got {
+			t.Errorf("AcquireN(0) = %v, want %v", got, true)
+		}
+	})
+
+}
+
+func testTokenBucket_WaitN(t *testing.T, new tokenBucketCreator) {
+
+	t.Run("wait more than capacity", func(t *testing.T) {
+		bucket := new(10, 10)
+		if got := bucket.WaitN(context.Background(), 11); got != ErrExceedCapacity {
+			t.Errorf("WaitN(11) = %v, want %v", got, ErrExceedCapacity)
+		}
+	})
+
+	t.Run("wait available", func(t *testing.T) {
+		bucket := new(10, 10)
+		if got := bucket.WaitN(context.Background(), 10); got != nil {
+			t.Errorf("WaitN(10) = %v, want %v", got, nil)
+		}
+	})
+
+	t.Run("wait until issuing", func(t *testing.T) {
+		bucket := new(10, 10)
+		bucket.AcquireN(10)
+		start := time.Now()
+		if got := bucket.Wait(context.Background()); got != nil {
+			t.Errorf("Wait() = %v, want %v", got, nil)
+		}
+		if elapse := time.Since(start); elapse < 90*time.Millisecond || elapse > 500*time.Millisecond {
+			t.Errorf("Wait() elapse = %v, want about 100ms", elapse)
+		}
+	})
+
+	t.Run("wait canceled", func(t *testing.T) {
+		bucket := new(10, 10)
+		bucket.AcquireN(10)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if got := bucket.Wait(ctx); got != context.DeadlineExceeded {
+			t.Errorf("Wait() = %v, want %v", got, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("wait forever", func(t *testing.T) {
+		bucket := new(10, 0)
+		bucket.AcquireN(10)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if got := bucket.Wait(ctx); got != context.DeadlineExceeded {
+			t.Errorf("Wait() = %v, want %v", got, context.DeadlineExceeded)
+		}
+	})
+
+}
+
+func Test_tokenIssueDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		lack     float64
+		interval time.Duration
+		want     time.Duration
+	}{
+		{"lack 1, interval 100ms", 1, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"lack 0.3, interval 100ms", 0.3, 100 * time.Millisecond, 30 * time.Millisecond},
+		{"lack 10, interval 3s", 10, 3 * time.Second, 30 * time.Second},
+		{"lack 0, interval 100ms", 0, 100 * time.Millisecond, 1},
+		{"lack 1, interval 0", 1, 0, delayForever},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenIssueDelay(tt.lack, int64(tt.interval)); got != tt.want {
+				t.Errorf("tokenIssueDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rateToInterval(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want time.Duration
+	}{
+		{1000, time.Millisecond},
+		{1, time.Second},
+		{1.0 / 3, 3 * time.Second},
+		{0, 0},
+		{-1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.FormatFloat(tt.rate, 'f', -1, 64), func(t *testing.T) {
+			if got := rateToInterval(tt.rate); got != tt.want {
+				t.Errorf("rateToInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncTokenBucket_smoothIssue(t *testing.T) {
+	testTokenBucket_smoothIssue(t, func(capacity int, interval time.Duration) TokenBucket {
+		return NewSyncTokenBucketInterval(capacity, interval)
+	})
+}
+
+func TestAtomicTokenBucket_smoothIssue(t *testing.T) {
+	testTokenBucket_smoothIssue(t, func(capacity int, interval time.Duration) TokenBucket {
+		return NewAtomicTokenBucketInterval(capacity, interval)
+	})
+}
+
+// 测试令牌是否平滑的发放，而不是按秒发放
+func testTokenBucket_smoothIssue(t *testing.T, new func(int, time.Duration) TokenBucket) {
+
+	t.Run("rate 1000/s", func(t *testing.T) {
+		bucket := new(1000, time.Millisecond)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		bucket.AcquireN(1000)
+
+		clock = clock.Add(time.Millisecond)
+		if got := bucket.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		clock = clock.Add(10 * time.Millisecond)
+		if got := bucket.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+	t.Run("1 token every 3 seconds", func(t *testing.T) {
+		bucket := new(1, 3*time.Second)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		bucket.Acquire()
+
+		clock = clock.Add(2 * time.Second)
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		clock = clock.Add(time.Second)
+		if got := bucket.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("fractional tokens accumulate", func(t *testing.T) {
+		bucket := new(10, 100*time.Millisecond)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		bucket.AcquireN(10)
+
+		// 每次流逝半个interval，第二次才能拿到令牌
+		clock = clock.Add(50 * time.Millisecond)
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+		clock = clock.Add(50 * time.Millisecond)
+		if got := bucket.Acquire(); !got {
+			t.Errorf("Acquire() = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("never exceed capacity", func(t *testing.T) {
+		bucket := new(10, time.Millisecond)
+		clock := time.Now()
+		mockTokenBucketClock(t, bucket, &clock)
+		clock = clock.Add(time.Hour)
+		if got := bucket.AcquireN(10); !got {
+			t.Errorf("AcquireN(10) = %v, want %v", got, true)
+		}
+		if got := bucket.Acquire(); got {
+			t.Errorf("Acquire() = %v, want %v", got, false)
+		}
+	})
+
+}
+
 // 测试爆发请求之后，令牌桶是否能够在间隔 1 秒之后生成新 token
 func testTokenBucket_Acquire_bust(t *testing.T, capacity, rate int, new tokenBucketCreator) {
 
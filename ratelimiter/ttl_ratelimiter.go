@@ -17,7 +17,13 @@
 
 package ratelimiter
 
-import "context"
+import (
+	"context"
+	gtime "github.com/chanjarster/gears/util/time"
+	"sort"
+	"sync"
+	"time"
+)
 
 // Result Legal results are:
 //
@@ -31,6 +37,7 @@ type Result struct {
 	Triggered bool   // first time blocking，otherwise false
 	Ttl       int    // how many seconds blocking will last
 	Msg       string // message recorded when first time blocking
+	Strikes   int    // how many times blocking triggered in strike memory period, only in escalation mode
 }
 
 // TtlRateLimiter A rate limiter that will prevent further request for ttl seconds after first time request rate exceeds the limit.
@@ -59,6 +66,49 @@ type TtlRateLimiter interface {
 	ShouldBlock2Context(ctx context.Context, key string, blockKey string, msg string) *Result
 }
 
+// BlockedKey a blockKey being blocked
+type BlockedKey struct {
+	BlockKey string // blockKey passed to ShouldBlock2
+	Ttl      int    // how many seconds blocking will last
+	Msg      string // message recorded when first time blocking
+}
+
+// Inspection current state of a key
+type Inspection struct {
+	Count    int    // requests recorded in current window
+	Capacity int    // window capacity
+	Block    bool   // true: key(as blockKey) is blocked
+	Ttl      int    // how many seconds blocking will last
+	Msg      string // message recorded when first time blocking
+	Strikes  int    // how many times blocking triggered in strike memory period, only in escalation mode
+}
+
+// TtlRateLimiterAdmin admin operations of TtlRateLimiter, implemented by all TtlRateLimiter in this package:
+//
+//	admin := limiter.(ratelimiter.TtlRateLimiterAdmin)
+type TtlRateLimiterAdmin interface {
+	// Unblock release blocking of `blockKey` immediately, strikes are forgotten too
+	Unblock(blockKey string) error
+
+	// Reset clear the requests recorded for `key`, blocking is not released, use Unblock
+	Reset(key string) error
+
+	// ListBlocked iterate blocked keys, start with cursor 0, iteration is finished when nextCursor is 0.
+	// `count` is a hint of how many keys to return in one call.
+	ListBlocked(cursor uint64, count int64) (keys []*BlockedKey, nextCursor uint64, err error)
+
+	// Inspect return how many requests of `key` in current window, and blocking state of `key` as blockKey
+	Inspect(key string) (*Inspection, error)
+
+	UnblockContext(ctx context.Context, blockKey string) error
+
+	ResetContext(ctx context.Context, key string) error
+
+	ListBlockedContext(ctx context.Context, cursor uint64, count int64) (keys []*BlockedKey, nextCursor uint64, err error)
+
+	InspectContext(ctx context.Context, key string) (*Inspection, error)
+}
+
 // Interface for the need of runtime rate limit parameters
 type TtlRateLimiterParams interface {
 	// capacity: window capacity
@@ -70,6 +120,37 @@ type TtlRateLimiterParams interface {
 	GetTimeoutSeconds() int
 }
 
+// TtlRateLimiterEscalation optional interface of TtlRateLimiterParams, enables escalation mode:
+// each consecutive blocking triggered within strike memory period lasts longer.
+type TtlRateLimiterEscalation interface {
+	// seconds of blocking for the 1st, 2nd, 3rd... strike, the last one is used for further strikes
+	GetBlockScheduleSeconds() []int
+	// how many seconds strikes are remembered after the latest blocking ends
+	GetStrikeMemorySeconds() int
+}
+
+// return block schedule and strike memory, nil, 0 if escalation mode is not enabled
+func getEscalation(params TtlRateLimiterParams) ([]int, int) {
+	escalation, ok := params.(TtlRateLimiterEscalation)
+	if !ok {
+		return nil, 0
+	}
+	schedule := escalation.GetBlockScheduleSeconds()
+	memory := escalation.GetStrikeMemorySeconds()
+	if len(schedule) == 0 || memory <= 0 {
+		return nil, 0
+	}
+	return schedule, memory
+}
+
+// blocking seconds of the nth strike
+func scheduledTimeout(schedule []int, strikes int) int {
+	if strikes > len(schedule) {
+		strikes = len(schedule)
+	}
+	return schedule[strikes-1]
+}
+
 func isParamsNotSet(params TtlRateLimiterParams) bool {
 	return params.GetCapacity() <= 0 || params.GetTimeoutSeconds() <= 0 || params.GetWindowSizeSeconds() <= 0
 }
@@ -82,6 +163,75 @@ func NewFixedTtlRateLimiterParams(capacity, windowsSizeSec, timeoutSec int) TtlR
 	}
 }
 
+// NewEscalatingTtlRateLimiterParams params of escalation mode, GetTimeoutSeconds is the first of blockScheduleSec.
+//
+//	blockScheduleSec: seconds of blocking for the 1st, 2nd, 3rd... strike, e.g. 1m, 5m, 30m, 24h
+//	strikeMemorySec: how many seconds strikes are remembered after the latest blocking ends
+func NewEscalatingTtlRateLimiterParams(capacity, windowsSizeSec int, blockScheduleSec []int, strikeMemorySec int) TtlRateLimiterParams {
+	timeoutSec := 0
+	if len(blockScheduleSec) > 0 {
+		timeoutSec = blockScheduleSec[0]
+	}
+	return &escalatingTtlRateLimiterParams{
+		fixedTtlRateLimiterParams: fixedTtlRateLimiterParams{
+			capacity:      capacity,
+			windowSizeSec: windowsSizeSec,
+			timeoutSec:    timeoutSec,
+		},
+		blockScheduleSec: append([]int(nil), blockScheduleSec...),
+		strikeMemorySec:  strikeMemorySec,
+	}
+}
+
+type escalatingTtlRateLimiterParams struct {
+	fixedTtlRateLimiterParams
+	blockScheduleSec []int
+	strikeMemorySec  int
+}
+
+func (e *escalatingTtlRateLimiterParams) GetBlockScheduleSeconds() []int {
+	return e.blockScheduleSec
+}
+
+func (e *escalatingTtlRateLimiterParams) GetStrikeMemorySeconds() int {
+	return e.strikeMemorySec
+}
+
+// capacity is divided by replicas, the local share of a distributed rate limiter
+type scaledTtlRateLimiterParams struct {
+	params   TtlRateLimiterParams
+	replicas int
+}
+
+func (s *scaledTtlRateLimiterParams) GetWindowSizeSeconds() int {
+	return s.params.GetWindowSizeSeconds()
+}
+
+func (s *scaledTtlRateLimiterParams) GetCapacity() int {
+	capacity := s.params.GetCapacity()
+	if capacity <= 0 || s.replicas <= 1 {
+		return capacity
+	}
+	if capacity = capacity / s.replicas; capacity < 1 {
+		capacity = 1
+	}
+	return capacity
+}
+
+func (s *scaledTtlRateLimiterParams) GetTimeoutSeconds() int {
+	return s.params.GetTimeoutSeconds()
+}
+
+func (s *scaledTtlRateLimiterParams) GetBlockScheduleSeconds() []int {
+	schedule, _ := getEscalation(s.params)
+	return schedule
+}
+
+func (s *scaledTtlRateLimiterParams) GetStrikeMemorySeconds() int {
+	_, memory := getEscalation(s.params)
+	return memory
+}
+
 type fixedTtlRateLimiterParams struct {
 	timeoutSec    int
 	windowSizeSec int
@@ -99,3 +249,329 @@ func (f *fixedTtlRateLimiterParams) GetTimeoutSeconds() int {
 func (f *fixedTtlRateLimiterParams) GetCapacity() int {
 	return f.capacity
 }
+
+// how often SyncTtlRateLimiter purges expired keys
+const syncTtlSweepInterval = int64(time.Minute)
+
+// NewSyncTtlRateLimiter create an in-process TtlRateLimiter, it has identical Result semantics with the redis one,
+// useful in unit tests and single node deployments.
+//
+//	nowFn: clock of the rate limiter, nil means gtime.SysNow
+func NewSyncTtlRateLimiter(params TtlRateLimiterParams, nowFn gtime.NowFunc) TtlRateLimiter {
+	if nowFn == nil {
+		nowFn = gtime.SysNow
+	}
+	return &syncTtlRateLimiter{
+		params:  params,
+		nowFn:   nowFn,
+		records: make(map[string]*ttlRecords),
+		blocks:  make(map[string]*ttlBlock),
+		strikes: make(map[string]*ttlStrikes),
+	}
+}
+
+// request timestamps of a key, same as the redis list
+type ttlRecords struct {
+	timestamps []int64 // unix seconds, oldest first
+	expireAt   int64   // unix nanoseconds
+}
+
+// blocking of a blockKey, same as the redis block key
+type ttlBlock struct {
+	msg      string
+	expireAt int64 // unix nanoseconds
+}
+
+// strikes of a blockKey, same as the redis strike key
+type ttlStrikes struct {
+	count    int
+	expireAt int64 // unix nanoseconds
+}
+
+type syncTtlRateLimiter struct {
+	params    TtlRateLimiterParams
+	nowFn     gtime.NowFunc
+	lock      sync.Mutex
+	records   map[string]*ttlRecords
+	blocks    map[string]*ttlBlock
+	strikes   map[string]*ttlStrikes
+	nextSweep int64
+	observable
+}
+
+func (s *syncTtlRateLimiter) ShouldBlock(key string, msg string) *Result {
+	return s.ShouldBlock2(key, key, msg)
+}
+
+func (s *syncTtlRateLimiter) IsBlocked(blockKey string) *Result {
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.blockedResult(blockKey, now)
+}
+
+func (s *syncTtlRateLimiter) ShouldBlock2(key string, blockKey string, msg string) *Result {
+	return s.observeResult(s.shouldBlock2(key, blockKey, msg))
+}
+
+func (s *syncTtlRateLimiter) shouldBlock2(key string, blockKey string, msg string) *Result {
+	capacity := s.params.GetCapacity()
+	windowSize := int64(s.params.GetWindowSizeSeconds())
+	timeout := s.params.GetTimeoutSeconds()
+	if capacity <= 0 || windowSize <= 0 || timeout <= 0 {
+		return &Result{}
+	}
+
+	nowTime := s.nowFn()
+	now := nowTime.UnixNano()
+	nowSec := nowTime.Unix()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.sweep(now)
+
+	if result := s.blockedResult(blockKey, now); result.Block {
+		return result
+	}
+
+	records := s.records[key]
+	if records == nil || records.expireAt <= now {
+		records = &ttlRecords{}
+		s.records[key] = records
+	}
+
+	if len(records.timestamps) < capacity {
+		records.timestamps = append(records.timestamps, nowSec)
+		records.expireAt = now + int64(timeout)*2*int64(time.Second)
+		return &Result{}
+	}
+
+	// capacity may be decreased, drop the oldest ones
+	records.timestamps = records.timestamps[len(records.timestamps)-capacity:]
+
+	if nowSec-records.timestamps[0] > windowSize {
+		records.timestamps = append(records.timestamps[1:], nowSec)
+		records.expireAt = now + int64(timeout)*2*int64(time.Second)
+		return &Result{}
+	}
+
+	strikes := 0
+	if schedule, memory := getEscalation(s.params); schedule != nil {
+		strikes = s.strikeCount(blockKey, now) + 1
+		timeout = scheduledTimeout(schedule, strikes)
+		s.strikes[blockKey] = &ttlStrikes{
+			count:    strikes,
+			expireAt: now + int64(timeout+memory)*int64(time.Second),
+		}
+	}
+
+	s.blocks[blockKey] = &ttlBlock{
+		msg:      msg,
+		expireAt: now + int64(timeout)*int64(time.Second),
+	}
+	return &Result{
+		Block:     true,
+		Triggered: true,
+		Ttl:       timeout,
+		Msg:       msg,
+		Strikes:   strikes,
+	}
+}
+
+func (s *syncTtlRateLimiter) ShouldBlockContext(ctx context.Context, key string, msg string) *Result {
+	return s.ShouldBlock(key, msg)
+}
+
+func (s *syncTtlRateLimiter) IsBlockedContext(ctx context.Context, blockKey string) *Result {
+	return s.IsBlocked(blockKey)
+}
+
+func (s *syncTtlRateLimiter) ShouldBlock2Context(ctx context.Context, key string, blockKey string, msg string) *Result {
+	return s.ShouldBlock2(key, blockKey, msg)
+}
+
+func (s *syncTtlRateLimiter) GetWindowSizeSeconds() int {
+	return s.params.GetWindowSizeSeconds()
+}
+
+func (s *syncTtlRateLimiter) GetCapacity() int {
+	return s.params.GetCapacity()
+}
+
+func (s *syncTtlRateLimiter) GetTimeoutSeconds() int {
+	return s.params.GetTimeoutSeconds()
+}
+
+func (s *syncTtlRateLimiter) Unblock(blockKey string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.blocks, blockKey)
+	delete(s.strikes, blockKey)
+	return nil
+}
+
+func (s *syncTtlRateLimiter) Reset(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// ListBlocked cursor is the offset of blocked keys sorted by name
+func (s *syncTtlRateLimiter) ListBlocked(cursor uint64, count int64) ([]*BlockedKey, uint64, error) {
+	if count <= 0 {
+		count = 10
+	}
+	now := s.nowFn().UnixNano()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	blockKeys := make([]string, 0, len(s.blocks))
+	for blockKey, block := range s.blocks {
+		if block.expireAt > now {
+			blockKeys = append(blockKeys, blockKey)
+		}
+	}
+	sort.Strings(blockKeys)
+
+	if cursor >= uint64(len(blockKeys)) {
+		return nil, 0, nil
+	}
+	end := cursor + uint64(count)
+	if end >= uint64(len(blockKeys)) {
+		end = 0
+	}
+
+	var page []string
+	if end == 0 {
+		page = blockKeys[cursor:]
+	} else {
+		page = blockKeys[cursor:end]
+	}
+	keys := make([]*BlockedKey, 0, len(page))
+	for _, blockKey := range page {
+		block := s.blocks[blockKey]
+		keys = append(keys, &BlockedKey{
+			BlockKey: blockKey,
+			Ttl:      ttlSeconds(block.expireAt - now),
+			Msg:      block.msg,
+		})
+	}
+	return keys, end, nil
+}
+
+func (s *syncTtlRateLimiter) Inspect(key string) (*Inspection, error) {
+	windowSize := int64(s.params.GetWindowSizeSeconds())
+	nowTime := s.nowFn()
+	now := nowTime.UnixNano()
+	nowSec := nowTime.Unix()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	inspection := &Inspection{Capacity: s.params.GetCapacity()}
+	if records := s.records[key]; records != nil && records.expireAt > now {
+		inspection.Count = countInWindow(records.timestamps, nowSec, windowSize, inspection.Capacity)
+	}
+	result := s.blockedResult(key, now)
+	inspection.Block = result.Block
+	inspection.Ttl = result.Ttl
+	inspection.Msg = result.Msg
+	inspection.Strikes = s.strikeCount(key, now)
+	return inspection, nil
+}
+
+func (s *syncTtlRateLimiter) UnblockContext(ctx context.Context, blockKey string) error {
+	return s.Unblock(blockKey)
+}
+
+func (s *syncTtlRateLimiter) ResetContext(ctx context.Context, key string) error {
+	return s.Reset(key)
+}
+
+func (s *syncTtlRateLimiter) ListBlockedContext(ctx context.Context, cursor uint64, count int64) ([]*BlockedKey, uint64, error) {
+	return s.ListBlocked(cursor, count)
+}
+
+func (s *syncTtlRateLimiter) InspectContext(ctx context.Context, key string) (*Inspection, error) {
+	return s.Inspect(key)
+}
+
+// must be called with lock held
+func (s *syncTtlRateLimiter) blockedResult(blockKey string, now int64) *Result {
+	block := s.blocks[blockKey]
+	if block == nil {
+		return &Result{}
+	}
+	if block.expireAt <= now {
+		delete(s.blocks, blockKey)
+		return &Result{}
+	}
+	return &Result{
+		Block:   true,
+		Ttl:     ttlSeconds(block.expireAt - now),
+		Msg:     block.msg,
+		Strikes: s.strikeCount(blockKey, now),
+	}
+}
+
+// must be called with lock held
+func (s *syncTtlRateLimiter) strikeCount(blockKey string, now int64) int {
+	strikes := s.strikes[blockKey]
+	if strikes == nil {
+		return 0
+	}
+	if strikes.expireAt <= now {
+		delete(s.strikes, blockKey)
+		return 0
+	}
+	return strikes.count
+}
+
+// purge expired keys periodically, must be called with lock held
+func (s *syncTtlRateLimiter) sweep(now int64) {
+	if now < s.nextSweep {
+		return
+	}
+	s.nextSweep = now + syncTtlSweepInterval
+	for key, records := range s.records {
+		if records.expireAt <= now {
+			delete(s.records, key)
+		}
+	}
+	for blockKey, block := range s.blocks {
+		if block.expireAt <= now {
+			delete(s.blocks, blockKey)
+		}
+	}
+	for blockKey, strikes := range s.strikes {
+		if strikes.expireAt <= now {
+			delete(s.strikes, blockKey)
+		}
+	}
+}
+
+// round remaining nanoseconds to seconds, same as redis TTL command
+func ttlSeconds(remain int64) int {
+	return int((remain + int64(time.Second)/2) / int64(time.Second))
+}
+
+// count timestamps(oldest first) still in the window, only the latest `capacity` ones are counted
+func countInWindow(timestamps []int64, nowSec, windowSize int64, capacity int) int {
+	if capacity >= 0 && len(timestamps) > capacity {
+		timestamps = timestamps[len(timestamps)-capacity:]
+	}
+	count := 0
+	for _, ts := range timestamps {
+		if nowSec-ts <= windowSize {
+			count++
+		}
+	}
+	return count
+}
@@ -19,8 +19,10 @@ package ratelimiter
 
 import (
 	"context"
+	"github.com/chanjarster/gears/circuitbreaker"
 	"github.com/chanjarster/gears/simplelog"
 	"github.com/go-redis/redis/v7"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -44,31 +46,47 @@ const (
 		    lpop key
 				return false, false
 
+			if escalation mode
+			  strikes = incr key:strikes
+			  timeout = schedule[strikes]
+			  expire key:strikes timeout + memory
+
 			SET key:block 1 EX timeout NX
+			ZADD blocked now+timeout key
 			return true, true
 	*/
-	prefix = "_rl:"
-	suffix = ":bl"
-
-	// return block, triggered, ttl, msg
+	prefix       = "_rl:"
+	suffix       = ":bl"
+	strikeSuffix = ":st"
+	blockedIndex = "_rl:blocked" // sorted set of blocked keys, score is the timestamp(seconds) when blocking expires
+
+	// return block, triggered, ttl, msg, strikes
+	//
+	// ARGV[7] is the block key to be indexed,
+	// ARGV[6] is strike memory seconds, ARGV[8...] is block schedule, only in escalation mode
 	script1 = `local key = KEYS[1]
 local keyb = KEYS[2]
+local keys = KEYS[3]
+local keyi = KEYS[4]
 local cap = tonumber(ARGV[1])
 local win = tonumber(ARGV[2])
 local exp = tonumber(ARGV[3])
 local now = tonumber(ARGV[4])
 local msg = ARGV[5]
+local mem = tonumber(ARGV[6])
+local blk = ARGV[7]
 
 if cap <= 0 or win <= 0 or exp <= 0
 then
-  return {0, 0, 0, ''}
+  return {0, 0, 0, '', 0}
 end
 
 if redis.call('EXISTS', keyb) == 1
 then
   local ttl = redis.call('TTL', keyb)
   local omsg = redis.call('GET', keyb)
-  return {1, 0, ttl, omsg}
+  local strikes = tonumber(redis.call('GET', keys)) or 0
+  return {1, 0, ttl, omsg, strikes}
 end
 
 local size = redis.call('LLEN', key)
@@ -76,7 +94,7 @@ if size < cap
 then
   redis.call('RPUSH', key, now)
   redis.call('EXPIRE', key, exp * 2)
-  return {0, 0, 0, ''}
+  return {0, 0, 0, '', 0}
 end
 
 local diff = size - cap
@@ -94,7 +112,7 @@ if table.getn(list) == 0
 then
   redis.call('RPUSH', key, now)
   redis.call('EXPIRE', key, exp * 2)
-  return {0, 0, 0, ''}
+  return {0, 0, 0, '', 0}
 end
 
 local oldest = tonumber(list[1])
@@ -103,24 +121,39 @@ then
   redis.call('RPUSH', key, now)
   redis.call('LPOP', key)
   redis.call('EXPIRE', key, exp * 2)
-  return {0, 0, 0, ''}
+  return {0, 0, 0, '', 0}
+end
+
+local strikes = 0
+if mem > 0 and #ARGV > 7
+then
+  strikes = redis.call('INCR', keys)
+  exp = tonumber(ARGV[7 + math.min(strikes, #ARGV - 7)])
+  redis.call('EXPIRE', keys, exp + mem)
 end
 
 redis.call('SET', keyb, msg, 'EX', exp, 'NX')
-return {1, 1, exp, msg}
+
+redis.call('ZREMRANGEBYSCORE', keyi, '-inf', now)
+redis.call('ZADD', keyi, now + exp, blk)
+local last = redis.call('ZRANGE', keyi, -1, -1, 'WITHSCORES')
+redis.call('EXPIRE', keyi, tonumber(last[2]) - now)
+return {1, 1, exp, msg, strikes}
 `
 
-	// return block, triggered, ttl, msg
+	// return block, triggered, ttl, msg, strikes
 	script2 = `local keyb = KEYS[1]
+local keys = KEYS[2]
 
 if redis.call('EXISTS', keyb) == 1
 then
   local ttl = redis.call('TTL', keyb)
   local omsg = redis.call('GET', keyb)
-  return {1, 0, ttl, omsg}
+  local strikes = tonumber(redis.call('GET', keys)) or 0
+  return {1, 0, ttl, omsg, strikes}
 end
 
-return {0, 0, 0, ''}
+return {0, 0, 0, '', 0}
 `
 )
 
@@ -129,30 +162,108 @@ var (
 	scriptSha2 = ""
 )
 
-func NewRedisTtlRateLimiter(redisClient *redis.Client, params TtlRateLimiterParams) TtlRateLimiter {
-	r := &redisTtlRateLimiter{
-		params:      params,
-		redisClient: redisClient,
+// FailurePolicy decides the Result when redis is unavailable, or circuit breaker is open
+type FailurePolicy int
+
+const (
+	FailOpen   FailurePolicy = iota // let requests pass, the default policy
+	FailClosed                      // block requests, Ttl is GetTimeoutSeconds
+	FailLocal                       // degrade to a local in-memory rate limiter, see WithExpectedReplicas
+)
+
+type ttlOptions struct {
+	failurePolicy FailurePolicy
+	replicas      int
+	breaker       circuitbreaker.Interface
+	redisTimeout  time.Duration
+}
+
+type TtlOption func(opts *ttlOptions)
+
+// WithFailurePolicy how to handle requests when redis is unavailable, default is FailOpen
+func WithFailurePolicy(policy FailurePolicy) TtlOption {
+	return func(opts *ttlOptions) {
+		opts.failurePolicy = policy
+	}
+}
+
+// WithExpectedReplicas how many replicas of the service share the redis rate limiter, default is 1.
+// In FailLocal policy, capacity of the local rate limiter is capacity / replicas.
+func WithExpectedReplicas(replicas int) TtlOption {
+	return func(opts *ttlOptions) {
+		opts.replicas = replicas
 	}
-	return r
+}
+
+// WithCircuitBreaker calls redis through the circuit breaker, when it's open, FailurePolicy applies.
+// Default is circuitbreaker.NeverOpen.
+//
+// If breaker implements circuitbreaker.ContextInterface, DoCtx is called with ctx of the caller,
+// otherwise errors caused by ctx of the caller being done are returned to Do wrapped by circuitbreaker.Ignored.
+// Either way, circuit breakers of package circuitbreaker count them as neither success nor failure.
+func WithCircuitBreaker(breaker circuitbreaker.Interface) TtlOption {
+	return func(opts *ttlOptions) {
+		opts.breaker = breaker
+	}
+}
+
+// WithRedisTimeout timeout of each redis call of ShouldBlock and IsBlocked, a timeout is a failure of circuit breaker.
+// Default is 0, means no timeout other than the redis client's.
+func WithRedisTimeout(timeout time.Duration) TtlOption {
+	return func(opts *ttlOptions) {
+		opts.redisTimeout = timeout
+	}
+}
+
+func NewRedisTtlRateLimiter(redisClient *redis.Client, params TtlRateLimiterParams, opts ...TtlOption) TtlRateLimiter {
+	return newRedisTtlRateLimiter(redisClient, params, "", opts)
 }
 
 // NewRedisTtlRateLimiterCluster create a redis rate limiter for Redis Cluster environment.
 //  hashTag: redis hash tag value, helps to ensure all keys be in the same slot.
 // see: https://redis.io/topics/cluster-tutorial#redis-cluster-data-sharding
-func NewRedisTtlRateLimiterCluster(redisClient *redis.Client, params TtlRateLimiterParams, hashTag string) TtlRateLimiter {
+func NewRedisTtlRateLimiterCluster(redisClient *redis.Client, params TtlRateLimiterParams, hashTag string, opts ...TtlOption) TtlRateLimiter {
+	return newRedisTtlRateLimiter(redisClient, params, formatHashTag(hashTag), opts)
+}
+
+func newRedisTtlRateLimiter(redisClient *redis.Client, params TtlRateLimiterParams, hashTag string, opts []TtlOption) *redisTtlRateLimiter {
+	options := &ttlOptions{
+		failurePolicy: FailOpen,
+		replicas:      1,
+		breaker:       circuitbreaker.NeverOpen,
+	}
+	for _, o := range opts {
+		o(options)
+	}
+
 	r := &redisTtlRateLimiter{
-		params:      params,
-		redisClient: redisClient,
-		hashTag:     "{" + strings.Trim(hashTag, "{}") + "}",
+		params:        params,
+		redisClient:   redisClient,
+		hashTag:       hashTag,
+		failurePolicy: options.failurePolicy,
+		breaker:       options.breaker,
+		redisTimeout:  options.redisTimeout,
+	}
+	if options.failurePolicy == FailLocal {
+		r.local = NewSyncTtlRateLimiter(&scaledTtlRateLimiterParams{params: params, replicas: options.replicas}, nil)
 	}
 	return r
 }
 
+// wrap hashTag with "{}"
+func formatHashTag(hashTag string) string {
+	return "{" + strings.Trim(hashTag, "{}") + "}"
+}
+
 type redisTtlRateLimiter struct {
-	params      TtlRateLimiterParams
-	redisClient *redis.Client
-	hashTag     string
+	params        TtlRateLimiterParams
+	redisClient   *redis.Client
+	hashTag       string
+	failurePolicy FailurePolicy
+	local         TtlRateLimiter // fallback in FailLocal policy
+	breaker       circuitbreaker.Interface
+	redisTimeout  time.Duration
+	observable
 }
 
 func (r *redisTtlRateLimiter) ShouldBlockContext(ctx context.Context, key string, msg string) *Result {
@@ -160,76 +271,156 @@ func (r *redisTtlRateLimiter) ShouldBlockContext(ctx context.Context, key string
 }
 
 func (r *redisTtlRateLimiter) IsBlockedContext(ctx context.Context, blockKey string) *Result {
-	result := &Result{}
-	blockKey = blockKey + suffix
+	result, err := r.execute(ctx, func(ctx context.Context) (*Result, error) {
+		return r.isBlocked(ctx, blockKey)
+	})
+	if err != nil {
+		if err != circuitbreaker.ErrOpenState {
+			simplelog.ErrLogger.Println("eval script2 ", scriptSha2, "error", err)
+		}
+		result = r.fallback(func(local TtlRateLimiter) *Result {
+			return local.IsBlockedContext(ctx, blockKey)
+		}, "")
+	}
+	return result
+}
+
+func (r *redisTtlRateLimiter) isBlocked(ctx context.Context, blockKey string) (*Result, error) {
+	ctx, cancel := r.redisContext(ctx)
+	defer cancel()
 
 	//local keyb = KEYS[1]
+	//local keys = KEYS[2]
 	raw, err := r.redisClient.WithContext(ctx).EvalSha(
 		scriptSha2,
-		[]string{blockKey},
+		[]string{r.blockKey(blockKey), r.strikeKey(blockKey)},
 	).Result()
 
 	if err != nil {
-		simplelog.ErrLogger.Println("eval script2 ", scriptSha2, "error", err)
-		return result
+		return nil, err
 	}
 
-	arr := raw.([]interface{})
-	result.Block = arr[0].(int64) == 1
-	result.Triggered = arr[1].(int64) == 1
-	result.Ttl = int(arr[2].(int64))
-	result.Msg = arr[3].(string)
-	return result
+	result := &Result{}
+	parseResult(raw, result)
+	return result, nil
 
 }
 
 func (r *redisTtlRateLimiter) ShouldBlock2Context(ctx context.Context, key string, blockKey string, msg string) *Result {
-	result := &Result{}
-
 	if isParamsNotSet(r.params) {
-		return result
+		return r.observeResult(&Result{})
 	}
 
-	key = prefix + key
-	blockKey = blockKey + suffix
+	result, err := r.execute(ctx, func(ctx context.Context) (*Result, error) {
+		return r.shouldBlock2(ctx, key, blockKey, msg)
+	})
+	if err != nil {
+		if err != circuitbreaker.ErrOpenState {
+			simplelog.ErrLogger.Println("eval script1 ", scriptSha1, "error", err)
+		}
+		result = r.fallback(func(local TtlRateLimiter) *Result {
+			return local.ShouldBlock2Context(ctx, key, blockKey, msg)
+		}, msg)
+	}
+	return r.observeResult(result)
+}
 
-	if r.hashTag != "" {
-		key = key + r.hashTag
-		blockKey = blockKey + r.hashTag
+// execute redis call through the circuit breaker, return circuitbreaker.ErrOpenState if it's open.
+// Errors caused by ctx of the caller being done are not counted as failures of redis.
+func (r *redisTtlRateLimiter) execute(ctx context.Context, task func(ctx context.Context) (*Result, error)) (*Result, error) {
+	breaker, ok := r.breaker.(circuitbreaker.ContextInterface)
+	if !ok {
+		breaker = contextBreaker{r.breaker}
 	}
+	return circuitbreaker.Execute(breaker, ctx, task, nil)
+}
+
+// contextBreaker adapt circuitbreaker.Interface to circuitbreaker.ContextInterface
+type contextBreaker struct {
+	circuitbreaker.Interface
+}
+
+func (c contextBreaker) DoCtx(ctx context.Context, task func(ctx context.Context) error, onError func(error), onOpen func()) {
+	c.Do(func() error {
+		err := task(ctx)
+		if err != nil && ctx.Err() != nil {
+			// the caller gives up, it's neither success nor failure
+			return circuitbreaker.Ignored(err)
+		}
+		return err
+	}, onError, onOpen)
+}
+
+func (r *redisTtlRateLimiter) shouldBlock2(ctx context.Context, key string, blockKey string, msg string) (*Result, error) {
+	ctx, cancel := r.redisContext(ctx)
+	defer cancel()
 
 	//local key = KEYS[1]
 	//local keyb = KEYS[2]
+	//local keys = KEYS[3]
+	//local keyi = KEYS[4]
 	//local cap = tonumber(ARGV[1])
 	//local win = tonumber(ARGV[2])
 	//local exp = tonumber(ARGV[3])
 	//local now = tonumber(ARGV[4])
 	//local msg = ARGV[5]
+	//local mem = tonumber(ARGV[6])
+	//local blk = ARGV[7]
 
 	now := time.Now().UnixNano() / int64(time.Second)
 
-	raw, err := r.redisClient.WithContext(ctx).EvalSha(
-		scriptSha1,
-		[]string{key, blockKey},
+	schedule, memory := getEscalation(r.params)
+	args := make([]interface{}, 0, 7+len(schedule))
+	args = append(args,
 		r.params.GetCapacity(),
 		r.params.GetWindowSizeSeconds(),
 		r.params.GetTimeoutSeconds(),
 		now,
 		msg,
+		memory,
+		blockKey,
+	)
+	for _, timeout := range schedule {
+		args = append(args, timeout)
+	}
+
+	raw, err := r.redisClient.WithContext(ctx).EvalSha(
+		scriptSha1,
+		[]string{r.recordKey(key), r.blockKey(blockKey), r.strikeKey(blockKey), r.blockedIndexKey()},
+		args...,
 	).Result()
 
 	if err != nil {
-		simplelog.ErrLogger.Println("eval script1 ", scriptSha1, "error", err)
-		return result
+		return nil, err
 	}
 
-	arr := raw.([]interface{})
-	result.Block = arr[0].(int64) == 1
-	result.Triggered = arr[1].(int64) == 1
-	result.Ttl = int(arr[2].(int64))
-	result.Msg = arr[3].(string)
-	return result
+	result := &Result{}
+	parseResult(raw, result)
+	return result, nil
+
+}
 
+// Result when redis is unavailable
+func (r *redisTtlRateLimiter) fallback(localFn func(local TtlRateLimiter) *Result, msg string) *Result {
+	switch r.failurePolicy {
+	case FailClosed:
+		return &Result{
+			Block: true,
+			Ttl:   r.params.GetTimeoutSeconds(),
+			Msg:   msg,
+		}
+	case FailLocal:
+		return localFn(r.local)
+	default:
+		return &Result{}
+	}
+}
+
+func (r *redisTtlRateLimiter) redisContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.redisTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.redisTimeout)
 }
 
 func (r *redisTtlRateLimiter) ShouldBlock(key string, msg string) *Result {
@@ -256,6 +447,163 @@ func (r *redisTtlRateLimiter) GetCapacity() int {
 	return r.params.GetCapacity()
 }
 
+func (r *redisTtlRateLimiter) Unblock(blockKey string) error {
+	return r.UnblockContext(context.Background(), blockKey)
+}
+
+func (r *redisTtlRateLimiter) Reset(key string) error {
+	return r.ResetContext(context.Background(), key)
+}
+
+func (r *redisTtlRateLimiter) ListBlocked(cursor uint64, count int64) ([]*BlockedKey, uint64, error) {
+	return r.ListBlockedContext(context.Background(), cursor, count)
+}
+
+func (r *redisTtlRateLimiter) Inspect(key string) (*Inspection, error) {
+	return r.InspectContext(context.Background(), key)
+}
+
+func (r *redisTtlRateLimiter) UnblockContext(ctx context.Context, blockKey string) error {
+	pipe := r.redisClient.WithContext(ctx).Pipeline()
+	pipe.Del(r.blockKey(blockKey), r.strikeKey(blockKey))
+	pipe.ZRem(r.blockedIndexKey(), blockKey)
+	_, err := pipe.Exec()
+	return err
+}
+
+func (r *redisTtlRateLimiter) ResetContext(ctx context.Context, key string) error {
+	return r.redisClient.WithContext(ctx).Del(r.recordKey(key)).Err()
+}
+
+// ListBlockedContext ZSCAN the index of blocked keys, same as ZSCAN, a key may be returned multiple times.
+// Blocks written by older versions are not indexed, so they are not listed, but they still block.
+func (r *redisTtlRateLimiter) ListBlockedContext(ctx context.Context, cursor uint64, count int64) ([]*BlockedKey, uint64, error) {
+	client := r.redisClient.WithContext(ctx)
+	// member, score, member, score...
+	members, nextCursor, err := client.ZScan(r.blockedIndexKey(), cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(members) == 0 {
+		return nil, nextCursor, nil
+	}
+
+	blockKeys := make([]string, 0, len(members)/2)
+	for i := 0; i < len(members); i += 2 {
+		blockKeys = append(blockKeys, members[i])
+	}
+
+	pipe := client.Pipeline()
+	ttlCmds := make([]*redis.DurationCmd, len(blockKeys))
+	msgCmds := make([]*redis.StringCmd, len(blockKeys))
+	for i, blockKey := range blockKeys {
+		ttlCmds[i] = pipe.TTL(r.blockKey(blockKey))
+		msgCmds[i] = pipe.Get(r.blockKey(blockKey))
+	}
+	// errors are checked one by one
+	_, _ = pipe.Exec()
+
+	keys := make([]*BlockedKey, 0, len(blockKeys))
+	for i, blockKey := range blockKeys {
+		ttl, err := ttlCmds[i].Result()
+		if err != nil || ttl < 0 {
+			// expired or unblocked
+			continue
+		}
+		msg, err := msgCmds[i].Result()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, &BlockedKey{
+			BlockKey: blockKey,
+			Ttl:      int(ttl / time.Second),
+			Msg:      msg,
+		})
+	}
+	return keys, nextCursor, nil
+}
+
+func (r *redisTtlRateLimiter) InspectContext(ctx context.Context, key string) (*Inspection, error) {
+	windowSize := int64(r.params.GetWindowSizeSeconds())
+	nowSec := time.Now().Unix()
+
+	pipe := r.redisClient.WithContext(ctx).Pipeline()
+	recordsCmd := pipe.LRange(r.recordKey(key), 0, -1)
+	ttlCmd := pipe.TTL(r.blockKey(key))
+	msgCmd := pipe.Get(r.blockKey(key))
+	strikesCmd := pipe.Get(r.strikeKey(key))
+	// errors are checked one by one
+	_, _ = pipe.Exec()
+	if err := recordsCmd.Err(); err != nil {
+		return nil, err
+	}
+	if err := ttlCmd.Err(); err != nil {
+		return nil, err
+	}
+	if err := msgCmd.Err(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err := strikesCmd.Err(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	inspection := &Inspection{Capacity: r.params.GetCapacity()}
+
+	timestamps := make([]int64, 0, len(recordsCmd.Val()))
+	for _, record := range recordsCmd.Val() {
+		ts, err := strconv.ParseInt(record, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		timestamps = append(timestamps, ts)
+	}
+	inspection.Count = countInWindow(timestamps, nowSec, windowSize, inspection.Capacity)
+
+	if ttl := ttlCmd.Val(); ttl >= 0 && msgCmd.Err() == nil {
+		inspection.Block = true
+		inspection.Ttl = int(ttl / time.Second)
+		inspection.Msg = msgCmd.Val()
+	}
+	if strikesCmd.Err() == nil {
+		strikes, err := strikesCmd.Int()
+		if err != nil {
+			return nil, err
+		}
+		inspection.Strikes = strikes
+	}
+	return inspection, nil
+}
+
+// redis key of requests records
+func (r *redisTtlRateLimiter) recordKey(key string) string {
+	return prefix + key + r.hashTag
+}
+
+// redis key of blocking, it has no prefix for compatibility with blocks written by older versions
+func (r *redisTtlRateLimiter) blockKey(blockKey string) string {
+	return blockKey + suffix + r.hashTag
+}
+
+// redis key of strikes in escalation mode
+func (r *redisTtlRateLimiter) strikeKey(blockKey string) string {
+	return prefix + blockKey + strikeSuffix + r.hashTag
+}
+
+// redis key of the index of blocked keys, block keys have no prefix, so they are listed through it
+func (r *redisTtlRateLimiter) blockedIndexKey() string {
+	return blockedIndex + r.hashTag
+}
+
+// parse {block, triggered, ttl, msg, strikes} returned by script1 and script2
+func parseResult(raw interface{}, result *Result) {
+	arr := raw.([]interface{})
+	result.Block = arr[0].(int64) == 1
+	result.Triggered = arr[1].(int64) == 1
+	result.Ttl = int(arr[2].(int64))
+	result.Msg = arr[3].(string)
+	result.Strikes = int(arr[4].(int64))
+}
+
 func LoadScript(redisClient *redis.Client) {
 	loadScript(redisClient, script1, func(scriptSha string) {
 		scriptSha1 = scriptSha
@@ -263,6 +611,21 @@ func LoadScript(redisClient *redis.Client) {
 	loadScript(redisClient, script2, func(scriptSha string) {
 		scriptSha2 = scriptSha
 	})
+	loadScript(redisClient, tokenBucketScript, func(scriptSha string) {
+		tokenBucketScriptSha = scriptSha
+	})
+	loadScript(redisClient, fixedWindowScript, func(scriptSha string) {
+		fixedWindowScriptSha = scriptSha
+	})
+	loadScript(redisClient, slidingWindowScript, func(scriptSha string) {
+		slidingWindowScriptSha = scriptSha
+	})
+	loadScript(redisClient, gcraScript, func(scriptSha string) {
+		gcraScriptSha = scriptSha
+	})
+	loadScript(redisClient, compositeScript, func(scriptSha string) {
+		compositeScriptSha = scriptSha
+	})
 }
 
 func loadScript(redisClient *redis.Client, script string, callback func(scriptSha string)) {
@@ -272,3 +635,19 @@ func loadScript(redisClient *redis.Client, script string, callback func(scriptSh
 	}
 	callback(sha)
 }
+
+// eval script which returns {acquired, delay}, delay is in microseconds, -1 means never be acquired.
+func evalAcquireScript(ctx context.Context, redisClient *redis.Client, scriptSha string, key string, args ...interface{}) (bool, time.Duration, error) {
+	raw, err := redisClient.WithContext(ctx).EvalSha(scriptSha, []string{key}, args...).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	arr := raw.([]interface{})
+	acquired := arr[0].(int64) == 1
+	delay := arr[1].(int64)
+	if delay < 0 {
+		return acquired, delayForever, nil
+	}
+	return acquired, time.Duration(delay) * time.Microsecond, nil
+}
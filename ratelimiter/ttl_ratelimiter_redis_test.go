@@ -18,8 +18,12 @@
 package ratelimiter
 
 import (
+	"context"
+	"github.com/chanjarster/gears/circuitbreaker"
 	"github.com/chanjarster/gears/confs"
+	"github.com/go-redis/redis/v7"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -336,3 +340,268 @@ func Test_redisTtlRateLimiter_IsBlocked(t *testing.T) {
 	}
 
 }
+
+// count commands sent to redis
+type countingHook struct {
+	count int32
+}
+
+func (c *countingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	atomic.AddInt32(&c.count, 1)
+	return ctx, nil
+}
+
+func (c *countingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (c *countingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	atomic.AddInt32(&c.count, int32(len(cmds)))
+	return ctx, nil
+}
+
+func (c *countingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// a redis client can never connect
+func newUnavailableRedisClient() (*redis.Client, *countingHook) {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	hook := &countingHook{}
+	redisClient.AddHook(hook)
+	return redisClient, hook
+}
+
+func Test_redisTtlRateLimiter_FailurePolicy(t *testing.T) {
+	params := NewFixedTtlRateLimiterParams(4, 10, 5)
+
+	tests := []struct {
+		name  string
+		opts  []TtlOption
+		wants []Result
+	}{
+		{
+			name:  "fail open",
+			opts:  nil,
+			wants: []Result{{}, {}, {}},
+		},
+		{
+			name: "fail closed",
+			opts: []TtlOption{WithFailurePolicy(FailClosed)},
+			wants: []Result{
+				{Block: true, Ttl: 5, Msg: "foo"},
+				{Block: true, Ttl: 5, Msg: "foo"},
+				{Block: true, Ttl: 5, Msg: "foo"},
+			},
+		},
+		{
+			name: "fail local",
+			opts: []TtlOption{WithFailurePolicy(FailLocal), WithExpectedReplicas(2)},
+			wants: []Result{
+				{},
+				{},
+				{Block: true, Triggered: true, Ttl: 5, Msg: "foo"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redisClient, _ := newUnavailableRedisClient()
+			defer redisClient.Close()
+
+			r := NewRedisTtlRateLimiter(redisClient, params, tt.opts...)
+			for i, want := range tt.wants {
+				if got := r.ShouldBlock("foo", "foo"); *got != want {
+					t.Errorf("#%d ShouldBlock() = %+v, want %+v", i, *got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_redisTtlRateLimiter_CircuitBreaker(t *testing.T) {
+	redisClient, hook := newUnavailableRedisClient()
+	defer redisClient.Close()
+
+	r := NewRedisTtlRateLimiter(redisClient, NewFixedTtlRateLimiterParams(1, 10, 5),
+		WithFailurePolicy(FailClosed),
+		WithCircuitBreaker(circuitbreaker.NewSyncCircuitBreaker(2, time.Minute)),
+		WithRedisTimeout(50*time.Millisecond),
+	)
+
+	for i := 0; i < 5; i++ {
+		if got := r.ShouldBlock("foo", "foo"); !got.Block {
+			t.Errorf("#%d ShouldBlock() = %+v, want blocked", i, *got)
+		}
+	}
+	if got := r.IsBlocked("foo"); !got.Block {
+		t.Errorf("IsBlocked() = %+v, want blocked", *got)
+	}
+	// circuit breaker is opened after 2 failures
+	if got := atomic.LoadInt32(&hook.count); got != 2 {
+		t.Errorf("redis commands = %v, want %v", got, 2)
+	}
+}
+
+func Test_redisTtlRateLimiter_CircuitBreaker_callerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		breaker func(cb *circuitbreaker.SyncCircuitBreaker) circuitbreaker.Interface
+	}{
+		{
+			name: "context interface",
+			breaker: func(cb *circuitbreaker.SyncCircuitBreaker) circuitbreaker.Interface {
+				return cb
+			},
+		},
+		{
+			name: "interface",
+			breaker: func(cb *circuitbreaker.SyncCircuitBreaker) circuitbreaker.Interface {
+				return struct{ circuitbreaker.Interface }{cb}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redisClient, _ := newUnavailableRedisClient()
+			defer redisClient.Close()
+
+			cb := circuitbreaker.NewSyncCircuitBreaker(2, time.Minute)
+			r := NewRedisTtlRateLimiter(redisClient, NewFixedTtlRateLimiterParams(1, 10, 5),
+				WithFailurePolicy(FailClosed),
+				WithCircuitBreaker(tt.breaker(cb)),
+			)
+			for i := 0; i < 3; i++ {
+				if got := r.ShouldBlockContext(ctx, "foo", "foo"); !got.Block {
+					t.Errorf("#%d ShouldBlockContext() = %+v, want blocked", i, *got)
+				}
+				r.IsBlockedContext(ctx, "foo")
+			}
+			// canceled by the caller is not a failure of redis
+			if got, want := cb.State(), circuitbreaker.StateClosed; got != want {
+				t.Errorf("State() = %v, want %v", got, want)
+			}
+
+			r.ShouldBlock("foo", "foo")
+			r.ShouldBlock("foo", "foo")
+			if got, want := cb.State(), circuitbreaker.StateOpen; got != want {
+				t.Errorf("State() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func Test_redisTtlRateLimiter_CircuitBreaker_callerContextHalfOpen(t *testing.T) {
+	redisClient, _ := newUnavailableRedisClient()
+	defer redisClient.Close()
+
+	cb := circuitbreaker.NewCircuitBreaker(circuitbreaker.WithFailureThreshold(1),
+		circuitbreaker.WithResetTimeout(50*time.Millisecond))
+	r := NewRedisTtlRateLimiter(redisClient, NewFixedTtlRateLimiterParams(1, 10, 5),
+		WithCircuitBreaker(struct{ circuitbreaker.Interface }{cb}),
+	)
+	r.ShouldBlock("foo", "foo")
+	time.Sleep(60 * time.Millisecond)
+	if got, want := cb.State(), circuitbreaker.StateHalfOpen; got != want {
+		t.Fatalf("State() = %v, want %v", got, want)
+	}
+
+	// redis is still unavailable, trial call canceled by the caller should not close the circuit breaker
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.ShouldBlockContext(ctx, "foo", "foo")
+	if got, want := cb.State(), circuitbreaker.StateHalfOpen; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
+	}
+
+	r.ShouldBlock("foo", "foo")
+	if got, want := cb.State(), circuitbreaker.StateOpen; got != want {
+		t.Errorf("State() = %v, want %v", got, want)
+	}
+}
+
+func Test_scaledTtlRateLimiterParams(t *testing.T) {
+	tests := []struct {
+		capacity int
+		replicas int
+		want     int
+	}{
+		{10, 1, 10},
+		{10, 0, 10},
+		{10, 3, 3},
+		{2, 3, 1},
+		{0, 3, 0},
+	}
+	for _, tt := range tests {
+		s := &scaledTtlRateLimiterParams{params: NewFixedTtlRateLimiterParams(tt.capacity, 1, 1), replicas: tt.replicas}
+		if got := s.GetCapacity(); got != tt.want {
+			t.Errorf("GetCapacity() of %v/%v = %v, want %v", tt.capacity, tt.replicas, got, tt.want)
+		}
+	}
+}
+
+func Test_redisTtlRateLimiter_blockKeyLayout(t *testing.T) {
+
+	val, hit := os.LookupEnv("INTEGRATION_TEST")
+	if !hit || val != "true" {
+		t.Skip("skip integration test")
+	}
+
+	redisClient := confs.NewRedisClient(&confs.RedisConf{
+		Host:     "localhost",
+		Port:     6379,
+		Password: "",
+		Pool:     10,
+		MinIdle:  1,
+	}, nil)
+	redisClient.FlushAll()
+	defer redisClient.Close()
+
+	LoadScript(redisClient)
+	params := NewFixedTtlRateLimiterParams(1, 1, 10)
+
+	// blocks written by older versions are "<blockKey>:bl"
+	redisClient.Set("legacy:bl", "legacy msg", 10*time.Second)
+	redisClient.Set("legacy:bl{foo}", "legacy msg", 10*time.Second)
+	// keys of the application which look like block keys
+	redisClient.Set("foo:bl", "not a block", 10*time.Second)
+	redisClient.Set("foo:bl{foo}", "not a block", 10*time.Second)
+
+	for _, r := range []TtlRateLimiter{
+		NewRedisTtlRateLimiter(redisClient, params),
+		NewRedisTtlRateLimiterCluster(redisClient, params, "foo"),
+	} {
+		if got := r.IsBlocked("legacy"); !got.Block || got.Msg != "legacy msg" {
+			t.Errorf("IsBlocked() = %+v, want blocked with legacy msg", got)
+		}
+		r.ShouldBlock("bar", "bar msg")
+		r.ShouldBlock("bar", "bar msg")
+
+		// only blocks written by this version are listed
+		admin := r.(TtlRateLimiterAdmin)
+		keys, _, err := admin.ListBlocked(0, 100)
+		if err != nil || len(keys) != 1 || keys[0].BlockKey != "bar" {
+			t.Errorf("ListBlocked() = %v, %v, want [bar]", keys, err)
+		}
+		if err := admin.Unblock("bar"); err != nil {
+			t.Errorf("Unblock() = %v, want nil", err)
+		}
+		if keys, _, err := admin.ListBlocked(0, 100); err != nil || len(keys) != 0 {
+			t.Errorf("ListBlocked() = %v, %v, want empty", keys, err)
+		}
+
+		if err := admin.Unblock("legacy"); err != nil {
+			t.Errorf("Unblock() = %v, want nil", err)
+		}
+		if got := r.IsBlocked("legacy"); got.Block {
+			t.Errorf("IsBlocked() = %+v, want not blocked", got)
+		}
+	}
+}
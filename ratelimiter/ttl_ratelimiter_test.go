@@ -0,0 +1,380 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"github.com/chanjarster/gears/confs"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ttl rate limiter params can be changed at runtime
+type mutableTtlParams struct {
+	capacity         int
+	windowSizeSec    int
+	timeoutSec       int
+	blockScheduleSec []int // escalation mode
+	strikeMemorySec  int   // escalation mode
+}
+
+func (m *mutableTtlParams) GetBlockScheduleSeconds() []int {
+	return m.blockScheduleSec
+}
+
+func (m *mutableTtlParams) GetStrikeMemorySeconds() int {
+	return m.strikeMemorySec
+}
+
+func (m *mutableTtlParams) GetWindowSizeSeconds() int {
+	return m.windowSizeSec
+}
+
+func (m *mutableTtlParams) GetCapacity() int {
+	return m.capacity
+}
+
+func (m *mutableTtlParams) GetTimeoutSeconds() int {
+	return m.timeoutSec
+}
+
+type ttlStep struct {
+	sleep     time.Duration
+	update    func(params *mutableTtlParams) // change params before calling
+	isBlocked bool                           // call IsBlocked(blockKey) instead of ShouldBlock2
+	key       string
+	blockKey  string // empty means ShouldBlock(key, msg)
+	msg       string
+	want      Result
+}
+
+type ttlScenario struct {
+	name   string
+	params mutableTtlParams
+	steps  []ttlStep
+}
+
+var ttlScenarios = []ttlScenario{
+	{
+		name:   "same key",
+		params: mutableTtlParams{capacity: 1, windowSizeSec: 1, timeoutSec: 1},
+		steps: []ttlStep{
+			{key: "foo", msg: "foo1", want: Result{}},
+			{key: "foo", msg: "foo2", want: Result{Block: true, Triggered: true, Ttl: 1, Msg: "foo2"}},
+			{key: "foo", msg: "foo3", want: Result{Block: true, Triggered: false, Ttl: 1, Msg: "foo2"}},
+			{sleep: 2 * time.Second, key: "foo", msg: "foo4", want: Result{}},
+			{key: "bar", msg: "bar1", want: Result{}},
+		},
+	},
+	{
+		name:   "shared block key",
+		params: mutableTtlParams{capacity: 1, windowSizeSec: 1, timeoutSec: 1},
+		steps: []ttlStep{
+			{key: "bar", blockKey: "bar", msg: "bar1", want: Result{}},
+			{key: "bar", blockKey: "bar", msg: "bar2", want: Result{Block: true, Triggered: true, Ttl: 1, Msg: "bar2"}},
+			{key: "zoo", blockKey: "bar", msg: "zoo", want: Result{Block: true, Triggered: false, Ttl: 1, Msg: "bar2"}},
+			{key: "zoo", blockKey: "zoo", msg: "zoo", want: Result{}},
+		},
+	},
+	{
+		name:   "is blocked",
+		params: mutableTtlParams{capacity: 1, windowSizeSec: 1, timeoutSec: 3},
+		steps: []ttlStep{
+			{isBlocked: true, blockKey: "bar", want: Result{}},
+			{key: "bar", msg: "bar msg", want: Result{}},
+			{key: "bar", msg: "bar msg", want: Result{Block: true, Triggered: true, Ttl: 3, Msg: "bar msg"}},
+			{isBlocked: true, blockKey: "bar", want: Result{Block: true, Ttl: 3, Msg: "bar msg"}},
+			{sleep: time.Second, isBlocked: true, blockKey: "bar", want: Result{Block: true, Ttl: 2, Msg: "bar msg"}},
+			{isBlocked: true, blockKey: "foo", want: Result{}},
+			{sleep: 3 * time.Second, isBlocked: true, blockKey: "bar", want: Result{}},
+		},
+	},
+	{
+		name:   "sliding window",
+		params: mutableTtlParams{capacity: 2, windowSizeSec: 2, timeoutSec: 1},
+		steps: []ttlStep{
+			{key: "foo", msg: "foo", want: Result{}},
+			{key: "foo", msg: "foo", want: Result{}},
+			{sleep: 3 * time.Second, key: "foo", msg: "foo", want: Result{}},
+			{key: "foo", msg: "foo", want: Result{}},
+			{key: "foo", msg: "foo", want: Result{Block: true, Triggered: true, Ttl: 1, Msg: "foo"}},
+		},
+	},
+	{
+		name:   "dynamic params",
+		params: mutableTtlParams{capacity: 1, windowSizeSec: 10, timeoutSec: 2},
+		steps: []ttlStep{
+			{key: "foo", msg: "foo1", want: Result{}},
+			{key: "foo", msg: "foo2", want: Result{Block: true, Triggered: true, Ttl: 2, Msg: "foo2"}},
+			// params not set, never block
+			{update: func(p *mutableTtlParams) { p.capacity = 0 }, key: "foo", msg: "foo3", want: Result{}},
+			{isBlocked: true, blockKey: "foo", want: Result{Block: true, Ttl: 2, Msg: "foo2"}},
+			// capacity increased, 1 request recorded before
+			{sleep: 3 * time.Second, update: func(p *mutableTtlParams) { p.capacity = 3 }, key: "foo", msg: "foo4", want: Result{}},
+			{key: "foo", msg: "foo5", want: Result{}},
+			{key: "foo", msg: "foo6", want: Result{Block: true, Triggered: true, Ttl: 2, Msg: "foo6"}},
+			// capacity decreased
+			{sleep: 3 * time.Second, update: func(p *mutableTtlParams) { p.capacity = 1 }, key: "foo", msg: "foo7", want: Result{Block: true, Triggered: true, Ttl: 2, Msg: "foo7"}},
+		},
+	},
+	{
+		name: "escalation",
+		params: mutableTtlParams{capacity: 1, windowSizeSec: 10, timeoutSec: 2,
+			blockScheduleSec: []int{2, 3, 4}, strikeMemorySec: 2},
+		steps: []ttlStep{
+			{key: "foo", msg: "foo1", want: Result{}},
+			{key: "foo", msg: "foo2", want: Result{Block: true, Triggered: true, Ttl: 2, Msg: "foo2", Strikes: 1}},
+			{key: "foo", msg: "foo3", want: Result{Block: true, Ttl: 2, Msg: "foo2", Strikes: 1}},
+			// 2nd strike
+			{sleep: 2500 * time.Millisecond, key: "foo", msg: "foo4", want: Result{Block: true, Triggered: true, Ttl: 3, Msg: "foo4", Strikes: 2}},
+			// requests recorded expired, but strikes are remembered
+			{sleep: 3500 * time.Millisecond, key: "foo", msg: "foo5", want: Result{}},
+			{key: "foo", msg: "foo6", want: Result{Block: true, Triggered: true, Ttl: 4, Msg: "foo6", Strikes: 3}},
+			{isBlocked: true, blockKey: "foo", want: Result{Block: true, Ttl: 4, Msg: "foo6", Strikes: 3}},
+			// strikes are forgotten
+			{sleep: 7 * time.Second, key: "foo", msg: "foo7", want: Result{}},
+			{key: "foo", msg: "foo8", want: Result{Block: true, Triggered: true, Ttl: 2, Msg: "foo8", Strikes: 1}},
+		},
+	},
+}
+
+// testTtlRateLimiterConformance all TtlRateLimiter implementations must pass.
+//
+//	newLimiter: create a fresh rate limiter, no keys recorded
+//	sleep: let time go by for the rate limiter
+//	ttlSlack: Ttl of an existing blocking can be less than expected by ttlSlack seconds, for clocks can't be controlled
+func testTtlRateLimiterConformance(t *testing.T, newLimiter func(params TtlRateLimiterParams) TtlRateLimiter,
+	sleep func(d time.Duration), ttlSlack int) {
+	for _, sc := range ttlScenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			params := sc.params
+			r := newLimiter(&params)
+			for i, step := range sc.steps {
+				if step.sleep != 0 {
+					sleep(step.sleep)
+				}
+				if step.update != nil {
+					step.update(&params)
+				}
+				var got *Result
+				switch {
+				case step.isBlocked:
+					got = r.IsBlocked(step.blockKey)
+				case step.blockKey == "":
+					got = r.ShouldBlock(step.key, step.msg)
+				default:
+					got = r.ShouldBlock2(step.key, step.blockKey, step.msg)
+				}
+				want := step.want
+				if got.Block && !got.Triggered && got.Ttl < want.Ttl && got.Ttl >= want.Ttl-ttlSlack {
+					want.Ttl = got.Ttl
+				}
+				if *got != want {
+					t.Errorf("step #%d got = %+v, want %+v", i, *got, step.want)
+				}
+			}
+		})
+	}
+}
+
+// testTtlRateLimiterAdminConformance all TtlRateLimiterAdmin implementations must pass.
+func testTtlRateLimiterAdminConformance(t *testing.T, newLimiter func(params TtlRateLimiterParams) TtlRateLimiter, ttlSlack int) {
+	// blocking ttl is 5 seconds
+	fixTtl := func(ttl int) int {
+		if ttl < 5 && ttl >= 5-ttlSlack {
+			return 5
+		}
+		return ttl
+	}
+
+
+	t.Run("unblock reset inspect", func(t *testing.T) {
+		r := newLimiter(NewFixedTtlRateLimiterParams(1, 10, 5))
+		admin := r.(TtlRateLimiterAdmin)
+
+		got, err := admin.Inspect("foo")
+		if want := (Inspection{Capacity: 1}); err != nil || *got != want {
+			t.Errorf("Inspect() = %+v, %v, want %+v", got, err, want)
+		}
+
+		r.ShouldBlock("foo", "foo1")
+		r.ShouldBlock("foo", "foo2")
+		got, err = admin.Inspect("foo")
+		if err == nil {
+			got.Ttl = fixTtl(got.Ttl)
+		}
+		if want := (Inspection{Count: 1, Capacity: 1, Block: true, Ttl: 5, Msg: "foo2"}); err != nil || *got != want {
+			t.Errorf("Inspect() = %+v, %v, want %+v", got, err, want)
+		}
+
+		// requests are still recorded, blocking again
+		if err := admin.Unblock("foo"); err != nil {
+			t.Errorf("Unblock() error = %v", err)
+		}
+		if got := r.IsBlocked("foo"); got.Block {
+			t.Errorf("IsBlocked() = %+v, want not blocked", got)
+		}
+		if got := r.ShouldBlock("foo", "foo3"); !got.Triggered {
+			t.Errorf("ShouldBlock() = %+v, want triggered", got)
+		}
+
+		if err := admin.Unblock("foo"); err != nil {
+			t.Errorf("Unblock() error = %v", err)
+		}
+		if err := admin.Reset("foo"); err != nil {
+			t.Errorf("Reset() error = %v", err)
+		}
+		if got := r.ShouldBlock("foo", "foo4"); got.Block {
+			t.Errorf("ShouldBlock() = %+v, want not blocked", got)
+		}
+	})
+
+	t.Run("list blocked", func(t *testing.T) {
+		r := newLimiter(NewFixedTtlRateLimiterParams(1, 10, 5))
+		admin := r.(TtlRateLimiterAdmin)
+
+		for _, key := range []string{"a", "b", "c", "d"} {
+			r.ShouldBlock(key, key+" msg")
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			r.ShouldBlock(key, key+" msg")
+		}
+
+		got := make(map[string]BlockedKey)
+		cursor := uint64(0)
+		for i := 0; i < 100; i++ {
+			keys, nextCursor, err := admin.ListBlocked(cursor, 2)
+			if err != nil {
+				t.Fatalf("ListBlocked() error = %v", err)
+			}
+			for _, key := range keys {
+				key.Ttl = fixTtl(key.Ttl)
+				got[key.BlockKey] = *key
+			}
+			if cursor = nextCursor; cursor == 0 {
+				break
+			}
+		}
+		want := map[string]BlockedKey{
+			"a": {BlockKey: "a", Ttl: 5, Msg: "a msg"},
+			"b": {BlockKey: "b", Ttl: 5, Msg: "b msg"},
+			"c": {BlockKey: "c", Ttl: 5, Msg: "c msg"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ListBlocked() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+// a clock for tests, time goes by only when sleep is called
+type mockTtlClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (m *mockTtlClock) Now() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.now
+}
+
+func (m *mockTtlClock) Sleep(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.now = m.now.Add(d)
+}
+
+func Test_syncTtlRateLimiter_Conformance(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600000000, 0)}
+	testTtlRateLimiterConformance(t, func(params TtlRateLimiterParams) TtlRateLimiter {
+		return NewSyncTtlRateLimiter(params, clock.Now)
+	}, clock.Sleep, 0)
+	testTtlRateLimiterAdminConformance(t, func(params TtlRateLimiterParams) TtlRateLimiter {
+		return NewSyncTtlRateLimiter(params, clock.Now)
+	}, 0)
+}
+
+func Test_redisTtlRateLimiter_Conformance(t *testing.T) {
+
+	val, hit := os.LookupEnv("INTEGRATION_TEST")
+	if !hit || val != "true" {
+		t.Skip("skip integration test")
+	}
+
+	redisClient := confs.NewRedisClient(&confs.RedisConf{
+		Host:     "localhost",
+		Port:     6379,
+		Password: "",
+		Pool:     10,
+		MinIdle:  1,
+	}, nil)
+	defer redisClient.Close()
+
+	LoadScript(redisClient)
+	testTtlRateLimiterConformance(t, func(params TtlRateLimiterParams) TtlRateLimiter {
+		redisClient.FlushAll()
+		return NewRedisTtlRateLimiter(redisClient, params)
+	}, time.Sleep, 1)
+	testTtlRateLimiterAdminConformance(t, func(params TtlRateLimiterParams) TtlRateLimiter {
+		redisClient.FlushAll()
+		return NewRedisTtlRateLimiter(redisClient, params)
+	}, 1)
+}
+
+func Test_redisTtlRateLimiterCluster_Conformance(t *testing.T) {
+
+	val, hit := os.LookupEnv("INTEGRATION_TEST")
+	if !hit || val != "true" {
+		t.Skip("skip integration test")
+	}
+
+	redisClient := confs.NewRedisClient(&confs.RedisConf{
+		Host:     "localhost",
+		Port:     6379,
+		Password: "",
+		Pool:     10,
+		MinIdle:  1,
+	}, nil)
+	defer redisClient.Close()
+
+	LoadScript(redisClient)
+	testTtlRateLimiterAdminConformance(t, func(params TtlRateLimiterParams) TtlRateLimiter {
+		redisClient.FlushAll()
+		return NewRedisTtlRateLimiterCluster(redisClient, params, "tag")
+	}, 1)
+}
+
+func Test_syncTtlRateLimiter_sweep(t *testing.T) {
+	clock := &mockTtlClock{now: time.Unix(1600000000, 0)}
+	r := NewSyncTtlRateLimiter(NewFixedTtlRateLimiterParams(1, 1, 1), clock.Now).(*syncTtlRateLimiter)
+
+	r.ShouldBlock("foo", "foo")
+	r.ShouldBlock("foo", "foo")
+	r.ShouldBlock("bar", "bar")
+	if len(r.records) != 2 || len(r.blocks) != 1 {
+		t.Errorf("records = %v, blocks = %v, want 2, 1", len(r.records), len(r.blocks))
+	}
+
+	clock.Sleep(time.Minute)
+	r.ShouldBlock("zoo", "zoo")
+	if len(r.records) != 1 || len(r.blocks) != 0 {
+		t.Errorf("records = %v, blocks = %v, want 1, 0", len(r.records), len(r.blocks))
+	}
+}
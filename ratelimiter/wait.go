@@ -0,0 +1,123 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"github.com/chanjarster/gears/simplelog"
+	"time"
+)
+
+// ErrExceedCapacity returned by WaitN when n > capacity, it will never be satisfied
+var ErrExceedCapacity = errors.New("ratelimiter: n exceeds capacity")
+
+// delay returned by tryAcquireNFunc if permissions will never be available until configuration changes
+const delayForever time.Duration = -1
+
+// try to acquire n permissions, if failed return how long to wait before next try.
+type tryAcquireNFunc func(n int) (acquired bool, delay time.Duration, err error)
+
+// waitN blocks until tryAcquireN succeeds or ctx is done.
+// It sleeps exactly the delay returned by tryAcquireN instead of polling.
+func waitN(ctx context.Context, n int, tryAcquireN tryAcquireNFunc) error {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		acquired, delay, err := tryAcquireN(n)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+
+		if delay == delayForever {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(delay)
+		} else {
+			timer.Reset(delay)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+type localTryAcquirer interface {
+	tryAcquireN(n int) (bool, time.Duration, error)
+}
+
+type remoteTryAcquirer interface {
+	tryAcquireN(ctx context.Context, n int) (bool, time.Duration, error)
+}
+
+// implemented by limiters embedding observable
+type acquireObserver interface {
+	observe(n int, acquired bool) bool
+}
+
+// TryAcquire acquire 1 permission from limiter, if rejected also return how long to wait before retry.
+// retryAfter is 0 if the limiter doesn't know it, e.g. custom Interface implementations, or permissions will never be
+// available until configuration changes.
+//
+// Like Acquire, redis backed limiters let the request pass when redis is unavailable.
+func TryAcquire(ctx context.Context, limiter Interface) (acquired bool, retryAfter time.Duration) {
+	var (
+		delay time.Duration
+		err   error
+	)
+	switch l := limiter.(type) {
+	case localTryAcquirer:
+		acquired, delay, err = l.tryAcquireN(1)
+	case remoteTryAcquirer:
+		acquired, delay, err = l.tryAcquireN(ctx, 1)
+	default:
+		return limiter.Acquire(), 0
+	}
+	if o, ok := limiter.(acquireObserver); ok {
+		// fail open if error is not ErrExceedCapacity
+		o.observe(1, acquired || (err != nil && err != ErrExceedCapacity))
+	}
+	if err == ErrExceedCapacity {
+		return false, 0
+	}
+	if err != nil {
+		simplelog.ErrLogger.Println("ratelimiter try acquire error", err)
+		return true, 0
+	}
+	if acquired || delay == delayForever {
+		return acquired, 0
+	}
+	return false, delay
+}
@@ -0,0 +1,66 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type acquireFunc func() bool
+
+func (f acquireFunc) Acquire() bool { return f() }
+func (f acquireFunc) Capacity() int { return 1 }
+
+func TestTryAcquire(t *testing.T) {
+	tests := []struct {
+		name           string
+		limiter        Interface
+		wantAcquired   bool
+		wantRetryAfter time.Duration
+	}{
+		{"custom pass", acquireFunc(func() bool { return true }), true, 0},
+		{"custom reject", acquireFunc(func() bool { return false }), false, 0},
+		{"token bucket pass", NewSyncTokenBucket(1, 1), true, 0},
+		{"token bucket reject", NewSyncTokenBucket(0, 1), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acquired, retryAfter := TryAcquire(context.Background(), tt.limiter)
+			if acquired != tt.wantAcquired || retryAfter != tt.wantRetryAfter {
+				t.Errorf("TryAcquire() = %v, %v, want %v, %v", acquired, retryAfter, tt.wantAcquired, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	tb := NewSyncTokenBucket(1, 1)
+	tb.Acquire()
+	acquired, retryAfter := TryAcquire(context.Background(), tb)
+	if acquired || retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("TryAcquire() = %v, %v, want false, (0, 1s]", acquired, retryAfter)
+	}
+
+	// never issue tokens, retry after is unknown
+	tb = NewSyncTokenBucketRate(1, 0)
+	tb.Acquire()
+	acquired, retryAfter = TryAcquire(context.Background(), tb)
+	if acquired || retryAfter != 0 {
+		t.Errorf("TryAcquire() = %v, %v, want false, 0", acquired, retryAfter)
+	}
+}